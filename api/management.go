@@ -0,0 +1,241 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/driver"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/secret"
+	"github.com/yassine/soxy-driver/utils"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//ManagementSocketName the name of the management socket in the driver runtime directory
+const ManagementSocketName = "management.sock"
+
+//ManagementSocket returns the configured management socket path, defaulting to the driver runtime directory
+func ManagementSocket(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	dir, err := utils.RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, ManagementSocketName), nil
+}
+
+//Manager the driver operations exposed by the management API
+type Manager interface {
+	StatusProvider
+	//NetworkIDs returns the ids of the networks, without inspecting them
+	NetworkIDs() []string
+	//NetworkStatus returns the state of a single network
+	NetworkStatus(networkID string) (soxyNetwork.Status, error)
+	Overrides(networkID string) (map[string]string, error)
+	SetOverrides(networkID string, overrides map[string]string) error
+	PatchOverrides(networkID string, patch map[string]*string) error
+	Pause(networkID string, mode soxyNetwork.Mode, keepTunnel bool) error
+	Resume(networkID string) error
+}
+
+//pauseRequest the body of a pause request
+type pauseRequest struct {
+	Mode       string `json:"mode"`
+	KeepTunnel bool   `json:"keepTunnel"`
+}
+
+//ManagementServer serves the token authenticated management API :
+//GET, PUT, PATCH and DELETE /networks/{id}/overrides, POST /networks/{id}/pause and POST /networks/{id}/resume
+type ManagementServer struct {
+	manager Manager
+	token   string
+	server  *http.Server
+	path    string
+}
+
+//NewManagementServer creates a management server authenticating requests with the token read from a given file
+func NewManagementServer(manager Manager, tokenFile string) (*ManagementServer, error) {
+	token, err := secret.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(token) == "" {
+		return nil, fmt.Errorf("the management token file '%s' is empty", tokenFile)
+	}
+	managementServer := &ManagementServer{manager: manager, token: token}
+	managementServer.server = &http.Server{Handler: managementServer.authenticated(managementServer.handler())}
+	return managementServer, nil
+}
+
+//Serve listens on a unix socket at the given path (replacing a stale one) and serves the management API until closed
+func (s *ManagementServer) Serve(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+	s.path = path
+	logrus.Infof("serving the management API on '%s'", path)
+	err = s.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+//Close stops the management server and removes its socket
+func (s *ManagementServer) Close() error {
+	err := s.server.Close()
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+	return err
+}
+
+func (s *ManagementServer) authenticated(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (s *ManagementServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/networks/", func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/networks/")
+		separator := strings.LastIndex(path, "/")
+		if separator < 0 {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		id, resource := path[:separator], path[separator+1:]
+		networkID, err := matchID("network", s.manager.NetworkIDs(), id)
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+		switch resource {
+		case "overrides":
+			s.handleOverrides(w, r, networkID)
+		case "pause", "resume":
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", "POST")
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			if resource == "resume" {
+				s.apply(w, networkID, s.manager.Resume(networkID))
+				return
+			}
+			request := pauseRequest{Mode: string(soxyNetwork.DirectMode)}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid pause request : %v", err))
+				return
+			}
+			mode, err := soxyNetwork.ParseMode(request.Mode)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			s.apply(w, networkID, s.manager.Pause(networkID, mode, request.KeepTunnel))
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	})
+	return mux
+}
+
+func (s *ManagementServer) handleOverrides(w http.ResponseWriter, r *http.Request, networkID string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getOverrides(w, networkID)
+	case http.MethodPut:
+		var overrides map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid overrides : %v", err))
+			return
+		}
+		s.setOverrides(w, networkID, overrides)
+	case http.MethodPatch:
+		//a null value removes an override
+		var patch map[string]*string
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid overrides : %v", err))
+			return
+		}
+		err := s.manager.PatchOverrides(networkID, patch)
+		if err == nil {
+			logrus.Infof("network '%s' overrides updated through the management API", networkID)
+		}
+		s.apply(w, networkID, err)
+	case http.MethodDelete:
+		s.setOverrides(w, networkID, nil)
+	default:
+		w.Header().Set("Allow", "GET, PUT, PATCH, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *ManagementServer) getOverrides(w http.ResponseWriter, networkID string) {
+	overrides, err := s.manager.Overrides(networkID)
+	if err != nil {
+		writeManagementError(w, err)
+		return
+	}
+	for key, value := range overrides {
+		overrides[key] = soxyNetwork.RedactOption(key, value)
+	}
+	writeJSON(w, http.StatusOK, overrides)
+}
+
+func (s *ManagementServer) setOverrides(w http.ResponseWriter, networkID string, overrides map[string]string) {
+	err := s.manager.SetOverrides(networkID, overrides)
+	if err == nil {
+		logrus.Infof("network '%s' overrides updated through the management API", networkID)
+	}
+	s.apply(w, networkID, err)
+}
+
+//apply responds to a management operation with its error or the network resulting status
+func (s *ManagementServer) apply(w http.ResponseWriter, networkID string, err error) {
+	if err != nil {
+		writeManagementError(w, err)
+		return
+	}
+	network, err := s.manager.NetworkStatus(networkID)
+	if err != nil {
+		writeManagementError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, network)
+}
+
+func writeManagementError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case *driver.UnknownNetworkError:
+		writeError(w, http.StatusNotFound, err.Error())
+	case *soxyNetwork.InvalidOptionError, *soxyNetwork.UnknownOptionError, *soxyNetwork.ConflictingOptionsError:
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
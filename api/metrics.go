@@ -0,0 +1,204 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/driver"
+	"github.com/yassine/soxy-driver/supervisor"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//MetricsProvider exposes the driver metrics
+type MetricsProvider interface {
+	Metrics() driver.Metrics
+}
+
+//NameResolver returns the name of a docker network
+type NameResolver func(networkID string) (string, error)
+
+//MetricsServer serves the driver metrics in the Prometheus text format on GET /metrics
+type MetricsServer struct {
+	provider MetricsProvider
+	resolver NameResolver
+	names    map[string]string
+	server   *http.Server
+	sync.Mutex
+}
+
+//NewMetricsServer creates a metrics server exposing the metrics of a given provider, labelling networks with the names
+//returned by the given resolver
+func NewMetricsServer(provider MetricsProvider, resolver NameResolver) *MetricsServer {
+	metricsServer := &MetricsServer{provider: provider, resolver: resolver, names: make(map[string]string)}
+	metricsServer.server = &http.Server{Handler: metricsServer.handler()}
+	return metricsServer
+}
+
+//Serve listens on a given TCP address and serves the metrics until closed
+func (s *MetricsServer) Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("serving the metrics on '%s'", address)
+	err = s.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+//Close stops the metrics server
+func (s *MetricsServer) Close() error {
+	return s.server.Close()
+}
+
+func (s *MetricsServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(s.render(s.provider.Metrics()))
+	}))
+	return mux
+}
+
+//name returns the name of a network, resolved once
+func (s *MetricsServer) name(networkID string) string {
+	s.Lock()
+	defer s.Unlock()
+	if name, ok := s.names[networkID]; ok {
+		return name
+	}
+	if s.resolver == nil {
+		return ""
+	}
+	name, err := s.resolver(networkID)
+	if err != nil {
+		logrus.Debugf("couldn't resolve the name of network '%s' : %v", networkID, err)
+		return ""
+	}
+	s.names[networkID] = name
+	return name
+}
+
+func (s *MetricsServer) render(metrics driver.Metrics) []byte {
+	families := newMetricFamilies()
+
+	families.add("soxy_tor_up", "gauge", "Whether the embedded tor instance is running", nil, boolValue(metrics.Tor.Process.State == supervisor.Running))
+	families.add("soxy_tor_restarts_total", "counter", "The embedded tor instance restarts", nil, float64(metrics.Tor.Process.Restarts))
+	if metrics.TorControl != nil {
+		families.add("soxy_tor_bootstrap_progress", "gauge", "The embedded tor instance bootstrap progress, in percents", nil, float64(metrics.TorControl.BootstrapProgress))
+		families.add("soxy_tor_circuits", "gauge", "The embedded tor instance built circuits", nil, float64(metrics.TorControl.Circuits))
+	}
+
+	live := make(map[string]bool)
+	for _, network := range metrics.Networks {
+		live[network.ID] = true
+		labels := []label{{"network_id", network.ID}, {"network_name", s.name(network.ID)}}
+		families.add("soxy_network_healthy", "gauge", "Whether the network traffic is being tunneled", labels, boolValue(network.Health.Healthy))
+		families.add("soxy_network_kill_switch", "gauge", "Whether the network egress traffic is dropped by the kill-switch", labels, boolValue(network.Health.KillSwitch))
+		families.add("soxy_network_mode", "gauge", "The network mode", append(labels, label{"mode", string(network.Mode.Mode)}), 1)
+		families.add("soxy_network_upstream_up", "gauge", "Whether the network upstream proxy accepts connections", labels, boolValue(network.UpstreamReachable))
+		families.add("soxy_network_tunnel_up", "gauge", "Whether the network tunnel process is running", labels, boolValue(network.Health.Tunnel.State == supervisor.Running))
+		families.add("soxy_network_tunnel_restarts_total", "counter", "The network tunnel process restarts", labels, float64(network.Health.Tunnel.Restarts))
+		families.add("soxy_network_endpoints", "gauge", "The network endpoints", labels, float64(len(network.Endpoints)))
+
+		var redirected, droppedUDP uint64
+		for _, counter := range network.Counters {
+			ruleLabels := append(labels[:2:2], label{"table", counter.Table}, label{"target", counter.Target},
+				label{"protocol", counter.Protocol}, label{"destination", counter.Destination})
+			families.add("soxy_network_rule_packets_total", "counter", "The packets matched by the network rules of the driver chain", ruleLabels, float64(counter.Packets))
+			families.add("soxy_network_rule_bytes_total", "counter", "The bytes matched by the network rules of the driver chain", ruleLabels, float64(counter.Bytes))
+			if counter.Table == "nat" && counter.Target == "REDIRECT" && counter.Protocol == "tcp" {
+				redirected += counter.Packets
+			}
+			if counter.Table == "filter" && counter.Target == "DROP" && counter.Protocol == "udp" {
+				droppedUDP += counter.Packets
+			}
+		}
+		families.add("soxy_network_redirected_connections_total", "counter", "The TCP connections redirected through the network tunnel", labels, float64(redirected))
+		families.add("soxy_network_dropped_udp_packets_total", "counter", "The network UDP packets dropped", labels, float64(droppedUDP))
+	}
+	s.forget(live)
+	return families.render()
+}
+
+//forget drops the names of the networks that don't exist anymore
+func (s *MetricsServer) forget(live map[string]bool) {
+	s.Lock()
+	defer s.Unlock()
+	for networkID := range s.names {
+		if !live[networkID] {
+			delete(s.names, networkID)
+		}
+	}
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+type metricFamily struct {
+	name    string
+	kind    string
+	help    string
+	samples []sample
+}
+
+//metricFamilies the metric families, in the order they are first added, each one being rendered as a whole
+type metricFamilies struct {
+	families []*metricFamily
+	index    map[string]*metricFamily
+}
+
+func newMetricFamilies() *metricFamilies {
+	return &metricFamilies{index: make(map[string]*metricFamily)}
+}
+
+func (f *metricFamilies) add(name string, kind string, help string, labels []label, value float64) {
+	family, ok := f.index[name]
+	if !ok {
+		family = &metricFamily{name: name, kind: kind, help: help}
+		f.index[name] = family
+		f.families = append(f.families, family)
+	}
+	family.samples = append(family.samples, sample{labels, value})
+}
+
+func (f *metricFamilies) render() []byte {
+	var buffer bytes.Buffer
+	for _, family := range f.families {
+		fmt.Fprintf(&buffer, "# HELP %s %s\n# TYPE %s %s\n", family.name, family.help, family.name, family.kind)
+		for _, sample := range family.samples {
+			buffer.WriteString(family.name)
+			if len(sample.labels) > 0 {
+				var labels []string
+				for _, l := range sample.labels {
+					labels = append(labels, fmt.Sprintf("%s=\"%s\"", l.name, labelEscaper.Replace(l.value)))
+				}
+				buffer.WriteString("{" + strings.Join(labels, ",") + "}")
+			}
+			buffer.WriteString(" " + strconv.FormatFloat(sample.value, 'g', -1, 64) + "\n")
+		}
+	}
+	return buffer.Bytes()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/driver"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/tor"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fixtureMetrics struct {
+	metrics driver.Metrics
+}
+
+func (p *fixtureMetrics) Metrics() driver.Metrics {
+	return p.metrics
+}
+
+func TestMetrics(t *testing.T) {
+	provider := &fixtureMetrics{driver.Metrics{
+		Tor:        driver.TorStatus{Process: supervisor.Status{State: supervisor.Running, Restarts: 1}},
+		TorControl: &tor.ControlInfo{BootstrapProgress: 100, Circuits: 4},
+		Networks: []driver.NetworkMetrics{{
+			Status: soxyNetwork.Status{
+				ID:     fixtureNetworkID,
+				Mode:   soxyNetwork.Pause{Mode: soxyNetwork.TunnelMode},
+				Health: soxyNetwork.Health{Healthy: true, Tunnel: supervisor.Status{State: supervisor.Running, Restarts: 2}},
+			},
+			Counters: []soxyNetwork.RuleCounter{
+				{Table: "nat", Target: "REDIRECT", Protocol: "tcp", Destination: "0.0.0.0/0", Packets: 42, Bytes: 2520},
+				{Table: "filter", Target: "DROP", Protocol: "udp", Destination: "0.0.0.0/0", Packets: 3, Bytes: 120},
+			},
+			UpstreamReachable: true,
+		}},
+	}}
+	resolved := 0
+	server := NewMetricsServer(provider, func(networkID string) (string, error) {
+		resolved++
+		return `my "network"`, nil
+	})
+
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		server.handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		body := recorder.Body.String()
+		labels := `network_id="` + fixtureNetworkID + `",network_name="my \"network\""`
+		for _, line := range []string{
+			"soxy_tor_up 1",
+			"soxy_tor_bootstrap_progress 100",
+			"soxy_tor_circuits 4",
+			"soxy_network_healthy{" + labels + "} 1",
+			"soxy_network_tunnel_restarts_total{" + labels + "} 2",
+			"soxy_network_redirected_connections_total{" + labels + "} 42",
+			"soxy_network_dropped_udp_packets_total{" + labels + "} 3",
+			"soxy_network_rule_bytes_total{" + labels + `,table="nat",target="REDIRECT",protocol="tcp",destination="0.0.0.0/0"} 2520`,
+			"# TYPE soxy_network_rule_packets_total counter",
+		} {
+			assert.Contains(t, body, line+"\n")
+		}
+		assert.Equal(t, 1, strings.Count(body, "# TYPE soxy_network_healthy gauge"))
+	}
+	assert.Equal(t, 1, resolved)
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/driver"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//StatusSocketName the name of the status socket in the driver runtime directory
+const StatusSocketName = "status.sock"
+
+//StatusProvider the source of the driver state : the full status checks every iptables rule, the health and the
+//endpoints are served without it
+type StatusProvider interface {
+	Status() driver.Status
+	Healthy() bool
+	Endpoints() []driver.Endpoint
+}
+
+//StatusServer serves the read-only status API : GET /status, /health, /networks and /networks/{id}
+type StatusServer struct {
+	provider StatusProvider
+	server   *http.Server
+	path     string
+}
+
+//StatusSocket returns the status socket path : the configured one, or the one in the driver runtime directory
+func StatusSocket(configured string) (string, error) {
+	if configured != "" {
+		return configured, nil
+	}
+	dir, err := utils.RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, StatusSocketName), nil
+}
+
+//NewStatusServer creates a status server exposing the state of a given provider
+func NewStatusServer(provider StatusProvider) *StatusServer {
+	statusServer := &StatusServer{provider: provider}
+	statusServer.server = &http.Server{Handler: statusServer.handler()}
+	return statusServer
+}
+
+//Serve listens on a unix socket at the given path (replacing a stale one) and serves the status API until closed
+func (s *StatusServer) Serve(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(path, 0660); err != nil {
+		listener.Close()
+		return err
+	}
+	s.path = path
+	logrus.Infof("serving the status API on '%s'", path)
+	err = s.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+//Close stops the status server and removes its socket
+func (s *StatusServer) Close() error {
+	err := s.server.Close()
+	if s.path != "" {
+		os.Remove(s.path)
+	}
+	return err
+}
+
+func (s *StatusServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.Status())
+	}))
+	mux.HandleFunc("/health", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		healthy := s.provider.Healthy()
+		code := http.StatusOK
+		if !healthy {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]bool{"healthy": healthy})
+	}))
+	mux.HandleFunc("/networks", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.Status().Networks)
+	}))
+	mux.HandleFunc("/networks/", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		status := s.provider.Status()
+		ids := make([]string, 0, len(status.Networks))
+		for _, network := range status.Networks {
+			ids = append(ids, network.ID)
+		}
+		id, err := matchID("network", ids, strings.TrimPrefix(r.URL.Path, "/networks/"))
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+		for _, network := range status.Networks {
+			if network.ID == id {
+				writeJSON(w, http.StatusOK, network)
+			}
+		}
+	}))
+	mux.HandleFunc("/endpoints", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.Endpoints())
+	}))
+	mux.HandleFunc("/endpoints/", readOnly(func(w http.ResponseWriter, r *http.Request) {
+		endpoints := s.provider.Endpoints()
+		ids := make([]string, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			ids = append(ids, endpoint.ID)
+		}
+		id, err := matchID("endpoint", ids, strings.TrimPrefix(r.URL.Path, "/endpoints/"))
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+		for _, endpoint := range endpoints {
+			if endpoint.ID == id {
+				writeJSON(w, http.StatusOK, endpoint)
+			}
+		}
+	}))
+	return mux
+}
+
+//CheckHealth queries the health of the driver serving the status API on a given socket
+func CheckHealth(path string) error {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", path)
+			},
+		},
+	}
+	response, err := client.Get("http://soxy-driver/health")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("the driver is unhealthy (%s)", response.Status)
+	}
+	return nil
+}
+
+//notFoundError an id referencing no resource
+type notFoundError struct {
+	kind string
+	id   string
+}
+
+func (e *notFoundError) Error() string {
+	return fmt.Sprintf("%s '%s' not found", e.kind, e.id)
+}
+
+//ambiguousIDError a short id referencing several resources
+type ambiguousIDError struct {
+	kind    string
+	id      string
+	matches []string
+}
+
+func (e *ambiguousIDError) Error() string {
+	return fmt.Sprintf("%s id '%s' is ambiguous, it matches %s", e.kind, e.id, strings.Join(e.matches, ", "))
+}
+
+//matchID returns the id of the resource a given id or short id (as displayed by 'docker network ls') references, a
+//short id has to match a single resource
+func matchID(kind string, ids []string, id string) (string, error) {
+	if id == "" {
+		return "", &notFoundError{kind, id}
+	}
+	var matches []string
+	for _, candidate := range ids {
+		if candidate == id {
+			return candidate, nil
+		}
+		if strings.HasPrefix(candidate, id) {
+			matches = append(matches, candidate)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", &notFoundError{kind, id}
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", &ambiguousIDError{kind, id, matches}
+}
+
+func writeLookupError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*ambiguousIDError); ok {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeError(w, http.StatusNotFound, err.Error())
+}
+
+func readOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	utils.LogIfNotNull(encoder.Encode(value))
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/driver"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fixtureProvider struct {
+	status driver.Status
+	//checks the number of full status requests, each checking every iptables rule
+	checks int
+}
+
+func (p *fixtureProvider) Status() driver.Status {
+	p.checks++
+	return p.status
+}
+
+func (p *fixtureProvider) Healthy() bool {
+	return p.status.Healthy
+}
+
+func (p *fixtureProvider) Endpoints() []driver.Endpoint {
+	return p.status.Endpoints
+}
+
+func fixtureStatus(healthy bool) *fixtureProvider {
+	return &fixtureProvider{status: driver.Status{
+		Healthy: healthy,
+		Networks: []soxyNetwork.Status{
+			{ID: "4f1e0d6a5b2c9e8f7a6b5c4d3e2f1a0b", Bridge: "br-4f1e0d6a5b2c"},
+		},
+		Endpoints: []driver.Endpoint{
+			{ID: "9c1d2e3f4a5b6c7d", NetworkID: "4f1e0d6a5b2c9e8f7a6b5c4d3e2f1a0b", IPv4Address: "172.30.1.2", Joined: true},
+		},
+	}}
+}
+
+func TestStatusRoutes(t *testing.T) {
+	handler := NewStatusServer(fixtureStatus(true)).handler()
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var status driver.Status
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	assert.Equal(t, "br-4f1e0d6a5b2c", status.Networks[0].Bridge)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/networks/4f1e0d6a5b2c", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/networks/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/endpoints/9c1d", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var endpoint driver.Endpoint
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &endpoint))
+	assert.Equal(t, "172.30.1.2", endpoint.IPv4Address)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/endpoints/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
+
+func TestMatchID(t *testing.T) {
+	ids := []string{"4f1e0d6a5b2c9e8f", "4f1e0d6a5b2c0000", "4f1e"}
+
+	id, err := matchID("network", ids, "4f1e0d6a5b2c9")
+	assert.Nil(t, err)
+	assert.Equal(t, "4f1e0d6a5b2c9e8f", id)
+
+	id, err = matchID("network", ids, "4f1e")
+	assert.Nil(t, err)
+	assert.Equal(t, "4f1e", id)
+
+	_, err = matchID("network", ids, "4f1e0d")
+	assert.IsType(t, &ambiguousIDError{}, err)
+	assert.Contains(t, err.Error(), "4f1e0d6a5b2c0000, 4f1e0d6a5b2c9e8f")
+
+	_, err = matchID("network", ids, "9c1d")
+	assert.IsType(t, &notFoundError{}, err)
+	_, err = matchID("network", ids, "")
+	assert.IsType(t, &notFoundError{}, err)
+}
+
+func TestHealth(t *testing.T) {
+	provider := fixtureStatus(false)
+	recorder := httptest.NewRecorder()
+	NewStatusServer(provider).handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, 0, provider.checks)
+}
+
+func TestEndpointsDontCheckTheRules(t *testing.T) {
+	provider := fixtureStatus(true)
+	handler := NewStatusServer(provider).handler()
+	for _, path := range []string{"/endpoints", "/endpoints/9c1d"} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, recorder.Code, path)
+	}
+	assert.Equal(t, 0, provider.checks)
+}
+
+func TestCheckHealthThroughSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy-status")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, StatusSocketName)
+
+	server := NewStatusServer(fixtureStatus(true))
+	go server.Serve(path)
+	defer server.Close()
+
+	for i := 0; i < 50; i++ {
+		if _, err = os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Nil(t, CheckHealth(path))
+}
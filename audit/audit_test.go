@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	records []Record
+	closed  bool
+}
+
+func (s *memorySink) Write(record Record) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestTracker(t *testing.T) {
+	sink := &memorySink{}
+	tracker := NewTracker(sink, "4f1e0d6a5b2c", "proxy:1080", func(address net.IP) string {
+		if address.Equal(net.ParseIP("172.30.1.2")) {
+			return "endpoint-1"
+		}
+		return ""
+	})
+	start := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return start }
+
+	tracker.Line("1525168800.000001 info redsocks.c:1243 redsocks_accept_client(...) [172.30.1.2:45678->93.184.216.34:443]: accepted")
+	tracker.Line("1525168800.000002 info redsocks.c:1243 redsocks_accept_client(...) [172.30.1.3:45679->10.1.1.1:80]: accepted")
+	tracker.Line("1525168800.000003 err redsocks.c:680 redsocks_connect_relay(...) [172.30.1.3:45679->10.1.1.1:80]: red_connect_relay: Connection refused")
+	tracker.Line("unrelated line")
+	tracker.now = func() time.Time { return start.Add(1500 * time.Millisecond) }
+	tracker.Line("1525168801.500000 info redsocks.c:542 redsocks_drop_client(...) [172.30.1.3:45679->10.1.1.1:80]: dropping client @ state: 0")
+
+	assert.Len(t, sink.records, 1)
+	assert.Equal(t, Record{
+		Time:        start,
+		Network:     "4f1e0d6a5b2c",
+		Source:      "172.30.1.3:45679",
+		Destination: "10.1.1.1:80",
+		Upstream:    "proxy:1080",
+		Duration:    1.5,
+		Result:      "red_connect_relay: Connection refused",
+	}, sink.records[0])
+
+	assert.Nil(t, tracker.Close())
+	assert.True(t, sink.closed)
+	assert.Len(t, sink.records, 2)
+	assert.Equal(t, "endpoint-1", sink.records[1].Endpoint)
+	assert.Equal(t, "interrupted", sink.records[1].Result)
+}
+
+func TestTrackerRecord(t *testing.T) {
+	sink := &memorySink{}
+	tracker := NewTracker(sink, "4f1e0d6a5b2c", "proxy:1080", func(address net.IP) string {
+		if address.Equal(net.ParseIP("172.30.1.2")) {
+			return "endpoint-1"
+		}
+		return ""
+	})
+	start := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+	tracker.Record(Record{
+		Time:          start,
+		Source:        "172.30.1.2:45678",
+		Destination:   "93.184.216.34:443",
+		Upstream:      "corp:1080",
+		Host:          "example.com",
+		BytesSent:     517,
+		BytesReceived: 5120,
+		Duration:      1.5,
+		Result:        "closed",
+	})
+	assert.Equal(t, []Record{{
+		Time:          start,
+		Network:       "4f1e0d6a5b2c",
+		Source:        "172.30.1.2:45678",
+		Endpoint:      "endpoint-1",
+		Destination:   "93.184.216.34:443",
+		Upstream:      "corp:1080",
+		Host:          "example.com",
+		BytesSent:     517,
+		BytesReceived: 5120,
+		Duration:      1.5,
+		Result:        "closed",
+	}}, sink.records)
+}
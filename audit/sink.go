@@ -0,0 +1,140 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/yassine/soxy-driver/utils"
+	"log/syslog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//Off connections aren't audited
+	Off = "off"
+	//File audit records are written to a rotating file
+	File = "file"
+	//Syslog audit records are sent to the local syslog socket
+	Syslog = "syslog"
+	//DefaultDir the directory the audit files are written to
+	DefaultDir = "/var/log/soxy-driver"
+	//DefaultMaxSize the size (in MB) above which an audit file is rotated
+	DefaultMaxSize = 10
+	//DefaultMaxFiles the number of rotated audit files kept
+	DefaultMaxFiles = 5
+)
+
+//Modes the available audit modes
+var Modes = []string{Off, File, Syslog}
+
+//Settings where and how audit files are written
+type Settings struct {
+	//Dir the directory the audit files are written to
+	Dir string
+	//MaxSize the size (in MB) above which an audit file is rotated
+	MaxSize int64
+	//MaxFiles the number of rotated audit files kept
+	MaxFiles int
+}
+
+var (
+	settings = Settings{Dir: DefaultDir, MaxSize: DefaultMaxSize, MaxFiles: DefaultMaxFiles}
+	lock     sync.RWMutex
+)
+
+//Configure sets where and how the audit files of the networks opened from now on are written
+func Configure(value Settings) {
+	lock.Lock()
+	defer lock.Unlock()
+	settings = value
+}
+
+//Record a tunneled connection
+type Record struct {
+	//Time when the connection was accepted
+	Time time.Time `json:"time"`
+	//Network the network id
+	Network string `json:"network"`
+	//Source the client address
+	Source string `json:"source"`
+	//Endpoint the id of the client endpoint, if known
+	Endpoint string `json:"endpoint,omitempty"`
+	//Destination the original destination address
+	Destination string `json:"destination"`
+	//Upstream the proxy the connection was tunneled through
+	Upstream string `json:"upstream"`
+	//Host the host the client reached, as named by its TLS server name indication or its HTTP Host header (shared
+	//tunnel only)
+	Host string `json:"host,omitempty"`
+	//BytesSent the number of bytes sent by the client (shared tunnel only)
+	BytesSent int64 `json:"bytesSent,omitempty"`
+	//BytesReceived the number of bytes received by the client (shared tunnel only)
+	BytesReceived int64 `json:"bytesReceived,omitempty"`
+	//Duration the connection duration, in seconds
+	Duration float64 `json:"duration"`
+	//Result how the connection ended
+	Result string `json:"result"`
+}
+
+//Sink an audit records destination
+type Sink interface {
+	Write(record Record) error
+	Close() error
+}
+
+//Open opens the sink of a given audit mode for a given network
+func Open(mode string, networkID string) (Sink, error) {
+	switch mode {
+	case File:
+		lock.RLock()
+		current := settings
+		lock.RUnlock()
+		file, err := utils.NewRotatingFile(filepath.Join(current.Dir, networkID+".jsonl"), current.MaxSize*1024*1024, current.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		return &fileSink{file}, nil
+	case Syslog:
+		writer, err := syslog.Dial("", "", syslog.LOG_INFO|syslog.LOG_DAEMON, "soxy-driver")
+		if err != nil {
+			return nil, fmt.Errorf("couldn't connect to the local syslog socket : %v", err)
+		}
+		return &syslogSink{writer}, nil
+	}
+	return nil, fmt.Errorf("unknown audit mode '%s' (available choices : %s)", mode, strings.Join(Modes, ", "))
+}
+
+type fileSink struct {
+	file *utils.RotatingFile
+}
+
+func (s *fileSink) Write(record Record) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(content, '\n'))
+	return err
+}
+
+func (s *fileSink) Close() error {
+	return s.file.Close()
+}
+
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func (s *syslogSink) Write(record Record) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(content))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"github.com/sirupsen/logrus"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+//redsocksLine matches the redsocks log lines about a client connection :
+//<timestamp> <level> <file>:<line> <function>(...) [<client>-><destination>]: <message>
+var redsocksLine = regexp.MustCompile(`^\S+ (\w+) .*\[([^\]]+)->([^\]]+)\]: (.+)$`)
+
+//Tracker follows the connections of a network tunnel through the redsocks log, or is handed the ones of the shared
+//tunnel, and writes an audit record per connection
+type Tracker struct {
+	sink     Sink
+	network  string
+	upstream string
+	//endpoint returns the id of the endpoint a client address belongs to
+	endpoint    func(address net.IP) string
+	connections map[string]*Record
+	now         func() time.Time
+	sync.Mutex
+}
+
+//NewTracker creates a tracker writing the records of a given network to a given sink
+func NewTracker(sink Sink, network string, upstream string, endpoint func(address net.IP) string) *Tracker {
+	return &Tracker{
+		sink:        sink,
+		network:     network,
+		upstream:    upstream,
+		endpoint:    endpoint,
+		connections: make(map[string]*Record),
+		now:         time.Now,
+	}
+}
+
+//Line handles a redsocks log line
+func (t *Tracker) Line(line string) {
+	match := redsocksLine.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	level, source, destination, message := match[1], match[2], match[3], match[4]
+	t.Lock()
+	defer t.Unlock()
+	switch {
+	case message == "accepted":
+		record := &Record{
+			Time:        t.now(),
+			Network:     t.network,
+			Source:      source,
+			Destination: destination,
+			Upstream:    t.upstream,
+			Result:      "closed",
+		}
+		if host, _, err := net.SplitHostPort(source); err == nil && t.endpoint != nil {
+			record.Endpoint = t.endpoint(net.ParseIP(host))
+		}
+		t.connections[source] = record
+	case strings.HasPrefix(message, "dropping client"):
+		if record, ok := t.connections[source]; ok {
+			delete(t.connections, source)
+			t.write(record)
+		}
+	case level == "err" || level == "warning" || level == "notice":
+		//the last error reported about a connection is its result
+		if record, ok := t.connections[source]; ok {
+			record.Result = message
+		}
+	}
+}
+
+//Record writes the record of a closed connection the tunnel reported itself, its network and endpoint are filled in
+func (t *Tracker) Record(record Record) {
+	t.Lock()
+	defer t.Unlock()
+	record.Network = t.network
+	if host, _, err := net.SplitHostPort(record.Source); err == nil && t.endpoint != nil {
+		record.Endpoint = t.endpoint(net.ParseIP(host))
+	}
+	if err := t.sink.Write(record); err != nil {
+		logrus.Errorf("couldn't write the audit record of network '%s' : %v", t.network, err)
+	}
+}
+
+//Close writes the records of the connections still open, which are interrupted, and closes the sink
+func (t *Tracker) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	for source, record := range t.connections {
+		record.Result = "interrupted"
+		t.write(record)
+		delete(t.connections, source)
+	}
+	return t.sink.Close()
+}
+
+func (t *Tracker) write(record *Record) {
+	record.Duration = t.now().Sub(record.Time).Seconds()
+	if err := t.sink.Write(*record); err != nil {
+		logrus.Errorf("couldn't write the audit record of network '%s' : %v", t.network, err)
+	}
+}
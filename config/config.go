@@ -0,0 +1,366 @@
+package config
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/audit"
+	"github.com/yassine/soxy-driver/secret"
+	"github.com/yassine/soxy-driver/state"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/tunnel"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	//FileEnv the environment variable overriding the driver configuration file path
+	FileEnv = "SOXY_CONFIG_FILE"
+	//DefaultFile the default driver configuration file path
+	DefaultFile = "/etc/soxy-driver/config.yml"
+	//DefaultDockerSocket the default docker daemon socket
+	DefaultDockerSocket = "unix:///var/run/docker.sock"
+)
+
+var (
+	//DefaultLocalAddresses the reserved local addresses that are never tunneled by default
+	DefaultLocalAddresses = []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+	}
+)
+
+//Bridge the options the libnetwork bridge driver is initialized with
+type Bridge struct {
+	EnableIPForwarding  bool `yaml:"enableIPForwarding"`
+	EnableIPTables      bool `yaml:"enableIPTables"`
+	EnableUserlandProxy bool `yaml:"enableUserlandProxy"`
+}
+
+//Audit where and how the network connections audit files are written
+type Audit struct {
+	//Dir the directory the audit files are written to
+	Dir string `yaml:"dir"`
+	//MaxSize the size (in MB) above which an audit file is rotated
+	MaxSize int64 `yaml:"maxSize"`
+	//MaxFiles the number of rotated audit files kept
+	MaxFiles int `yaml:"maxFiles"`
+}
+
+//ProcessLogs how the output of the driver child processes (tor and the tunnels) is logged
+type ProcessLogs struct {
+	//Level the level the child processes output lines are logged at
+	Level string `yaml:"level"`
+	//Dir the directory the output of each child process is also written to (<network id>.log, tor.log), disabled if empty
+	Dir string `yaml:"dir"`
+	//MaxSize the size (in MB) above which a log file is rotated
+	MaxSize int64 `yaml:"maxSize"`
+	//MaxFiles the number of rotated log files kept
+	MaxFiles int `yaml:"maxFiles"`
+}
+
+//Secrets where the proxy password references of the networks may point to
+type Secrets struct {
+	//FileDir the directory the proxy password files have to be in, the file references are rejected if empty
+	FileDir string `yaml:"fileDir"`
+	//EnvPrefix the prefix the proxy password environment variables have to start with, the env references are rejected
+	//if empty
+	EnvPrefix string `yaml:"envPrefix"`
+}
+
+//ExecBackend what the networks using the exec tunnel backend may run
+type ExecBackend struct {
+	//Binaries the absolute paths of the binaries the networks may run, the exec backend is disabled if empty
+	Binaries []string `yaml:"binaries"`
+	//TemplateDir the directory the configuration templates have to be in, the templates are rejected if empty
+	TemplateDir string `yaml:"templateDir"`
+}
+
+//SharedTunnel the transparent proxy owned by the driver and shared by the networks
+type SharedTunnel struct {
+	//Enabled whether the networks that don't pick a tunnel backend are tunneled through the shared tunnel rather than
+	//through one process each
+	Enabled bool `yaml:"enabled"`
+	//BindAddress the address the shared tunnel listens on, the loopback and the networks gateways by default
+	BindAddress string `yaml:"bindAddress"`
+	//Port the port the shared tunnel listens on, a random available one by default
+	Port int64 `yaml:"port"`
+}
+
+//Configuration the driver configuration
+type Configuration struct {
+	//LogLevel the driver log level
+	LogLevel string `yaml:"logLevel"`
+	//LogFormat the driver log format : text or json
+	LogFormat string `yaml:"logFormat"`
+	//ProcessLogs how the output of the child processes is logged
+	ProcessLogs ProcessLogs `yaml:"processLogs"`
+	//DockerSocket the docker daemon socket
+	DockerSocket string `yaml:"dockerSocket"`
+	//Namespace the driver namespace, allowing multiple driver instances on a given host
+	Namespace string `yaml:"namespace"`
+	//Bridge the libnetwork bridge driver options
+	Bridge Bridge `yaml:"bridge"`
+	//LocalAddresses the destinations that are never tunneled
+	LocalAddresses []string `yaml:"localAddresses"`
+	//TorTemplate the path of a go template rendered as the embedded tor instance configuration
+	TorTemplate string `yaml:"torTemplate"`
+	//ProfilesFile the path of a file defining additional profiles
+	ProfilesFile string `yaml:"profilesFile"`
+	//Profiles the named proxy profiles
+	Profiles map[string]*Profile `yaml:"profiles"`
+	//StatusSocket the path of the status API unix socket, in the driver runtime directory by default
+	StatusSocket string `yaml:"statusSocket"`
+	//ManagementSocket the path of the management API unix socket, in the driver runtime directory by default
+	ManagementSocket string `yaml:"managementSocket"`
+	//ManagementTokenFile the path of a file containing the management API token, the management API is disabled without it
+	ManagementTokenFile string `yaml:"managementTokenFile"`
+	//MetricsAddress the TCP address the Prometheus metrics are served on, the metrics are disabled without it
+	MetricsAddress string `yaml:"metricsAddress"`
+	//Audit the network connections audit files settings
+	Audit Audit `yaml:"audit"`
+	//Secrets where the proxy password references may point to
+	Secrets Secrets `yaml:"secrets"`
+	//ExecBackend what the exec tunnel backend may run
+	ExecBackend ExecBackend `yaml:"execBackend"`
+	//StateDir the directory where the state that has to survive the driver restarts is persisted
+	StateDir string `yaml:"stateDir"`
+	//TunnelIdleTimeout for how long the tunnels (and tor) keep running once no container relies on them (e.g. 5m), they
+	//are started on the first container join when set, as soon as the networks are created (and the driver started) otherwise
+	TunnelIdleTimeout string `yaml:"tunnelIdleTimeout"`
+	//SharedTunnel the transparent proxy shared by the networks
+	SharedTunnel SharedTunnel `yaml:"sharedTunnel"`
+	//torTemplate the content of the tor configuration template
+	torTemplate string
+	//idleTimeout the parsed tunnel idle timeout
+	idleTimeout time.Duration
+}
+
+//File returns the driver configuration file path
+func File() string {
+	if path := os.Getenv(FileEnv); path != "" {
+		return path
+	}
+	return DefaultFile
+}
+
+//Default returns the driver default configuration
+func Default() *Configuration {
+	return &Configuration{
+		LogLevel:     "debug",
+		LogFormat:    "text",
+		ProcessLogs: ProcessLogs{
+			Level:    "info",
+			MaxSize:  10,
+			MaxFiles: 5,
+		},
+		DockerSocket: DefaultDockerSocket,
+		Bridge: Bridge{
+			EnableIPForwarding:  true,
+			EnableIPTables:      true,
+			EnableUserlandProxy: false,
+		},
+		LocalAddresses: DefaultLocalAddresses,
+		ProfilesFile:   DefaultProfilesFile,
+		Profiles:       map[string]*Profile{},
+		StateDir:       state.DefaultDir,
+		Audit: Audit{
+			Dir:      audit.DefaultDir,
+			MaxSize:  audit.DefaultMaxSize,
+			MaxFiles: audit.DefaultMaxFiles,
+		},
+	}
+}
+
+//Load loads the driver configuration : defaults, overridden by the configuration file (if any), overridden by the environment
+func Load(path string) (*Configuration, error) {
+	configuration := Default()
+	content, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = yaml.UnmarshalStrict(content, configuration); err != nil {
+			return nil, fmt.Errorf("invalid configuration file '%s' : %v", path, err)
+		}
+	} else {
+		logrus.Debugf("configuration file '%s' not found, using defaults", path)
+	}
+	configuration.applyEnv()
+	if err = configuration.load(); err != nil {
+		return nil, fmt.Errorf("invalid configuration : %v", err)
+	}
+	return configuration, nil
+}
+
+//Level returns the configured log level
+func (configuration *Configuration) Level() logrus.Level {
+	level, _ := logrus.ParseLevel(configuration.LogLevel)
+	return level
+}
+
+//Formatter returns the configured log formatter
+func (configuration *Configuration) Formatter() logrus.Formatter {
+	if configuration.LogFormat == "json" {
+		return &logrus.JSONFormatter{}
+	}
+	return &logrus.TextFormatter{}
+}
+
+//ProcessLogSettings returns how the output of the child processes is logged
+func (configuration *Configuration) ProcessLogSettings() supervisor.LogSettings {
+	level, _ := logrus.ParseLevel(configuration.ProcessLogs.Level)
+	return supervisor.LogSettings{
+		Level:    level,
+		Dir:      configuration.ProcessLogs.Dir,
+		MaxSize:  configuration.ProcessLogs.MaxSize,
+		MaxFiles: configuration.ProcessLogs.MaxFiles,
+	}
+}
+
+//TorConfigurationTemplate returns the custom tor configuration template, empty if the default one applies
+func (configuration *Configuration) TorConfigurationTemplate() string {
+	return configuration.torTemplate
+}
+
+//IdleTimeout returns for how long the tunnels keep running once no container relies on them, 0 if they are never stopped
+//for being idle
+func (configuration *Configuration) IdleTimeout() time.Duration {
+	return configuration.idleTimeout
+}
+
+//AuditSettings returns the network connections audit files settings
+func (configuration *Configuration) AuditSettings() audit.Settings {
+	return audit.Settings{Dir: configuration.Audit.Dir, MaxSize: configuration.Audit.MaxSize, MaxFiles: configuration.Audit.MaxFiles}
+}
+
+//SecretSettings returns where the proxy password references may point to
+func (configuration *Configuration) SecretSettings() secret.Settings {
+	return secret.Settings{FileDir: configuration.Secrets.FileDir, EnvPrefix: configuration.Secrets.EnvPrefix}
+}
+
+//ExecSettings returns what the exec tunnel backend may run
+func (configuration *Configuration) ExecSettings() tunnel.ExecSettings {
+	return tunnel.ExecSettings{Binaries: configuration.ExecBackend.Binaries, TemplateDir: configuration.ExecBackend.TemplateDir}
+}
+
+func (configuration *Configuration) applyEnv() {
+	overrides := []struct {
+		env   string
+		value *string
+	}{
+		{"SOXY_LOG_LEVEL", &configuration.LogLevel},
+		{"SOXY_LOG_FORMAT", &configuration.LogFormat},
+		{"SOXY_PROCESS_LOG_DIR", &configuration.ProcessLogs.Dir},
+		{"SOXY_DOCKER_SOCKET", &configuration.DockerSocket},
+		{"DRIVER_NAMESPACE", &configuration.Namespace},
+		{"SOXY_TOR_TEMPLATE", &configuration.TorTemplate},
+		{ProfilesFileEnv, &configuration.ProfilesFile},
+		{"SOXY_STATUS_SOCKET", &configuration.StatusSocket},
+		{"SOXY_MANAGEMENT_SOCKET", &configuration.ManagementSocket},
+		{"SOXY_MANAGEMENT_TOKEN_FILE", &configuration.ManagementTokenFile},
+		{"SOXY_METRICS_ADDRESS", &configuration.MetricsAddress},
+		{state.DirEnv, &configuration.StateDir},
+		{"SOXY_AUDIT_DIR", &configuration.Audit.Dir},
+		{"SOXY_SECRET_FILES_DIR", &configuration.Secrets.FileDir},
+		{"SOXY_SECRET_ENV_PREFIX", &configuration.Secrets.EnvPrefix},
+		{"SOXY_TUNNEL_IDLE_TIMEOUT", &configuration.TunnelIdleTimeout},
+	}
+	for _, override := range overrides {
+		if value, ok := os.LookupEnv(override.env); ok {
+			*override.value = value
+		}
+	}
+	configuration.Namespace = strings.TrimSpace(configuration.Namespace)
+}
+
+// load validates the configuration and loads the files it references
+func (configuration *Configuration) load() error {
+	if _, err := logrus.ParseLevel(configuration.LogLevel); err != nil {
+		return fmt.Errorf("invalid log level '%s'", configuration.LogLevel)
+	}
+	if configuration.LogFormat != "text" && configuration.LogFormat != "json" {
+		return fmt.Errorf("invalid log format '%s' (available choices : text, json)", configuration.LogFormat)
+	}
+	if _, err := logrus.ParseLevel(configuration.ProcessLogs.Level); err != nil {
+		return fmt.Errorf("invalid process log level '%s'", configuration.ProcessLogs.Level)
+	}
+	if configuration.ProcessLogs.MaxSize < 1 || configuration.ProcessLogs.MaxFiles < 0 {
+		return fmt.Errorf("invalid process logs settings : a positive max size and a non-negative max files count are expected")
+	}
+	if configuration.DockerSocket == "" {
+		return fmt.Errorf("the docker socket is mandatory")
+	}
+	if configuration.StateDir == "" {
+		return fmt.Errorf("the state directory is mandatory")
+	}
+	if configuration.Audit.Dir == "" || configuration.Audit.MaxSize < 1 || configuration.Audit.MaxFiles < 0 {
+		return fmt.Errorf("invalid audit settings : a directory, a positive max size and a non-negative max files count are expected")
+	}
+	if configuration.TunnelIdleTimeout != "" {
+		timeout, err := time.ParseDuration(strings.TrimSpace(configuration.TunnelIdleTimeout))
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("invalid tunnel idle timeout '%s' : a non-negative duration (e.g. 5m) is expected", configuration.TunnelIdleTimeout)
+		}
+		configuration.idleTimeout = timeout
+	}
+	if configuration.SharedTunnel.Port < 0 || configuration.SharedTunnel.Port > 65535 {
+		return fmt.Errorf("invalid shared tunnel port '%d'", configuration.SharedTunnel.Port)
+	}
+	if address := configuration.SharedTunnel.BindAddress; address != "" && (net.ParseIP(address) == nil || net.ParseIP(address).To4() == nil) {
+		return fmt.Errorf("invalid shared tunnel bind address '%s' : an ipv4 address is expected", address)
+	}
+	for _, binary := range configuration.ExecBackend.Binaries {
+		if !filepath.IsAbs(binary) {
+			return fmt.Errorf("invalid exec backend binary '%s' : an absolute path is expected", binary)
+		}
+	}
+	if dir := configuration.ExecBackend.TemplateDir; dir != "" && !filepath.IsAbs(dir) {
+		return fmt.Errorf("invalid exec backend template directory '%s' : an absolute path is expected", dir)
+	}
+	for _, address := range configuration.LocalAddresses {
+		if _, _, err := net.ParseCIDR(address); err != nil {
+			return fmt.Errorf("invalid local address '%s'", address)
+		}
+	}
+	if configuration.TorTemplate != "" {
+		content, err := ioutil.ReadFile(configuration.TorTemplate)
+		if err != nil {
+			return fmt.Errorf("couldn't read the tor configuration template : %v", err)
+		}
+		if _, err = template.New("tor").Parse(string(content)); err != nil {
+			return fmt.Errorf("invalid tor configuration template : %v", err)
+		}
+		configuration.torTemplate = string(content)
+	}
+	if configuration.Profiles == nil {
+		configuration.Profiles = map[string]*Profile{}
+	}
+	if err := ValidateProfiles(configuration.Profiles); err != nil {
+		return err
+	}
+	if configuration.ProfilesFile != "" {
+		profiles, err := LoadProfiles(configuration.ProfilesFile)
+		if err != nil {
+			return err
+		}
+		for name, profile := range profiles {
+			if _, ok := configuration.Profiles[name]; ok {
+				return fmt.Errorf("profile '%s' is defined more than once", name)
+			}
+			configuration.Profiles[name] = profile
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	configuration, err := Load("/nonexistent/config.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultDockerSocket, configuration.DockerSocket)
+	assert.Equal(t, DefaultLocalAddresses, configuration.LocalAddresses)
+	assert.True(t, configuration.Bridge.EnableIPTables)
+	assert.Equal(t, logrus.DebugLevel, configuration.Level())
+}
+
+func TestLoadEnvironmentTakesPrecedence(t *testing.T) {
+	path := fixtureFile(t, "logLevel: warn\ndockerSocket: unix:///tmp/docker.sock\nprofilesFile: ''\n")
+	defer os.Remove(path)
+	os.Setenv("SOXY_LOG_LEVEL", "error")
+	defer os.Unsetenv("SOXY_LOG_LEVEL")
+	configuration, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "unix:///tmp/docker.sock", configuration.DockerSocket)
+	assert.Equal(t, logrus.ErrorLevel, configuration.Level())
+}
+
+func TestLoadTunnelIdleTimeout(t *testing.T) {
+	configuration, err := Load("/nonexistent/config.yml")
+	assert.Nil(t, err)
+	assert.Zero(t, configuration.IdleTimeout())
+
+	path := fixtureFile(t, "tunnelIdleTimeout: 5m\nprofilesFile: ''\n")
+	defer os.Remove(path)
+	configuration, err = Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Minute, configuration.IdleTimeout())
+}
+
+func TestLoadSecrets(t *testing.T) {
+	path := fixtureFile(t, "secrets:\n  fileDir: /etc/soxy-driver/secrets\nprofilesFile: ''\n")
+	defer os.Remove(path)
+	os.Setenv("SOXY_SECRET_ENV_PREFIX", "SOXY_PROXY_PASSWORD_")
+	defer os.Unsetenv("SOXY_SECRET_ENV_PREFIX")
+	configuration, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "/etc/soxy-driver/secrets", configuration.SecretSettings().FileDir)
+	assert.Equal(t, "SOXY_PROXY_PASSWORD_", configuration.SecretSettings().EnvPrefix)
+}
+
+func TestLoadRejectsInvalidConfiguration(t *testing.T) {
+	invalid := []string{
+		"logLevel: verbose\n",
+		"localAddresses: [nowhere]\n",
+		"torTemplate: /nonexistent/torrc\n",
+		"unknown: true\n",
+		"logFormat: xml\n",
+		"processLogs:\n  level: loud\n",
+		"tunnelIdleTimeout: soon\n",
+		"tunnelIdleTimeout: -1m\n",
+		"sharedTunnel:\n  port: 70000\n",
+		"sharedTunnel:\n  bindAddress: localhost\n",
+		"execBackend:\n  binaries: [gost]\n",
+		"execBackend:\n  templateDir: templates\n",
+	}
+	for _, content := range invalid {
+		path := fixtureFile(t, content)
+		_, err := Load(path)
+		os.Remove(path)
+		assert.NotNil(t, err, "configuration %q should be rejected", content)
+	}
+}
@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	//ProfileOption the network option referencing a profile
+	ProfileOption = "soxy.profile"
+	//proxyURLOption the network option defining the whole upstream as an url
+	proxyURLOption = "soxy.proxy"
+	//ProfilesFileEnv the environment variable overriding the profiles file path
+	ProfilesFileEnv = "SOXY_PROFILES_FILE"
+	//DefaultProfilesFile the default profiles file path
+	DefaultProfilesFile = "/etc/soxy-driver/profiles.yml"
+)
+
+//Upstream a proxy a profile tunnels traffic through
+type Upstream struct {
+	Address string `yaml:"address"`
+	Port    int64  `yaml:"port"`
+}
+
+//Profile a named proxy configuration that networks reference through the 'soxy.profile' option
+type Profile struct {
+	//Upstreams the proxies, in order of preference : the first reachable one is used whenever a network tunnel starts
+	Upstreams []Upstream `yaml:"upstreams"`
+	//Type the proxy type
+	Type string `yaml:"type"`
+	//User the proxy user
+	User string `yaml:"user"`
+	//Password the proxy password, prefer one of the password references
+	Password string `yaml:"password"`
+	//PasswordFile a file containing the proxy password
+	PasswordFile string `yaml:"passwordFile"`
+	//PasswordEnv a driver environment variable containing the proxy password
+	PasswordEnv string `yaml:"passwordEnv"`
+	//PasswordSecret a secret store entry containing the proxy password
+	PasswordSecret string `yaml:"passwordSecret"`
+	//DNS the dns mode (tor or direct)
+	DNS string `yaml:"dns"`
+	//Bypass the destinations (CIDRs) that aren't tunneled
+	Bypass []string `yaml:"bypass"`
+	//BlockUDP whether outgoing UDP traffic is blocked
+	BlockUDP *bool `yaml:"blockUDP"`
+}
+
+var upstreamKeys = []string{"soxy.proxyaddress", "soxy.proxyport", "soxy.proxyupstreams", "soxy.proxytype", "soxy.proxyuser"}
+
+var passwordKeys = []string{"soxy.proxypassword", "soxy.proxypassword.file", "soxy.proxypassword.env", "soxy.proxypassword.secret"}
+
+//proxyKeys the network options picking another proxy than the profile one, the profile credentials are withheld from it
+var proxyKeys = []string{proxyURLOption, "soxy.proxyaddress", "soxy.proxyport", "soxy.proxyupstreams", "soxy.proxytype"}
+
+type profilesFile struct {
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+//LoadProfiles loads and validates the named profiles defined in a given file, a missing file defines no profile
+func LoadProfiles(path string) (map[string]*Profile, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		logrus.Debugf("profiles file '%s' not found, no profile defined", path)
+		return map[string]*Profile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	parsed := &profilesFile{}
+	if err = yaml.UnmarshalStrict(content, parsed); err != nil {
+		return nil, fmt.Errorf("invalid profiles file '%s' : %v", path, err)
+	}
+	if parsed.Profiles == nil {
+		parsed.Profiles = map[string]*Profile{}
+	}
+	if err = ValidateProfiles(parsed.Profiles); err != nil {
+		return nil, fmt.Errorf("invalid profiles file '%s' : %v", path, err)
+	}
+	return parsed.Profiles, nil
+}
+
+//ValidateProfiles checks the given profiles definitions
+func ValidateProfiles(profiles map[string]*Profile) error {
+	for name, profile := range profiles {
+		if profile == nil {
+			return fmt.Errorf("profile '%s' is empty", name)
+		}
+		if len(profile.Upstreams) == 0 {
+			return fmt.Errorf("profile '%s' has no upstream", name)
+		}
+		for _, upstream := range profile.Upstreams {
+			if upstream.Address == "" || upstream.Port < 1 || upstream.Port > 65535 {
+				return fmt.Errorf("profile '%s' has an invalid upstream '%s:%d'", name, upstream.Address, upstream.Port)
+			}
+		}
+		references := 0
+		for _, reference := range []string{profile.Password, profile.PasswordFile, profile.PasswordEnv, profile.PasswordSecret} {
+			if reference != "" {
+				references++
+			}
+		}
+		if references > 1 {
+			return fmt.Errorf("profile '%s' defines more than one password", name)
+		}
+		for _, cidr := range profile.Bypass {
+			if !validIPv4Destination(cidr) {
+				return fmt.Errorf("profile '%s' has an invalid bypass destination '%s', an ipv4 address or network is expected", name, cidr)
+			}
+		}
+	}
+	return nil
+}
+
+//Params returns the network options a profile stands for : they only depend on the profile, the network tunnel picks
+//the first reachable of its upstreams whenever it starts
+func (profile *Profile) Params() map[string]string {
+	params := make(map[string]string)
+	params["soxy.proxyaddress"] = profile.Upstreams[0].Address
+	params["soxy.proxyport"] = strconv.FormatInt(profile.Upstreams[0].Port, 10)
+	if len(profile.Upstreams) > 1 {
+		upstreams := make([]string, 0, len(profile.Upstreams))
+		for _, upstream := range profile.Upstreams {
+			upstreams = append(upstreams, net.JoinHostPort(upstream.Address, strconv.FormatInt(upstream.Port, 10)))
+		}
+		params["soxy.proxyupstreams"] = strings.Join(upstreams, ",")
+	}
+	setIfNotEmpty(params, "soxy.proxytype", profile.Type)
+	setIfNotEmpty(params, "soxy.proxyuser", profile.User)
+	setIfNotEmpty(params, "soxy.proxypassword", profile.Password)
+	setIfNotEmpty(params, "soxy.proxypassword.file", profile.PasswordFile)
+	setIfNotEmpty(params, "soxy.proxypassword.env", profile.PasswordEnv)
+	setIfNotEmpty(params, "soxy.proxypassword.secret", profile.PasswordSecret)
+	setIfNotEmpty(params, "soxy.dns", profile.DNS)
+	setIfNotEmpty(params, "soxy.bypass", strings.Join(profile.Bypass, ","))
+	if profile.BlockUDP != nil {
+		params["soxy.blockUDP"] = strconv.FormatBool(*profile.BlockUDP)
+	}
+	return params
+}
+
+//Apply returns the given network options completed with the profile ones, the network options taking precedence. The
+//profile credentials only apply to the profile proxy : they are dropped once the network options pick another one
+func (profile *Profile) Apply(options map[string]string) map[string]string {
+	params := profile.Params()
+	if _, ok := options[proxyURLOption]; ok {
+		for _, key := range upstreamKeys {
+			delete(params, key)
+		}
+	}
+	for _, key := range proxyKeys {
+		if _, ok := options[key]; ok {
+			delete(params, "soxy.proxyupstreams")
+			delete(params, "soxy.proxyuser")
+			for _, passwordKey := range passwordKeys {
+				delete(params, passwordKey)
+			}
+		}
+	}
+	for key := range options {
+		if strings.HasPrefix(key, "soxy.proxypassword") {
+			for _, passwordKey := range passwordKeys {
+				delete(params, passwordKey)
+			}
+		}
+	}
+	for key, value := range options {
+		params[key] = value
+	}
+	return params
+}
+
+//validIPv4Destination whether a bypass destination is an ipv4 address or network, as the networks accept them
+func validIPv4Destination(destination string) bool {
+	destination = strings.TrimSpace(destination)
+	if !strings.Contains(destination, "/") {
+		destination += "/32"
+	}
+	_, network, err := net.ParseCIDR(destination)
+	return err == nil && network.IP.To4() != nil
+}
+
+func setIfNotEmpty(params map[string]string, key string, value string) {
+	if value != "" {
+		params[key] = value
+	}
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+const fixtureProfiles = `
+profiles:
+  corp-eu:
+    upstreams:
+      - address: proxy.eu.corp
+        port: 3128
+    type: http-connect
+    user: team
+    passwordFile: /run/secrets/corp-eu
+    dns: direct
+    bypass:
+      - 10.20.0.0/16
+      - 192.168.1.1
+`
+
+func TestLoadProfiles(t *testing.T) {
+	path := fixtureFile(t, fixtureProfiles)
+	defer os.Remove(path)
+	profiles, err := LoadProfiles(path)
+	assert.Nil(t, err)
+	assert.Contains(t, profiles, "corp-eu")
+
+	params := profiles["corp-eu"].Params()
+	assert.Equal(t, "proxy.eu.corp", params["soxy.proxyaddress"])
+	assert.Equal(t, "3128", params["soxy.proxyport"])
+	assert.Equal(t, "http-connect", params["soxy.proxytype"])
+	assert.Equal(t, "/run/secrets/corp-eu", params["soxy.proxypassword.file"])
+	assert.Equal(t, "10.20.0.0/16,192.168.1.1", params["soxy.bypass"])
+}
+
+func TestParamsDontDependOnTheUpstreamsReachability(t *testing.T) {
+	profile := &Profile{Upstreams: []Upstream{{"proxy1.corp", 3128}, {"proxy2.corp", 3128}}}
+	params := profile.Params()
+	assert.Equal(t, "proxy1.corp", params["soxy.proxyaddress"])
+	assert.Equal(t, "3128", params["soxy.proxyport"])
+	assert.Equal(t, "proxy1.corp:3128,proxy2.corp:3128", params["soxy.proxyupstreams"])
+	assert.Equal(t, params, profile.Params())
+
+	//a network picking its own proxy doesn't inherit the profile upstreams
+	params = profile.Apply(map[string]string{ProfileOption: "corp", "soxy.proxyaddress": "proxy3.corp"})
+	assert.NotContains(t, params, "soxy.proxyupstreams")
+	assert.NotContains(t, (&Profile{Upstreams: []Upstream{{"proxy1.corp", 3128}}}).Params(), "soxy.proxyupstreams")
+}
+
+func TestLoadProfilesMissingFile(t *testing.T) {
+	profiles, err := LoadProfiles("/nonexistent/profiles.yml")
+	assert.Nil(t, err)
+	assert.Empty(t, profiles)
+}
+
+func TestLoadProfilesRejectsInvalidDefinitions(t *testing.T) {
+	invalid := []string{
+		"profiles:\n  empty:\n    type: socks5\n",
+		"profiles:\n  p:\n    upstreams:\n      - address: proxy\n        port: 0\n",
+		"profiles:\n  p:\n    upstreams:\n      - address: proxy\n        port: 1080\n    password: a\n    passwordEnv: B\n",
+		"profiles:\n  p:\n    upstreams:\n      - address: proxy\n        port: 1080\n    bypass: [nowhere]\n",
+		"profiles:\n  p:\n    upstreams:\n      - address: proxy\n        port: 1080\n    bypass: [\"fd00::/8\"]\n",
+		"profiles:\n  p:\n    upstreams:\n      - address: proxy\n        port: 1080\n    bypass: [\"::1\"]\n",
+		"profiles:\n  p:\n    upstreams:\n      - address: proxy\n        port: 1080\n    bypass: [\"\"]\n",
+		"profiles:\n  p:\n    upstream: proxy\n",
+	}
+	for _, content := range invalid {
+		path := fixtureFile(t, content)
+		_, err := LoadProfiles(path)
+		os.Remove(path)
+		assert.NotNil(t, err, "profiles %q should be rejected", content)
+	}
+}
+
+func TestApplyWithholdsTheCredentialsFromAnotherProxy(t *testing.T) {
+	path := fixtureFile(t, fixtureProfiles)
+	defer os.Remove(path)
+	profiles, _ := LoadProfiles(path)
+	for _, key := range []string{"soxy.proxyaddress", "soxy.proxyport", "soxy.proxytype", "soxy.proxy"} {
+		value := map[string]string{
+			"soxy.proxyaddress": "attacker.example",
+			"soxy.proxyport":    "1080",
+			"soxy.proxytype":    "socks5",
+			"soxy.proxy":        "socks5://attacker.example:1080",
+		}[key]
+		params := profiles["corp-eu"].Apply(map[string]string{ProfileOption: "corp-eu", key: value})
+		assert.Equal(t, value, params[key], key)
+		assert.NotContains(t, params, "soxy.proxyuser", key)
+		assert.NotContains(t, params, "soxy.proxypassword.file", key)
+	}
+
+	//the profile proxy keeps its credentials
+	params := profiles["corp-eu"].Apply(map[string]string{ProfileOption: "corp-eu", "soxy.dns": "tor"})
+	assert.Equal(t, "team", params["soxy.proxyuser"])
+	assert.Equal(t, "/run/secrets/corp-eu", params["soxy.proxypassword.file"])
+}
+
+func TestApplyNetworkOptionsTakePrecedence(t *testing.T) {
+	path := fixtureFile(t, fixtureProfiles)
+	defer os.Remove(path)
+	profiles, _ := LoadProfiles(path)
+	params := profiles["corp-eu"].Apply(map[string]string{
+		ProfileOption:            "corp-eu",
+		"soxy.proxyport":         "8080",
+		"soxy.proxypassword.env": "CORP_PASSWORD",
+	})
+	assert.Equal(t, "proxy.eu.corp", params["soxy.proxyaddress"])
+	assert.Equal(t, "8080", params["soxy.proxyport"])
+	assert.Equal(t, "CORP_PASSWORD", params["soxy.proxypassword.env"])
+	assert.NotContains(t, params, "soxy.proxypassword.file")
+}
+
+func fixtureFile(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "profiles")
+	assert.Nil(t, err)
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
@@ -1,12 +1,12 @@
 package driver
 
 import (
+	"fmt"
 	"github.com/docker/go-plugins-helpers/network"
 	"github.com/docker/libnetwork/driverapi"
 	"github.com/docker/libnetwork/drivers/bridge"
 	"github.com/docker/libnetwork/netlabel"
 	"github.com/fsouza/go-dockerclient"
-	"github.com/sirupsen/logrus"
 	"net"
 	"strings"
 )
@@ -20,15 +20,33 @@ type InterfaceInfoProxy struct {
 	ip6      *net.IPNet
 }
 
-func (iface *InterfaceInfoProxy) init() {
-	address, netAddress, _ := net.ParseCIDR(iface.request.Interface.Address)
+func (iface *InterfaceInfoProxy) init() error {
+	if iface.request.Interface == nil {
+		return &DecodeError{"CreateEndpoint", "Interface", "is null"}
+	}
+	var err error
+	if iface.request.Interface.Address != "" {
+		var address net.IP
+		address, iface.ip, err = net.ParseCIDR(iface.request.Interface.Address)
+		if err != nil {
+			return &DecodeError{"CreateEndpoint", "Interface.Address", fmt.Sprintf("is not a CIDR : '%s'", iface.request.Interface.Address)}
+		}
+		iface.ip.IP = address
+	}
 	addressV6 := net.ParseIP(iface.request.Interface.AddressIPv6)
-	iface.mac, _ = net.ParseMAC(iface.request.Interface.MacAddress)
-	netAddress.IP = address
-	iface.ip = netAddress
+	if addressV6 == nil && iface.request.Interface.AddressIPv6 != "" {
+		return &DecodeError{"CreateEndpoint", "Interface.AddressIPv6", fmt.Sprintf("is not an ip address : '%s'", iface.request.Interface.AddressIPv6)}
+	}
 	iface.ip6 = &net.IPNet{
 		IP: addressV6,
 	}
+	if iface.request.Interface.MacAddress != "" {
+		iface.mac, err = net.ParseMAC(iface.request.Interface.MacAddress)
+		if err != nil {
+			return &DecodeError{"CreateEndpoint", "Interface.MacAddress", fmt.Sprintf("is not a mac address : '%s'", iface.request.Interface.MacAddress)}
+		}
+	}
+	return nil
 }
 
 //SetMacAddress intercepts the SetMacAddress call and updates the response data
@@ -90,11 +108,14 @@ func (p *JoinInfoProxy) SetGatewayIPv6(ip net.IP) error {
 
 //AddStaticRoute intercepts the libnetwork AddStaticRoute call and updates the driver response
 func (p *JoinInfoProxy) AddStaticRoute(destination *net.IPNet, routeType int, nextHop net.IP) error {
-	p.response.StaticRoutes = append(p.response.StaticRoutes, &network.StaticRoute{
+	route := &network.StaticRoute{
 		Destination: destination.String(),
 		RouteType:   routeType,
-		NextHop:     nextHop.String(),
-	})
+	}
+	if nextHop != nil {
+		route.NextHop = nextHop.String()
+	}
+	p.response.StaticRoutes = append(p.response.StaticRoutes, route)
 	return nil
 }
 
@@ -127,40 +148,6 @@ func (proxy *InterfaceNameInfoProxy) setNames(srcName, dstPrefix string) error {
 	return nil
 }
 
-func transform(input []*network.IPAMData) []driverapi.IPAMData {
-	var driverIPAM []driverapi.IPAMData
-	for _, element := range input {
-		gwIP, gatewayAddress, err := net.ParseCIDR(element.Gateway)
-		if err != nil {
-			gwIP, gatewayAddress, err = net.ParseCIDR(element.Gateway + "/24")
-			if err != nil {
-				logrus.Error(err.Error())
-			}
-		}
-		gatewayAddress.IP = gwIP
-		poolIP, poolAddress, poolError := net.ParseCIDR(element.Pool)
-
-		if poolError != nil {
-			poolAddress.IP = poolIP
-		}
-		options := make(map[string]*net.IPNet)
-
-		for key, val := range element.AuxAddresses {
-			_, parsedAddress, _ := net.ParseCIDR(val.(string))
-			options[key] = parsedAddress
-		}
-		options[bridge.DefaultGatewayV4AuxKey] = gatewayAddress
-
-		driverIPAM = append(driverIPAM, driverapi.IPAMData{
-			Gateway:      gatewayAddress,
-			Pool:         poolAddress,
-			AuxAddresses: options,
-			AddressSpace: element.AddressSpace,
-		})
-	}
-	return driverIPAM
-}
-
 func transformNetwork(ntwrk docker.Network) *network.CreateNetworkRequest {
 	request := &network.CreateNetworkRequest{}
 	request.NetworkID = ntwrk.ID
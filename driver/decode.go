@@ -0,0 +1,244 @@
+package driver
+
+import (
+	"fmt"
+	"github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/driverapi"
+	"github.com/docker/libnetwork/drivers/bridge"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"math"
+	"net"
+	"strings"
+)
+
+//DecodeError a plugin request payload that can't be decoded
+type DecodeError struct {
+	//Request the plugin request name
+	Request string
+	//Field the path of the offending field
+	Field string
+	//Reason what's wrong with the field
+	Reason string
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid %s request : field '%s' %s", e.Request, e.Field, e.Reason)
+}
+
+//decodeNetworkOptions returns the network options with the generic data (the '-o' options) decoded as strings
+func decodeNetworkOptions(data map[string]interface{}) (map[string]interface{}, map[string]string, error) {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	generic := make(map[string]string)
+	switch raw := data[netlabel.GenericData].(type) {
+	case nil:
+	case map[string]string:
+		for key, value := range raw {
+			generic[key] = value
+		}
+	case map[string]interface{}:
+		for key, value := range raw {
+			switch value.(type) {
+			case string, bool, float64, int, int64:
+				generic[key] = fmt.Sprint(value)
+			default:
+				return nil, nil, &DecodeError{"CreateNetwork", netlabel.GenericData + "." + key, fmt.Sprintf("has unsupported type %T", value)}
+			}
+		}
+	default:
+		return nil, nil, &DecodeError{"CreateNetwork", netlabel.GenericData, fmt.Sprintf("has unsupported type %T", raw)}
+	}
+	options := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		options[key] = value
+	}
+	options[netlabel.GenericData] = generic
+	return options, generic, nil
+}
+
+//decodeIPAMData decodes the pools allocated to a network
+func decodeIPAMData(field string, input []*network.IPAMData) ([]driverapi.IPAMData, error) {
+	var driverIPAM []driverapi.IPAMData
+	for i, element := range input {
+		path := fmt.Sprintf("%s[%d]", field, i)
+		if element == nil {
+			return nil, &DecodeError{"CreateNetwork", path, "is null"}
+		}
+		poolIP, poolAddress, err := net.ParseCIDR(element.Pool)
+		if err != nil {
+			return nil, &DecodeError{"CreateNetwork", path + ".Pool", fmt.Sprintf("is not a CIDR : '%s'", element.Pool)}
+		}
+		poolAddress.IP = poolIP
+		gatewayAddress, err := decodeGateway(element.Gateway, poolAddress)
+		if err != nil {
+			return nil, &DecodeError{"CreateNetwork", path + ".Gateway", err.Error()}
+		}
+
+		options := make(map[string]*net.IPNet)
+		for key, val := range element.AuxAddresses {
+			address, ok := val.(string)
+			if !ok {
+				return nil, &DecodeError{"CreateNetwork", path + ".AuxAddresses." + key, fmt.Sprintf("has unsupported type %T", val)}
+			}
+			auxIP, parsedAddress, err := net.ParseCIDR(address)
+			if err != nil {
+				return nil, &DecodeError{"CreateNetwork", path + ".AuxAddresses." + key, fmt.Sprintf("is not a CIDR : '%s'", address)}
+			}
+			parsedAddress.IP = auxIP
+			options[key] = parsedAddress
+		}
+		options[bridge.DefaultGatewayV4AuxKey] = gatewayAddress
+
+		driverIPAM = append(driverIPAM, driverapi.IPAMData{
+			Gateway:      gatewayAddress,
+			Pool:         poolAddress,
+			AuxAddresses: options,
+			AddressSpace: element.AddressSpace,
+		})
+	}
+	return driverIPAM, nil
+}
+
+//decodeGateway parses a gateway given either as a CIDR or as an address of the pool
+func decodeGateway(gateway string, pool *net.IPNet) (*net.IPNet, error) {
+	if !strings.Contains(gateway, "/") {
+		ip := net.ParseIP(gateway)
+		if ip == nil {
+			return nil, fmt.Errorf("is not an ip address : '%s'", gateway)
+		}
+		return &net.IPNet{IP: ip, Mask: pool.Mask}, nil
+	}
+	gatewayIP, gatewayAddress, err := net.ParseCIDR(gateway)
+	if err != nil {
+		return nil, fmt.Errorf("is not a CIDR : '%s'", gateway)
+	}
+	gatewayAddress.IP = gatewayIP
+	return gatewayAddress, nil
+}
+
+//decodeExternalConnectivity decodes the port mappings and the exposed ports of a ProgramExternalConnectivity request
+func decodeExternalConnectivity(options map[string]interface{}) ([]types.PortBinding, []types.TransportPort, error) {
+	var mappings []types.PortBinding
+	rawMappings, err := decodeList("ProgramExternalConnectivity", netlabel.PortMap, options[netlabel.PortMap])
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, element := range rawMappings {
+		decoder := fieldDecoder{request: "ProgramExternalConnectivity", path: fmt.Sprintf("%s[%d]", netlabel.PortMap, i), fields: element}
+		mappings = append(mappings, types.PortBinding{
+			IP:          decoder.ip("IP"),
+			Proto:       decoder.protocol("Proto"),
+			Port:        decoder.port("Port"),
+			HostIP:      decoder.ip("HostIP"),
+			HostPort:    decoder.port("HostPort"),
+			HostPortEnd: decoder.port("HostPortEnd"),
+		})
+		if decoder.err != nil {
+			return nil, nil, decoder.err
+		}
+	}
+
+	var exposedPorts []types.TransportPort
+	rawExposedPorts, err := decodeList("ProgramExternalConnectivity", netlabel.ExposedPorts, options[netlabel.ExposedPorts])
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, element := range rawExposedPorts {
+		decoder := fieldDecoder{request: "ProgramExternalConnectivity", path: fmt.Sprintf("%s[%d]", netlabel.ExposedPorts, i), fields: element}
+		exposedPorts = append(exposedPorts, types.TransportPort{
+			Proto: decoder.protocol("Proto"),
+			Port:  decoder.port("Port"),
+		})
+		if decoder.err != nil {
+			return nil, nil, decoder.err
+		}
+	}
+	return mappings, exposedPorts, nil
+}
+
+//decodeList decodes an optional list of JSON objects
+func decodeList(request string, field string, value interface{}) ([]map[string]interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, &DecodeError{request, field, fmt.Sprintf("is not a list but %T", value)}
+	}
+	var elements []map[string]interface{}
+	for i, element := range list {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, &DecodeError{request, fmt.Sprintf("%s[%d]", field, i), fmt.Sprintf("is not an object but %T", element)}
+		}
+		elements = append(elements, object)
+	}
+	return elements, nil
+}
+
+//fieldDecoder decodes the fields of a JSON object, keeping the first error
+type fieldDecoder struct {
+	request string
+	path    string
+	fields  map[string]interface{}
+	err     error
+}
+
+func (decoder *fieldDecoder) fail(field string, reason string) {
+	if decoder.err == nil {
+		decoder.err = &DecodeError{decoder.request, decoder.path + "." + field, reason}
+	}
+}
+
+//ip decodes an optional ip address
+func (decoder *fieldDecoder) ip(field string) net.IP {
+	switch value := decoder.fields[field].(type) {
+	case nil:
+		return nil
+	case string:
+		if value == "" {
+			return nil
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			decoder.fail(field, fmt.Sprintf("is not an ip address : '%s'", value))
+		}
+		return ip
+	default:
+		decoder.fail(field, fmt.Sprintf("is not a string but %T", value))
+		return nil
+	}
+}
+
+//number decodes an optional unsigned integer lower or equal to max
+func (decoder *fieldDecoder) number(field string, max float64) float64 {
+	switch value := decoder.fields[field].(type) {
+	case nil:
+		return 0
+	case float64:
+		if value < 0 || value > max || value != math.Trunc(value) {
+			decoder.fail(field, fmt.Sprintf("is out of range : %v", value))
+			return 0
+		}
+		return value
+	default:
+		decoder.fail(field, fmt.Sprintf("is not a number but %T", value))
+		return 0
+	}
+}
+
+func (decoder *fieldDecoder) port(field string) uint16 {
+	return uint16(decoder.number(field, math.MaxUint16))
+}
+
+func (decoder *fieldDecoder) protocol(field string) types.Protocol {
+	value := uint8(decoder.number(field, math.MaxUint8))
+	switch value {
+	case types.TCP, types.UDP, types.ICMP:
+		return types.Protocol(value)
+	}
+	decoder.fail(field, fmt.Sprintf("is not a supported protocol : %d", value))
+	return 0
+}
@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"github.com/docker/go-plugins-helpers/network"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeIPAMData(t *testing.T) {
+	ipam, err := decodeIPAMData("IPv4Data", []*network.IPAMData{{
+		Pool:         "172.21.1.0/24",
+		Gateway:      "172.21.1.1",
+		AuxAddresses: map[string]interface{}{"reserved": "172.21.1.2/24"},
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, "172.21.1.1/24", ipam[0].Gateway.String())
+	assert.Equal(t, "172.21.1.2/24", ipam[0].AuxAddresses["reserved"].String())
+}
+
+func TestDecodeIPAMDataErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []*network.IPAMData
+		field string
+	}{
+		{"null pool", []*network.IPAMData{nil}, "IPv4Data[0]"},
+		{"invalid pool", []*network.IPAMData{{Pool: "nowhere", Gateway: "172.21.1.1/24"}}, "IPv4Data[0].Pool"},
+		{"invalid gateway", []*network.IPAMData{{Pool: "172.21.1.0/24", Gateway: "172.21.1"}}, "IPv4Data[0].Gateway"},
+		{"invalid gateway CIDR", []*network.IPAMData{{Pool: "172.21.1.0/24", Gateway: "172.21.1.1/99"}}, "IPv4Data[0].Gateway"},
+		{"non string aux address", []*network.IPAMData{{Pool: "172.21.1.0/24", Gateway: "172.21.1.1/24",
+			AuxAddresses: map[string]interface{}{"reserved": 12.0}}}, "IPv4Data[0].AuxAddresses.reserved"},
+		{"invalid aux address", []*network.IPAMData{{Pool: "172.21.1.0/24", Gateway: "172.21.1.1/24",
+			AuxAddresses: map[string]interface{}{"reserved": "172.21.1.2"}}}, "IPv4Data[0].AuxAddresses.reserved"},
+	}
+	for _, test := range tests {
+		_, err := decodeIPAMData("IPv4Data", test.input)
+		if assert.IsType(t, &DecodeError{}, err, test.name) {
+			assert.Equal(t, test.field, err.(*DecodeError).Field, test.name)
+		}
+	}
+}
+
+func TestDecodeNetworkOptions(t *testing.T) {
+	options, generic, err := decodeNetworkOptions(map[string]interface{}{
+		netlabel.GenericData: map[string]interface{}{"soxy.proxyport": "1080", "soxy.blockUDP": true},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"soxy.proxyport": "1080", "soxy.blockUDP": "true"}, generic)
+	assert.Equal(t, generic, options[netlabel.GenericData])
+
+	_, generic, err = decodeNetworkOptions(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, generic)
+
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		field   string
+	}{
+		{"generic data list", map[string]interface{}{netlabel.GenericData: []interface{}{"a"}}, netlabel.GenericData},
+		{"nested value", map[string]interface{}{netlabel.GenericData: map[string]interface{}{"soxy.bypass": []interface{}{}}}, netlabel.GenericData + ".soxy.bypass"},
+	}
+	for _, test := range tests {
+		_, _, err := decodeNetworkOptions(test.options)
+		if assert.IsType(t, &DecodeError{}, err, test.name) {
+			assert.Equal(t, test.field, err.(*DecodeError).Field, test.name)
+		}
+	}
+}
+
+func TestDecodeExternalConnectivity(t *testing.T) {
+	mappings, exposedPorts, err := decodeExternalConnectivity(map[string]interface{}{
+		netlabel.PortMap: []interface{}{map[string]interface{}{
+			"IP": "", "Proto": 6.0, "Port": 80.0, "HostIP": "0.0.0.0", "HostPort": 8080.0, "HostPortEnd": 8080.0,
+		}},
+		netlabel.ExposedPorts: []interface{}{map[string]interface{}{"Proto": 17.0, "Port": 53.0}},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(8080), mappings[0].HostPort)
+	assert.Equal(t, types.Protocol(types.TCP), mappings[0].Proto)
+	assert.Equal(t, types.Protocol(types.UDP), exposedPorts[0].Proto)
+
+	mappings, exposedPorts, err = decodeExternalConnectivity(map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Empty(t, mappings)
+	assert.Empty(t, exposedPorts)
+}
+
+func TestDecodeExternalConnectivityErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		field   string
+	}{
+		{"port map object", map[string]interface{}{netlabel.PortMap: map[string]interface{}{}}, netlabel.PortMap},
+		{"port map element", map[string]interface{}{netlabel.PortMap: []interface{}{"80:80"}}, netlabel.PortMap + "[0]"},
+		{"string port", map[string]interface{}{netlabel.PortMap: []interface{}{map[string]interface{}{"Proto": 6.0, "Port": "80"}}}, netlabel.PortMap + "[0].Port"},
+		{"port out of range", map[string]interface{}{netlabel.PortMap: []interface{}{map[string]interface{}{"Proto": 6.0, "HostPort": 70000.0}}}, netlabel.PortMap + "[0].HostPort"},
+		{"invalid ip", map[string]interface{}{netlabel.PortMap: []interface{}{map[string]interface{}{"Proto": 6.0, "HostIP": "localhost"}}}, netlabel.PortMap + "[0].HostIP"},
+		{"unknown protocol", map[string]interface{}{netlabel.ExposedPorts: []interface{}{map[string]interface{}{"Proto": 42.0, "Port": 80.0}}}, netlabel.ExposedPorts + "[0].Proto"},
+		{"fractional port", map[string]interface{}{netlabel.ExposedPorts: []interface{}{map[string]interface{}{"Proto": 6.0, "Port": 80.5}}}, netlabel.ExposedPorts + "[0].Port"},
+	}
+	for _, test := range tests {
+		_, _, err := decodeExternalConnectivity(test.options)
+		if assert.IsType(t, &DecodeError{}, err, test.name) {
+			assert.Equal(t, test.field, err.(*DecodeError).Field, test.name)
+		}
+	}
+}
+
+func TestInterfaceInfoProxyInitErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		iface *network.EndpointInterface
+		field string
+	}{
+		{"null interface", nil, "Interface"},
+		{"invalid address", &network.EndpointInterface{Address: "172.21.1.2"}, "Interface.Address"},
+		{"invalid ipv6 address", &network.EndpointInterface{AddressIPv6: "fe80::zz"}, "Interface.AddressIPv6"},
+		{"invalid mac address", &network.EndpointInterface{MacAddress: "10:10"}, "Interface.MacAddress"},
+	}
+	for _, test := range tests {
+		proxy := &InterfaceInfoProxy{request: &network.CreateEndpointRequest{Interface: test.iface}}
+		err := proxy.init()
+		if assert.IsType(t, &DecodeError{}, err, test.name) {
+			assert.Equal(t, test.field, err.(*DecodeError).Field, test.name)
+		}
+	}
+}
@@ -11,36 +11,100 @@ import (
 	"github.com/docker/libnetwork/types"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/audit"
+	"github.com/yassine/soxy-driver/config"
 	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/secret"
+	"github.com/yassine/soxy-driver/state"
+	"github.com/yassine/soxy-driver/supervisor"
 	"github.com/yassine/soxy-driver/tor"
+	"github.com/yassine/soxy-driver/tunnel"
+	"github.com/yassine/soxy-driver/utils"
 	"net"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
 )
 
 //Driver A Driver structure
 type Driver struct {
 	delegate      *driverapi.Driver
 	networksIndex map[string]*soxyNetwork.Context
+	profiles      map[string]*config.Profile
+	configuration *config.Configuration
 	tor           *tor.Tor
+	//overrides the options changed at runtime through the management API, per network
+	overrides      map[string]map[string]string
+	overridesStore *state.Store
+	//networkLocks serialize the reconfigurations of each network
+	networkLocks map[string]*sync.Mutex
+	//pauses the networks whose traffic isn't currently tunneled
+	pauses      map[string]soxyNetwork.Pause
+	pausesStore *state.Store
+	//endpoints the endpoints of the networks, by id
+	endpoints      map[string]Endpoint
+	endpointsStore *state.Store
+	//resolver resolves the containers joining the networks
+	resolver ContainerResolver
+	//torTimer the pending stop of the embedded tor instance no joined network relies on, see idle.go
+	torTimer *time.Timer
+	//shared the transparent proxy shared by the networks, nil unless enabled
+	shared *tunnel.Shared
+	//probes the latest upstream probes, served by the metrics
+	probes *upstreamProbes
+	//torGeneration invalidates the pending stops of tor whenever a network relying on it is joined
+	torGeneration uint64
+	torLock       sync.Mutex
+	sync.RWMutex
 }
 
 //New Creates a new Driver instance
-func New() Driver {
+func New(configuration *config.Configuration) *Driver {
 	driverCallback := &Callback{}
 	var bridgeDriverOptions = make(map[string]interface{})
 	genericOptions := make(options.Generic)
-	genericOptions["EnableIPForwarding"] = true
-	genericOptions["EnableIPTables"] = true
-	genericOptions["EnableUserlandProxy"] = false
+	genericOptions["EnableIPForwarding"] = configuration.Bridge.EnableIPForwarding
+	genericOptions["EnableIPTables"] = configuration.Bridge.EnableIPTables
+	genericOptions["EnableUserlandProxy"] = configuration.Bridge.EnableUserlandProxy
 	bridgeDriverOptions[netlabel.GenericData] = genericOptions
 	err := bridge.Init(driverCallback, bridgeDriverOptions)
 	if err != nil {
 		logrus.Error(err.Error())
 	}
-	driver := Driver{
-		delegate:      &driverCallback.driver,
-		tor:           tor.New(),
-		networksIndex: make(map[string]*soxyNetwork.Context),
+	//the processes and files of a previous run that didn't shut down are left behind
+	if err = utils.CleanRuntimeDir(); err != nil {
+		logrus.Errorf("couldn't clean the runtime directory : %v", err)
 	}
+	driver := &Driver{
+		delegate:       &driverCallback.driver,
+		tor:            tor.New(configuration.TorConfigurationTemplate()),
+		networksIndex:  make(map[string]*soxyNetwork.Context),
+		profiles:       configuration.Profiles,
+		configuration:  configuration,
+		overrides:      make(map[string]map[string]string),
+		overridesStore: state.NewStore(filepath.Join(configuration.StateDir, configuration.Namespace, "overrides.json")),
+		networkLocks:   make(map[string]*sync.Mutex),
+		pauses:         make(map[string]soxyNetwork.Pause),
+		pausesStore:    state.NewStore(filepath.Join(configuration.StateDir, configuration.Namespace, "pauses.json")),
+		endpoints:      make(map[string]Endpoint),
+		endpointsStore: state.NewStore(filepath.Join(configuration.StateDir, configuration.Namespace, "endpoints.json")),
+		probes:         newUpstreamProbes(upstreamProbeTTL),
+	}
+	if err = driver.overridesStore.Load(&driver.overrides); err != nil {
+		logrus.Errorf("couldn't load the network overrides from '%s' : %v", driver.overridesStore.Path(), err)
+	}
+	if err = driver.pausesStore.Load(&driver.pauses); err != nil {
+		logrus.Errorf("couldn't load the paused networks from '%s' : %v", driver.pausesStore.Path(), err)
+	}
+	driver.loadEndpoints()
+	audit.Configure(configuration.AuditSettings())
+	supervisor.ConfigureLogs(configuration.ProcessLogSettings())
+	secret.Configure(configuration.SecretSettings())
+	tunnel.ConfigureExec(configuration.ExecSettings())
+	soxyNetwork.SetIdleTimeout(configuration.IdleTimeout())
+	driver.shared = newSharedTunnel(configuration.SharedTunnel)
+	soxyNetwork.SetSharedTunnel(driver.shared)
 	driver.init()
 	return driver
 }
@@ -51,30 +115,73 @@ func (d *Driver) GetCapabilities() (*network.CapabilitiesResponse, error) {
 	return &network.CapabilitiesResponse{Scope: network.LocalScope}, nil
 }
 
-//CreateNetwork driver-utils contract implementation
-func (d *Driver) CreateNetwork(request *network.CreateNetworkRequest) error {
+//CreateNetwork driver-utils contract implementation, a failed creation is rolled back so that the host is left as before
+func (d *Driver) CreateNetwork(request *network.CreateNetworkRequest) (err error) {
 	logrus.Debug("Received Get CreateNetwork Request : ", request.NetworkID)
 	delegate := *d.delegate
-	ipv4Addresses := transform(request.IPv4Data)
-	ipv6Addresses := transform(request.IPv6Data)
-	err := delegate.CreateNetwork(request.NetworkID, parseNetworkOptions(request.Options), nil, ipv4Addresses, ipv6Addresses)
-	link, _ := findLinkByAddress(ipv4Addresses[0].Gateway.IP.String())
-	if link != nil {
-		allocatedBridgeName := link.Attrs().Name
-		logrus.Debug("Allocated the bridge : ", allocatedBridgeName, " to network : ", request.NetworkID)
-		networkContext, err := soxyNetwork.NewContext(request.NetworkID, allocatedBridgeName, request.Options[netlabel.GenericData].(map[string]string), d.tor.Port(), d.tor.DNSPort)
-		if err != nil {
-			logrus.Error("Error while creating network context.")
-			return err
-		}
-		d.networksIndex[request.NetworkID] = networkContext
-		err = networkContext.Init()
+	ipv4Addresses, err := decodeIPAMData("IPv4Data", request.IPv4Data)
+	if err != nil {
+		return err
+	}
+	if len(ipv4Addresses) == 0 {
+		return &DecodeError{"CreateNetwork", "IPv4Data", "is empty"}
+	}
+	ipv6Addresses, err := decodeIPAMData("IPv6Data", request.IPv6Data)
+	if err != nil {
+		return err
+	}
+	options, networkOptions, err := decodeNetworkOptions(request.Options)
+	if err != nil {
+		return err
+	}
+	params, err := d.networkParams(request.NetworkID, networkOptions)
+	if err != nil {
+		return err
+	}
+
+	undo := &utils.Undo{}
+	defer func() {
 		if err != nil {
-			logrus.Error("Error while initializing network context.")
-			return err
+			logrus.Errorf("rolling back the creation of network '%s'", request.NetworkID)
+			undo.Rollback()
 		}
+	}()
+
+	//the bridge driver supports a single pool, the other ones are handled by the network context
+	err = delegate.CreateNetwork(request.NetworkID, options, nil, ipv4Addresses[:1], ipv6Addresses)
+	if err != nil {
+		return err
 	}
-	return err
+	undo.Push("bridge network "+request.NetworkID, func() error { return delegate.DeleteNetwork(request.NetworkID) })
+
+	link, err := findBridge(request.NetworkID, networkOptions)
+	if err != nil {
+		return utils.LogAndThrowError("couldn't find the bridge allocated to network '%s' : %v", request.NetworkID, err)
+	}
+	allocatedBridgeName := link.Attrs().Name
+	logrus.Debug("Allocated the bridge : ", allocatedBridgeName, " to network : ", request.NetworkID)
+	networkContext, err := soxyNetwork.NewContext(request.NetworkID, allocatedBridgeName, params, d.tor.Port(), d.tor.DNSPort)
+	if err != nil {
+		logrus.Error("Error while creating network context.")
+		return err
+	}
+	networkContext.Options = networkOptions
+	for _, ipam := range ipv4Addresses {
+		networkContext.Subnets = append(networkContext.Subnets, soxyNetwork.Subnet{Pool: ipam.Pool, Gateway: ipam.Gateway})
+	}
+
+	d.restorePause(networkContext)
+	//Init removes the rules, processes and files it created when it fails
+	err = networkContext.Init()
+	if err != nil {
+		logrus.Error("Error while initializing network context.")
+		return err
+	}
+	d.Lock()
+	d.networksIndex[request.NetworkID] = networkContext
+	d.Unlock()
+	d.restoreEndpoints(networkContext)
+	return nil
 }
 
 //AllocateNetwork driver-utils contract implementation
@@ -87,11 +194,32 @@ func (d *Driver) AllocateNetwork(request *network.AllocateNetworkRequest) (*netw
 //DeleteNetwork driver-utils contract implementation
 func (d *Driver) DeleteNetwork(request *network.DeleteNetworkRequest) error {
 	logrus.Debug("Received Get DeleteNetwork Request : %s", request.NetworkID)
+	//the network isn't deleted while it is being reconfigured, lest the reconfiguration brings it back
+	if lock := d.networkLock(request.NetworkID); lock != nil {
+		lock.Lock()
+		defer lock.Unlock()
+	}
 	delegate := *d.delegate
 	err := delegate.DeleteNetwork(request.NetworkID)
-	if networkContext, ok := d.networksIndex[request.NetworkID]; ok {
+	d.Lock()
+	networkContext, ok := d.networksIndex[request.NetworkID]
+	delete(d.networksIndex, request.NetworkID)
+	delete(d.networkLocks, request.NetworkID)
+	_, overridden := d.overrides[request.NetworkID]
+	delete(d.overrides, request.NetworkID)
+	_, paused := d.pauses[request.NetworkID]
+	delete(d.pauses, request.NetworkID)
+	d.Unlock()
+	if overridden {
+		utils.LogIfNotNull(d.saveOverrides())
+	}
+	if paused {
+		utils.LogIfNotNull(d.savePauses())
+	}
+	d.forgetEndpoints(func(endpoint Endpoint) bool { return endpoint.NetworkID == request.NetworkID })
+	if ok {
 		err = networkContext.Cleanup()
-		delete(d.networksIndex, request.NetworkID)
+		utils.LogIfNotNull(d.syncTor())
 	}
 	return err
 }
@@ -111,34 +239,67 @@ func (d *Driver) CreateEndpoint(request *network.CreateEndpointRequest) (*networ
 			Interface: &network.EndpointInterface{},
 		},
 	}
-	proxy.init()
+	if err := proxy.init(); err != nil {
+		return nil, err
+	}
 	err := delegate.CreateEndpoint(request.NetworkID, request.EndpointID, proxy, request.Options)
-	return proxy.response, err
+	if err != nil {
+		return nil, err
+	}
+	if networkContext := d.networkContext(request.NetworkID); networkContext != nil && proxy.ip != nil {
+		networkContext.AddEndpoint(request.EndpointID, proxy.ip.IP)
+	}
+	endpoint := Endpoint{ID: request.EndpointID, NetworkID: request.NetworkID, CreatedAt: time.Now()}
+	if proxy.ip != nil {
+		endpoint.IPv4Address = proxy.ip.IP.String()
+	}
+	if proxy.ip6 != nil && proxy.ip6.IP != nil {
+		endpoint.IPv6Address = proxy.ip6.IP.String()
+	}
+	if proxy.mac != nil {
+		endpoint.MacAddress = proxy.mac.String()
+	}
+	d.recordEndpoint(endpoint)
+	return proxy.response, nil
 }
 
 //DeleteEndpoint driver-utils contract implementation
 func (d *Driver) DeleteEndpoint(request *network.DeleteEndpointRequest) error {
 	logrus.Debug("Received DeleteEndpoint Request %s @ %s", request.EndpointID, request.NetworkID)
 	delegate := *d.delegate
-	return delegate.DeleteEndpoint(request.NetworkID, request.EndpointID)
+	err := delegate.DeleteEndpoint(request.NetworkID, request.EndpointID)
+	if networkContext := d.networkContext(request.NetworkID); err == nil && networkContext != nil {
+		networkContext.RemoveEndpointPolicy(request.EndpointID)
+		networkContext.RemoveEndpoint(request.EndpointID)
+		d.deactivate(networkContext, request.EndpointID)
+	}
+	if err == nil {
+		d.forgetEndpoints(func(endpoint Endpoint) bool { return endpoint.ID == request.EndpointID })
+	}
+	return err
 }
 
 //EndpointInfo driver-utils contract implementation
 func (d *Driver) EndpointInfo(request *network.InfoRequest) (*network.InfoResponse, error) {
 	logrus.Debug("Received EndpointInfo Request %s @ %s", request.EndpointID, request.NetworkID)
 	delegate := *d.delegate
-	info, _ := delegate.EndpointOperInfo(request.NetworkID, request.EndpointID)
-	m := map[string]string{}
-	if info[netlabel.ExposedPorts] != nil {
-		var exposedPorts []types.TransportPort
-		var exposedPortsString = ""
-		exposedPorts = info[netlabel.ExposedPorts].([]types.TransportPort)
-		for _, port := range exposedPorts {
-			exposedPortsString += port.String()
+	info, err := delegate.EndpointOperInfo(request.NetworkID, request.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	m := portsInfo(info)
+	if endpoint, ok := d.endpoint(request.EndpointID); ok {
+		for key, value := range endpoint.info() {
+			m[key] = value
 		}
 	}
-	if info[netlabel.MacAddress] != nil {
-		m[netlabel.MacAddress] = info[netlabel.MacAddress].(net.HardwareAddr).String()
+	if networkContext := d.networkContext(request.NetworkID); networkContext != nil {
+		for key, value := range tunnelInfo(networkContext, request.EndpointID) {
+			m[key] = value
+		}
+	}
+	if mac, ok := info[netlabel.MacAddress].(net.HardwareAddr); ok {
+		m[netlabel.MacAddress] = mac.String()
 	}
 	return &network.InfoResponse{
 		Value: m,
@@ -166,6 +327,37 @@ func (d *Driver) Join(request *network.JoinRequest) (*network.JoinResponse, erro
 	joinInfoProxy.response.InterfaceName.SrcName = ifaceNameProxy.InterfaceName.SrcName
 	joinInfoProxy.response.InterfaceName.DstPrefix = ifaceNameProxy.InterfaceName.DstPrefix
 
+	//endpoints of the secondary subnets are routed through their own subnet gateway
+	if networkContext := d.networkContext(request.NetworkID); err == nil && networkContext != nil {
+		if gateway := networkContext.GatewayOf(networkContext.EndpointAddress(request.EndpointID)); gateway != nil {
+			joinInfoProxy.response.Gateway = gateway.String()
+		}
+		for _, route := range networkContext.Routes {
+			routeType := types.NEXTHOP
+			if route.NextHop == nil {
+				routeType = types.CONNECTED
+			}
+			utils.LogIfNotNull(joinInfoProxy.AddStaticRoute(route.Destination, routeType, route.NextHop))
+		}
+		container, err := d.joinPolicy(networkContext, request.EndpointID, request.SandboxKey)
+		if err != nil {
+			utils.LogIfNotNull(delegate.Leave(request.NetworkID, request.EndpointID))
+			return nil, err
+		}
+		//the tunnel (and tor) may have been left stopped until a container joins the network
+		if err = d.activate(networkContext, request.EndpointID); err != nil {
+			networkContext.RemoveEndpointPolicy(request.EndpointID)
+			utils.LogIfNotNull(delegate.Leave(request.NetworkID, request.EndpointID))
+			return nil, err
+		}
+		d.updateEndpoint(request.EndpointID, func(endpoint *Endpoint) {
+			endpoint.Joined, endpoint.JoinedAt, endpoint.SandboxKey = true, time.Now(), request.SandboxKey
+			if container != nil {
+				endpoint.ContainerID = container.ID
+			}
+		})
+	}
+
 	return joinInfoProxy.response, err
 }
 
@@ -173,6 +365,13 @@ func (d *Driver) Join(request *network.JoinRequest) (*network.JoinResponse, erro
 func (d *Driver) Leave(request *network.LeaveRequest) error {
 	logrus.Debug("Received Leave Request %s @ %s", request.EndpointID, request.NetworkID)
 	delegate := *d.delegate
+	if networkContext := d.networkContext(request.NetworkID); networkContext != nil {
+		networkContext.RemoveEndpointPolicy(request.EndpointID)
+		d.deactivate(networkContext, request.EndpointID)
+	}
+	d.updateEndpoint(request.EndpointID, func(endpoint *Endpoint) {
+		endpoint.Joined, endpoint.JoinedAt, endpoint.SandboxKey = false, time.Time{}, ""
+	})
 	return delegate.Leave(request.NetworkID, request.EndpointID)
 }
 
@@ -191,28 +390,10 @@ func (d *Driver) ProgramExternalConnectivity(request *network.ProgramExternalCon
 	delegate := *d.delegate
 	logrus.Debug("Received ProgramExternalConnectivity Request")
 
-	var mappings []types.PortBinding
-	var rawMapping = request.Options[netlabel.PortMap].([]interface{})
-	for _, element := range rawMapping {
-		element2 := element.(map[string]interface{})
-		mappings = append(mappings, types.PortBinding{
-			IP:          net.ParseIP(element2["IP"].(string)),
-			Proto:       protocolValueOf(uint8(element2["Proto"].(float64))),
-			Port:        uint16(element2["Port"].(float64)),
-			HostIP:      net.ParseIP(element2["HostIP"].(string)),
-			HostPort:    uint16(element2["HostPort"].(float64)),
-			HostPortEnd: uint16(element2["HostPortEnd"].(float64)),
-		})
-	}
-
-	var exposedPorts []types.TransportPort
-	var rawExposedPorts = request.Options[netlabel.ExposedPorts].([]interface{})
-	for _, element := range rawExposedPorts {
-		element2 := element.(map[string]interface{})
-		exposedPorts = append(exposedPorts, types.TransportPort{
-			Proto: protocolValueOf(uint8(element2["Proto"].(float64))),
-			Port:  uint16(element2["Port"].(float64)),
-		})
+	mappings, exposedPorts, err := decodeExternalConnectivity(request.Options)
+	if err != nil {
+		logrus.Error(err.Error())
+		return err
 	}
 
 	var opts = make(map[string]interface{})
@@ -229,9 +410,12 @@ func (d *Driver) RevokeExternalConnectivity(request *network.RevokeExternalConne
 	return delegate.RevokeExternalConnectivity(request.NetworkID, request.EndpointID)
 }
 
-//Recover updates in-memory information on driver startup (e.g. if networks using the driver already exist)
+//Recover updates in-memory information on driver startup (e.g. if networks using the driver already exist) and
+//removes the rules of the networks deleted while the driver was down
 func (d *Driver) Recover(networks []docker.Network) {
+	var networkIDs []string
 	for _, element := range networks {
+		networkIDs = append(networkIDs, element.ID)
 		logrus.Debug("Recovering network ... ", element.ID)
 		err := d.CreateNetwork(transformNetwork(element))
 		if err != nil {
@@ -239,22 +423,232 @@ func (d *Driver) Recover(networks []docker.Network) {
 			logrus.Error(err)
 		}
 	}
+	d.pruneState(networkIDs)
+	d.removeOrphanRules()
+	utils.LogIfNotNull(d.syncTor())
 }
 
 //ShutDown shutdown hook, used to free resources
 func (d *Driver) ShutDown() {
+	d.RLock()
+	defer d.RUnlock()
 	for _, value := range d.networksIndex {
 		value.Cleanup()
 	}
 	d.removeChain()
+	if d.shared != nil {
+		utils.LogIfNotNull(d.shared.Stop())
+	}
 	(*d.tor).Shutdown()
 }
 
 func (d *Driver) init() {
 	d.createChain()
+	d.tor.OnFailure(d.torFailed)
+	if soxyNetwork.IdleTimeout() > 0 {
+		logrus.Debug("tor is started once a network relying on it is joined")
+		return
+	}
 	(*d.tor).Startup()
 }
 
+//Reconfigure applies a reloaded driver configuration, the settings that can't be changed at runtime are ignored until the driver restarts
+func (d *Driver) Reconfigure(configuration *config.Configuration) {
+	d.Lock()
+	previous := d.configuration
+	d.configuration = configuration
+	d.Unlock()
+
+	logrus.SetLevel(configuration.Level())
+	logrus.SetFormatter(configuration.Formatter())
+	audit.Configure(configuration.AuditSettings())
+	supervisor.ConfigureLogs(configuration.ProcessLogSettings())
+	secret.Configure(configuration.SecretSettings())
+	tunnel.ConfigureExec(configuration.ExecSettings())
+	if previous.DockerSocket != configuration.DockerSocket || previous.Namespace != configuration.Namespace ||
+		previous.Bridge != configuration.Bridge || previous.StatusSocket != configuration.StatusSocket ||
+		previous.ManagementSocket != configuration.ManagementSocket || previous.ManagementTokenFile != configuration.ManagementTokenFile ||
+		previous.StateDir != configuration.StateDir || previous.MetricsAddress != configuration.MetricsAddress ||
+		previous.IdleTimeout() != configuration.IdleTimeout() || previous.SharedTunnel != configuration.SharedTunnel {
+		logrus.Warn("docker socket, namespace, bridge, status socket, management API, metrics, state directory, tunnel idle timeout and shared tunnel settings changes require a driver restart")
+	}
+	if !reflect.DeepEqual(previous.LocalAddresses, configuration.LocalAddresses) {
+		d.updateLocalAddresses(previous.LocalAddresses, configuration.LocalAddresses)
+	}
+	if previous.TorConfigurationTemplate() != configuration.TorConfigurationTemplate() {
+		logrus.Info("tor configuration template changed, restarting tor")
+		if err := d.tor.Reconfigure(configuration.TorConfigurationTemplate()); err != nil {
+			logrus.Errorf("keeping the tor configuration : %v", err)
+		}
+	}
+	d.ReloadProfiles(configuration.Profiles)
+}
+
+//ReloadProfiles replaces the driver profiles and reconfigures the networks whose profile changed
+func (d *Driver) ReloadProfiles(profiles map[string]*config.Profile) {
+	d.Lock()
+	d.profiles = profiles
+	var networkContexts []*soxyNetwork.Context
+	for _, networkContext := range d.networksIndex {
+		if _, ok := networkContext.Options[config.ProfileOption]; ok {
+			networkContexts = append(networkContexts, networkContext)
+		}
+	}
+	d.Unlock()
+	for _, networkContext := range networkContexts {
+		d.reloadProfile(networkContext.ID)
+	}
+}
+
+//reloadProfile reconfigures a given network if its profile changed
+func (d *Driver) reloadProfile(networkID string) {
+	lock := d.networkLock(networkID)
+	if lock == nil {
+		return
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	networkContext := d.networkContext(networkID)
+	if networkContext == nil {
+		return
+	}
+	params, err := d.networkParams(networkContext.ID, networkContext.Options)
+	if err != nil {
+		logrus.Errorf("keeping network '%s' configuration : %v", networkContext.ID, err)
+		return
+	}
+	if reflect.DeepEqual(params, networkContext.Params()) {
+		return
+	}
+	logrus.Infof("profile '%s' changed, reconfiguring network '%s'", networkContext.Options[config.ProfileOption], networkContext.ID)
+	d.reconfigureNetwork(networkContext, params)
+}
+
+// networkContext returns the context of a given network, nil if the network isn't handled by the driver
+func (d *Driver) networkContext(networkID string) *soxyNetwork.Context {
+	d.RLock()
+	defer d.RUnlock()
+	return d.networksIndex[networkID]
+}
+
+// networkParams completes the network options with the ones of the profile they reference, if any, and applies the
+// network overrides
+func (d *Driver) networkParams(networkID string, options map[string]string) (map[string]string, error) {
+	params, err := d.profileParams(options)
+	if err != nil {
+		return nil, err
+	}
+	d.RLock()
+	overrides := d.overrides[networkID]
+	d.RUnlock()
+	return soxyNetwork.ApplyOverrides(params, overrides)
+}
+
+// profileParams completes the network options with the ones of the profile they reference, if any
+func (d *Driver) profileParams(options map[string]string) (map[string]string, error) {
+	if options == nil {
+		options = make(map[string]string)
+	}
+	if err := soxyNetwork.ValidateOptions(options); err != nil {
+		logrus.Error(err.Error())
+		return nil, err
+	}
+	name, ok := options[config.ProfileOption]
+	if !ok {
+		return options, nil
+	}
+	d.RLock()
+	profile, ok := d.profiles[name]
+	d.RUnlock()
+	if !ok {
+		return nil, utils.LogAndThrowError("unknown profile '%s'", name)
+	}
+	params := profile.Apply(options)
+	if err := soxyNetwork.ValidateOptions(params); err != nil {
+		return nil, utils.LogAndThrowError("profile '%s' : %v", name, err)
+	}
+	return params, nil
+}
+
+// reconfigureNetwork replaces a network context by a new one built from the given params, the network keeps its
+// current configuration if the new one is invalid or fails to start
+func (d *Driver) reconfigureNetwork(networkContext *soxyNetwork.Context, params map[string]string) error {
+	updated, err := soxyNetwork.NewContext(networkContext.ID, networkContext.BridgeName, params, d.tor.Port(), d.tor.DNSPort)
+	if err != nil {
+		logrus.Errorf("keeping network '%s' configuration : %v", networkContext.ID, err)
+		return err
+	}
+	//the network traffic is dropped until either context is initialized
+	release, err := networkContext.Hold()
+	if err != nil {
+		logrus.Errorf("keeping network '%s' configuration : %v", networkContext.ID, err)
+		return err
+	}
+	defer release()
+	updated.Inherit(networkContext)
+	utils.LogIfNotNull(networkContext.Cleanup())
+	if err = updated.Init(); err != nil {
+		logrus.Errorf("restoring network '%s' configuration : %v", networkContext.ID, err)
+		if restoreErr := networkContext.Init(); restoreErr != nil {
+			networkContext.Fail(fmt.Sprintf("couldn't restore the network configuration : %v", restoreErr))
+		}
+		return err
+	}
+	d.Lock()
+	current, ok := d.networksIndex[networkContext.ID]
+	if ok && current == networkContext {
+		d.networksIndex[networkContext.ID] = updated
+	}
+	d.Unlock()
+	if !ok || current != networkContext {
+		logrus.Warnf("network '%s' was deleted or replaced while being reconfigured, discarding its new configuration", networkContext.ID)
+		utils.LogIfNotNull(updated.Cleanup())
+		return &UnknownNetworkError{networkContext.ID}
+	}
+	return nil
+}
+
+// newSharedTunnel starts the transparent proxy shared by the networks if enabled, the networks are tunneled through
+// their own process when it can't be started
+func newSharedTunnel(settings config.SharedTunnel) *tunnel.Shared {
+	if !settings.Enabled {
+		return nil
+	}
+	port := settings.Port
+	if port == 0 {
+		port = utils.FindAvailablePort()
+	}
+	shared := tunnel.NewShared(settings.BindAddress, port)
+	if err := shared.Start(); err != nil {
+		logrus.Errorf("%v, the networks are tunneled through their own process", err)
+		return nil
+	}
+	return shared
+}
+
+// torFailed propagates the embedded tor instance permanent failure to the networks relying on it
+func (d *Driver) torFailed(status supervisor.Status) {
+	d.RLock()
+	defer d.RUnlock()
+	for _, networkContext := range d.networksIndex {
+		if networkContext.FallbackProxy {
+			networkContext.Fail(fmt.Sprintf("tor gave up after %d restarts, last exit code %d", status.Restarts, status.LastExitCode))
+		}
+	}
+}
+
+// removeOrphanRules removes the rules bound to other bridges than the ones of the indexed networks, it must not run
+// while a network is being created
+func (d *Driver) removeOrphanRules() {
+	d.RLock()
+	var bridges []string
+	for _, networkContext := range d.networksIndex {
+		bridges = append(bridges, networkContext.BridgeName)
+	}
+	d.RUnlock()
+	soxyNetwork.RemoveOrphanRules(bridges)
+}
+
 // utilities
 func (d *Driver) removeChain() {
 	iptables.Raw("-t", string(iptables.Nat), "-F", soxyNetwork.IptablesSoxyChain)
@@ -266,13 +660,26 @@ func (d *Driver) removeChain() {
 func (d *Driver) createChain() error {
 	//create SOXYDRIVER CHAIN
 	logrus.Debug("creating soxy-driver chain")
-	err := createChain(iptables.Nat, true)
+	err := createChain(iptables.Nat, d.configuration.LocalAddresses)
 	if err != nil {
 		logrus.Error(err.Error())
 	}
-	err = createChain(iptables.Filter, false)
+	err = createChain(iptables.Filter, nil)
 	if err != nil {
 		logrus.Error(err.Error())
 	}
 	return err
 }
+
+func (d *Driver) updateLocalAddresses(previous []string, current []string) {
+	for _, address := range previous {
+		if !contains(current, address) {
+			utils.LogIfNotNull(escapeLocalAddress(iptables.Nat, iptables.Delete, address))
+		}
+	}
+	for _, address := range current {
+		if !contains(previous, address) {
+			utils.LogIfNotNull(escapeLocalAddress(iptables.Nat, iptables.Insert, address))
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package driver
+
+import (
+	"fmt"
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"net"
+	"strconv"
+	"strings"
+)
+
+//tunnelInfoPrefix the prefix of the keys describing the endpoint tunnel in the endpoints operational data
+const tunnelInfoPrefix = "soxy.tunnel."
+
+//tunnelInfo returns the operational data describing how an endpoint traffic is tunneled
+func tunnelInfo(networkContext *soxyNetwork.Context, endpointID string) map[string]string {
+	tunnel := networkContext.EndpointTunnel(endpointID)
+	health := networkContext.Health()
+	info := map[string]string{
+		tunnelInfoPrefix + "mode":    string(networkContext.Mode().Mode),
+		tunnelInfoPrefix + "healthy": strconv.FormatBool(health.Healthy),
+	}
+	if health.Reason != "" {
+		info[tunnelInfoPrefix+"reason"] = health.Reason
+	}
+	if tunnel.Exempt {
+		info[tunnelInfoPrefix+"exempt"] = "true"
+		return info
+	}
+	if tunnel.Pending {
+		info[tunnelInfoPrefix+"pending"] = "true"
+		return info
+	}
+	info[tunnelInfoPrefix+"upstream"] = upstreamString(tunnel.Upstream)
+	info[tunnelInfoPrefix+"port"] = strconv.FormatInt(tunnel.TunnelPort, 10)
+	info[tunnelInfoPrefix+"dnsMode"] = networkContext.DNSMode
+	info[tunnelInfoPrefix+"state"] = string(tunnel.Health.State)
+	if tunnel.Profile != "" {
+		info[tunnelInfoPrefix+"profile"] = tunnel.Profile
+	}
+	return info
+}
+
+//portsInfo returns the exposed ports and port mappings of the bridge driver operational data
+func portsInfo(operInfo map[string]interface{}) map[string]string {
+	info := map[string]string{}
+	if exposedPorts, ok := operInfo[netlabel.ExposedPorts].([]types.TransportPort); ok && len(exposedPorts) != 0 {
+		var ports []string
+		for _, port := range exposedPorts {
+			ports = append(ports, fmt.Sprintf("%d/%s", port.Port, protocolName(port.Proto)))
+		}
+		info[endpointInfoPrefix+"exposedPorts"] = strings.Join(ports, ",")
+	}
+	if mappings, ok := operInfo[netlabel.PortMap].([]types.PortBinding); ok && len(mappings) != 0 {
+		var ports []string
+		for _, mapping := range mappings {
+			host := net.JoinHostPort(ipString(mapping.HostIP), strconv.Itoa(int(mapping.HostPort)))
+			if mapping.HostPortEnd > mapping.HostPort {
+				host += "-" + strconv.Itoa(int(mapping.HostPortEnd))
+			}
+			ports = append(ports, fmt.Sprintf("%s->%d/%s", host, mapping.Port, protocolName(mapping.Proto)))
+		}
+		info[endpointInfoPrefix+"portMappings"] = strings.Join(ports, ",")
+	}
+	return info
+}
+
+func upstreamString(upstream soxyNetwork.Upstream) string {
+	address := net.JoinHostPort(upstream.Address, strconv.FormatInt(upstream.Port, 10))
+	switch {
+	case upstream.Fallback:
+		return "tor " + address
+	case upstream.Type != "":
+		return upstream.Type + " " + address
+	}
+	return address
+}
+
+func protocolName(protocol types.Protocol) string {
+	switch protocol {
+	case types.TCP:
+		return "tcp"
+	case types.UDP:
+		return "udp"
+	case types.ICMP:
+		return "icmp"
+	}
+	return strconv.Itoa(int(protocol))
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return "0.0.0.0"
+	}
+	return ip.String()
+}
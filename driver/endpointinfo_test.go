@@ -0,0 +1,31 @@
+package driver
+
+import (
+	"github.com/docker/libnetwork/netlabel"
+	"github.com/docker/libnetwork/types"
+	"github.com/stretchr/testify/assert"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"net"
+	"testing"
+)
+
+func TestPortsInfo(t *testing.T) {
+	info := portsInfo(map[string]interface{}{
+		netlabel.ExposedPorts: []types.TransportPort{{Proto: types.TCP, Port: 80}, {Proto: types.UDP, Port: 53}},
+		netlabel.PortMap: []types.PortBinding{
+			{Proto: types.TCP, Port: 80, HostPort: 8080},
+			{Proto: types.UDP, Port: 53, HostIP: net.ParseIP("127.0.0.1"), HostPort: 5300, HostPortEnd: 5301},
+		},
+	})
+	assert.Equal(t, map[string]string{
+		"soxy.endpoint.exposedPorts": "80/tcp,53/udp",
+		"soxy.endpoint.portMappings": "0.0.0.0:8080->80/tcp,127.0.0.1:5300-5301->53/udp",
+	}, info)
+	assert.Empty(t, portsInfo(map[string]interface{}{}))
+}
+
+func TestUpstreamString(t *testing.T) {
+	assert.Equal(t, "tor localhost:9050", upstreamString(soxyNetwork.Upstream{Address: "localhost", Port: 9050, Fallback: true}))
+	assert.Equal(t, "socks5 proxy.corp:1080", upstreamString(soxyNetwork.Upstream{Address: "proxy.corp", Port: 1080, Type: "socks5"}))
+	assert.Equal(t, "proxy.corp:3128", upstreamString(soxyNetwork.Upstream{Address: "proxy.corp", Port: 3128}))
+}
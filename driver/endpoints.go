@@ -0,0 +1,169 @@
+package driver
+
+import (
+	"github.com/sirupsen/logrus"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//endpointInfoPrefix the prefix of the keys the driver adds to the endpoints operational data
+const endpointInfoPrefix = "soxy.endpoint."
+
+//Endpoint a network endpoint as recorded by the driver
+type Endpoint struct {
+	ID          string `json:"id"`
+	NetworkID   string `json:"networkId"`
+	IPv4Address string `json:"ipv4Address,omitempty"`
+	IPv6Address string `json:"ipv6Address,omitempty"`
+	MacAddress  string `json:"macAddress,omitempty"`
+	//SandboxKey the network namespace of the container the endpoint joined, while joined
+	SandboxKey string `json:"sandboxKey,omitempty"`
+	//ContainerID the container the endpoint belongs to, once resolved
+	ContainerID string    `json:"containerId,omitempty"`
+	Joined      bool      `json:"joined"`
+	CreatedAt   time.Time `json:"createdAt"`
+	JoinedAt    time.Time `json:"joinedAt,omitempty"`
+}
+
+//info returns the endpoint registry data as endpoint operational data
+func (endpoint Endpoint) info() map[string]string {
+	info := map[string]string{
+		endpointInfoPrefix + "joined": strconv.FormatBool(endpoint.Joined),
+	}
+	values := map[string]string{
+		"ipv4Address": endpoint.IPv4Address,
+		"ipv6Address": endpoint.IPv6Address,
+		"sandboxKey":  endpoint.SandboxKey,
+		"containerId": endpoint.ContainerID,
+	}
+	for key, value := range values {
+		if value != "" {
+			info[endpointInfoPrefix+key] = value
+		}
+	}
+	return info
+}
+
+//Endpoints returns the endpoints of the driver networks, sorted by network and id
+func (d *Driver) Endpoints() []Endpoint {
+	d.RLock()
+	defer d.RUnlock()
+	endpoints := make([]Endpoint, 0, len(d.endpoints))
+	for _, endpoint := range d.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].NetworkID != endpoints[j].NetworkID {
+			return endpoints[i].NetworkID < endpoints[j].NetworkID
+		}
+		return endpoints[i].ID < endpoints[j].ID
+	})
+	return endpoints
+}
+
+//endpoint returns the record of a given endpoint
+func (d *Driver) endpoint(endpointID string) (Endpoint, bool) {
+	d.RLock()
+	defer d.RUnlock()
+	endpoint, ok := d.endpoints[endpointID]
+	return endpoint, ok
+}
+
+//recordEndpoint records a created endpoint
+func (d *Driver) recordEndpoint(endpoint Endpoint) {
+	d.Lock()
+	d.endpoints[endpoint.ID] = endpoint
+	d.Unlock()
+	utils.LogIfNotNull(d.saveEndpoints())
+}
+
+//updateEndpoint updates the record of a given endpoint, it is a no-op if the endpoint isn't recorded or unchanged
+func (d *Driver) updateEndpoint(endpointID string, update func(endpoint *Endpoint)) {
+	d.Lock()
+	endpoint, ok := d.endpoints[endpointID]
+	if !ok {
+		d.Unlock()
+		return
+	}
+	updated := endpoint
+	update(&updated)
+	changed := updated != endpoint
+	d.endpoints[endpointID] = updated
+	d.Unlock()
+	if changed {
+		utils.LogIfNotNull(d.saveEndpoints())
+	}
+}
+
+//forgetEndpoints forgets the endpoints matching a given predicate
+func (d *Driver) forgetEndpoints(forget func(endpoint Endpoint) bool) {
+	d.Lock()
+	forgotten := false
+	for endpointID, endpoint := range d.endpoints {
+		if forget(endpoint) {
+			delete(d.endpoints, endpointID)
+			forgotten = true
+		}
+	}
+	d.Unlock()
+	if forgotten {
+		utils.LogIfNotNull(d.saveEndpoints())
+	}
+}
+
+//syncEndpoint records an endpoint docker reports on one of the driver networks, e.g. one created before the driver
+//recorded endpoints, and the container it belongs to
+func (d *Driver) syncEndpoint(networkID string, endpointID string, containerID string, address net.IP, mac string) {
+	if _, ok := d.endpoint(endpointID); !ok {
+		endpoint := Endpoint{ID: endpointID, NetworkID: networkID, MacAddress: mac, Joined: true, CreatedAt: time.Now()}
+		if address != nil {
+			endpoint.IPv4Address = address.String()
+		}
+		d.recordEndpoint(endpoint)
+	}
+	d.updateEndpoint(endpointID, func(endpoint *Endpoint) {
+		endpoint.ContainerID = containerID
+	})
+}
+
+//restoreEndpoints hands the recorded endpoints of a recovered network over to its context, starting its tunnel if one of
+//them is joined
+func (d *Driver) restoreEndpoints(networkContext *soxyNetwork.Context) {
+	d.RLock()
+	var endpoints []Endpoint
+	for _, endpoint := range d.endpoints {
+		if endpoint.NetworkID == networkContext.ID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	d.RUnlock()
+	//activating an endpoint starts the network tunnel, the driver lock isn't held meanwhile
+	for _, endpoint := range endpoints {
+		if address := net.ParseIP(endpoint.IPv4Address); address != nil {
+			networkContext.AddEndpoint(endpoint.ID, address)
+		}
+		if endpoint.Joined {
+			utils.LogIfNotNull(networkContext.Activate(endpoint.ID))
+		}
+	}
+}
+
+func (d *Driver) saveEndpoints() error {
+	d.RLock()
+	defer d.RUnlock()
+	if err := d.endpointsStore.Save(d.endpoints); err != nil {
+		return utils.LogAndThrowError("couldn't persist the endpoints : %v", err)
+	}
+	return nil
+}
+
+//loadEndpoints loads the endpoints recorded before the driver restarted
+func (d *Driver) loadEndpoints() {
+	if err := d.endpointsStore.Load(&d.endpoints); err != nil {
+		logrus.Errorf("couldn't load the endpoints from '%s' : %v", d.endpointsStore.Path(), err)
+	}
+}
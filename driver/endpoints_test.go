@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/state"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEndpointsRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy-endpoints")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := state.NewStore(filepath.Join(dir, "endpoints.json"))
+	driver := &Driver{endpoints: map[string]Endpoint{}, endpointsStore: store}
+
+	driver.recordEndpoint(Endpoint{ID: "b-endpoint", NetworkID: "network", IPv4Address: "172.30.1.3"})
+	driver.recordEndpoint(Endpoint{ID: "a-endpoint", NetworkID: "network", IPv4Address: "172.30.1.2"})
+	driver.updateEndpoint("a-endpoint", func(endpoint *Endpoint) {
+		endpoint.Joined, endpoint.SandboxKey, endpoint.ContainerID = true, "/var/run/docker/netns/4f1e0d6a5b2c", "c0ffee"
+	})
+	endpoints := driver.Endpoints()
+	assert.Equal(t, []string{"a-endpoint", "b-endpoint"}, []string{endpoints[0].ID, endpoints[1].ID})
+	assert.Equal(t, map[string]string{
+		"soxy.endpoint.joined":      "true",
+		"soxy.endpoint.ipv4Address": "172.30.1.2",
+		"soxy.endpoint.sandboxKey":  "/var/run/docker/netns/4f1e0d6a5b2c",
+		"soxy.endpoint.containerId": "c0ffee",
+	}, endpoints[0].info())
+
+	//the registry is persisted
+	restarted := &Driver{endpoints: map[string]Endpoint{}, endpointsStore: store}
+	restarted.loadEndpoints()
+	assert.Equal(t, endpoints, restarted.Endpoints())
+
+	restarted.forgetEndpoints(func(endpoint Endpoint) bool { return endpoint.ID == "b-endpoint" })
+	_, ok := restarted.endpoint("b-endpoint")
+	assert.False(t, ok)
+}
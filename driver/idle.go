@@ -0,0 +1,96 @@
+package driver
+
+import (
+	"github.com/sirupsen/logrus"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/utils"
+	"time"
+)
+
+//activate records an endpoint joined to a network, starting the network tunnel and the embedded tor instance if it
+//relies on them and they were left stopped
+func (d *Driver) activate(networkContext *soxyNetwork.Context, endpointID string) error {
+	if err := networkContext.Activate(endpointID); err != nil {
+		return err
+	}
+	if err := d.syncTor(); err != nil {
+		networkContext.Deactivate(endpointID)
+		return err
+	}
+	return nil
+}
+
+//deactivate records an endpoint left a network, its tunnel and the embedded tor instance are stopped once idle
+func (d *Driver) deactivate(networkContext *soxyNetwork.Context, endpointID string) {
+	networkContext.Deactivate(endpointID)
+	utils.LogIfNotNull(d.syncTor())
+}
+
+//syncTor starts the embedded tor instance when a network a container is joined to relies on it, and stops it once none
+//did for the idle timeout. It is a no-op unless an idle timeout is set, tor being started with the driver otherwise
+func (d *Driver) syncTor() error {
+	timeout := soxyNetwork.IdleTimeout()
+	if timeout <= 0 {
+		return nil
+	}
+	needed := d.torNeeded()
+	d.torLock.Lock()
+	defer d.torLock.Unlock()
+	stopped := d.tor.Status().State == supervisor.Stopped
+	if needed {
+		d.cancelTorStop()
+		if stopped {
+			logrus.Info("starting tor as a network relying on it was joined")
+			return d.tor.Startup()
+		}
+		return nil
+	}
+	if d.torTimer != nil || stopped {
+		return nil
+	}
+	generation := d.torGeneration
+	d.torTimer = time.AfterFunc(timeout, func() { d.stopIdleTor(generation) })
+	return nil
+}
+
+//torNeeded whether a network a container is joined to relies on the embedded tor instance
+func (d *Driver) torNeeded() bool {
+	d.RLock()
+	defer d.RUnlock()
+	for _, networkContext := range d.networksIndex {
+		if networkContext.Active() && networkContext.UsesTor() {
+			return true
+		}
+	}
+	return false
+}
+
+//torIdle whether the embedded tor instance is stopped, or about to be, as no network a container is joined to relies on it
+func (d *Driver) torIdle() bool {
+	return soxyNetwork.IdleTimeout() > 0 && !d.torNeeded()
+}
+
+//cancelTorStop cancels the pending stop of the embedded tor instance, if any, the caller must hold the tor lock
+func (d *Driver) cancelTorStop() {
+	d.torGeneration++
+	if d.torTimer != nil {
+		d.torTimer.Stop()
+		d.torTimer = nil
+	}
+}
+
+//stopIdleTor stops the embedded tor instance unless a network relying on it was joined since the stop was scheduled
+func (d *Driver) stopIdleTor(generation uint64) {
+	d.torLock.Lock()
+	defer d.torLock.Unlock()
+	if generation != d.torGeneration {
+		return
+	}
+	d.torTimer = nil
+	if d.torNeeded() {
+		return
+	}
+	logrus.Infof("no network relied on tor for %s, stopping it", soxyNetwork.IdleTimeout())
+	utils.LogIfNotNull(d.tor.Stop())
+}
@@ -0,0 +1,159 @@
+package driver
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/tor"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const upstreamProbeTimeout = 2 * time.Second
+
+//upstreamProbeTTL the delay during which the result of an upstream probe is served rather than probing it again
+const upstreamProbeTTL = 30 * time.Second
+
+//NetworkMetrics a network state along with the counters of its rules
+type NetworkMetrics struct {
+	soxyNetwork.Status
+	Counters []soxyNetwork.RuleCounter
+	//UpstreamReachable whether the network upstream proxy accepts connections
+	UpstreamReachable bool
+}
+
+//Metrics a snapshot of the driver state and counters
+type Metrics struct {
+	Tor TorStatus
+	//TorControl the tor bootstrap progress and circuits, nil if the tor control port can't be queried
+	TorControl *tor.ControlInfo
+	Networks   []NetworkMetrics
+}
+
+//Metrics returns a snapshot of the driver state and counters : the driver chain counters are read once per table and
+//every distinct upstream is probed once, unless it was recently
+func (d *Driver) Metrics() Metrics {
+	torStatus := d.tor.Status()
+	metrics := Metrics{
+		Tor: TorStatus{
+			SocksPort: d.tor.Port(),
+			DNSPort:   d.tor.DNSPort,
+			Process:   torStatus,
+			Uptime:    torStatus.Uptime().Round(time.Second).String(),
+		},
+	}
+	controlInfo, err := d.tor.ControlInfo()
+	if err != nil {
+		logrus.Debugf("couldn't query the tor control port : %v", err)
+	} else {
+		metrics.TorControl = controlInfo
+	}
+
+	counters := make(map[string][]soxyNetwork.RuleCounter)
+	for _, table := range []iptables.Table{iptables.Nat, iptables.Filter} {
+		tableCounters, err := soxyNetwork.ReadCounters(table)
+		if err != nil {
+			logrus.Debugf("couldn't read the '%s' table counters : %v", table, err)
+			continue
+		}
+		for bridge, bridgeCounters := range tableCounters {
+			counters[bridge] = append(counters[bridge], bridgeCounters...)
+		}
+	}
+
+	d.RLock()
+	networkContexts := make([]*soxyNetwork.Context, 0, len(d.networksIndex))
+	for _, networkContext := range d.networksIndex {
+		networkContexts = append(networkContexts, networkContext)
+	}
+	d.RUnlock()
+	upstreams := make(map[string]bool)
+	for _, networkContext := range networkContexts {
+		status := networkContext.Snapshot()
+		upstreams[upstreamAddress(status.Upstream)] = false
+		metrics.Networks = append(metrics.Networks, NetworkMetrics{Status: status, Counters: counters[status.Bridge]})
+	}
+	d.probes.probe(upstreams)
+	for i := range metrics.Networks {
+		metrics.Networks[i].UpstreamReachable = upstreams[upstreamAddress(metrics.Networks[i].Upstream)]
+	}
+	sort.Slice(metrics.Networks, func(i, j int) bool { return metrics.Networks[i].ID < metrics.Networks[j].ID })
+	return metrics
+}
+
+func upstreamAddress(upstream soxyNetwork.Upstream) string {
+	return net.JoinHostPort(upstream.Address, strconv.FormatInt(upstream.Port, 10))
+}
+
+//upstreamProbe the result of an upstream probe
+type upstreamProbe struct {
+	reachable bool
+	probedAt  time.Time
+}
+
+//upstreamProbes the results of the latest upstream probes, served until they are older than a given delay so that the
+//scrapes don't dial every upstream
+type upstreamProbes struct {
+	ttl     time.Duration
+	results map[string]upstreamProbe
+	sync.Mutex
+}
+
+func newUpstreamProbes(ttl time.Duration) *upstreamProbes {
+	return &upstreamProbes{ttl: ttl, results: make(map[string]upstreamProbe)}
+}
+
+//probe sets whether the given upstreams accept connections, concurrently probing the ones whose latest result expired.
+//The results of the upstreams that aren't given anymore are dropped
+func (p *upstreamProbes) probe(upstreams map[string]bool) {
+	now := time.Now()
+	p.Lock()
+	expired := make(map[string]bool)
+	for address := range upstreams {
+		if result, ok := p.results[address]; ok && now.Sub(result.probedAt) < p.ttl {
+			upstreams[address] = result.reachable
+		} else {
+			expired[address] = false
+		}
+	}
+	for address := range p.results {
+		if _, ok := upstreams[address]; !ok {
+			delete(p.results, address)
+		}
+	}
+	p.Unlock()
+	probeUpstreams(expired)
+	p.Lock()
+	defer p.Unlock()
+	for address, reachable := range expired {
+		upstreams[address] = reachable
+		p.results[address] = upstreamProbe{reachable: reachable, probedAt: now}
+	}
+}
+
+//probeUpstreams checks concurrently whether the given upstreams accept connections
+func probeUpstreams(upstreams map[string]bool) {
+	addresses := make([]string, 0, len(upstreams))
+	for address := range upstreams {
+		addresses = append(addresses, address)
+	}
+	var lock sync.Mutex
+	var wait sync.WaitGroup
+	for _, address := range addresses {
+		wait.Add(1)
+		go func(address string) {
+			defer wait.Done()
+			connection, err := net.DialTimeout("tcp", address, upstreamProbeTimeout)
+			if err == nil {
+				connection.Close()
+			}
+			lock.Lock()
+			upstreams[address] = err == nil
+			lock.Unlock()
+		}(address)
+	}
+	wait.Wait()
+}
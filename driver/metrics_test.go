@@ -0,0 +1,33 @@
+package driver
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUpstreamProbesAreCached(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	address := listener.Addr().String()
+	probes := newUpstreamProbes(time.Hour)
+
+	upstreams := map[string]bool{address: false}
+	probes.probe(upstreams)
+	assert.True(t, upstreams[address])
+
+	//the latest result is served until it expires
+	listener.Close()
+	upstreams = map[string]bool{address: false}
+	probes.probe(upstreams)
+	assert.True(t, upstreams[address])
+
+	probes.ttl = 0
+	probes.probe(upstreams)
+	assert.False(t, upstreams[address])
+
+	//the results of the upstreams no network relies on anymore are dropped
+	probes.probe(map[string]bool{})
+	assert.Empty(t, probes.results)
+}
@@ -0,0 +1,150 @@
+package driver
+
+import (
+	"fmt"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/utils"
+	"sync"
+)
+
+//UnknownNetworkError a network the driver doesn't handle
+type UnknownNetworkError struct {
+	NetworkID string
+}
+
+func (e *UnknownNetworkError) Error() string {
+	return fmt.Sprintf("unknown network '%s'", e.NetworkID)
+}
+
+//Overrides returns the options changed at runtime on a given network
+func (d *Driver) Overrides(networkID string) (map[string]string, error) {
+	d.RLock()
+	defer d.RUnlock()
+	if _, ok := d.networksIndex[networkID]; !ok {
+		return nil, &UnknownNetworkError{networkID}
+	}
+	overrides := make(map[string]string)
+	for key, value := range d.overrides[networkID] {
+		overrides[key] = value
+	}
+	return overrides, nil
+}
+
+//SetOverrides replaces the options changed at runtime on a given network, reconfiguring its tunnel and rules in place :
+//neither the network bridge nor its endpoints are affected. The overrides are persisted across the driver restarts
+func (d *Driver) SetOverrides(networkID string, overrides map[string]string) error {
+	if err := soxyNetwork.ValidateOverrides(overrides); err != nil {
+		return err
+	}
+	lock := d.networkLock(networkID)
+	if lock == nil {
+		return &UnknownNetworkError{networkID}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return d.setOverrides(networkID, overrides)
+}
+
+//PatchOverrides merges options into the ones changed at runtime on a given network, a nil value removing an override,
+//then reconfigures the network as SetOverrides does. The current overrides can't change in between
+func (d *Driver) PatchOverrides(networkID string, patch map[string]*string) error {
+	lock := d.networkLock(networkID)
+	if lock == nil {
+		return &UnknownNetworkError{networkID}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	overrides, err := d.Overrides(networkID)
+	if err != nil {
+		return err
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(overrides, key)
+		} else {
+			overrides[key] = *value
+		}
+	}
+	if err = soxyNetwork.ValidateOverrides(overrides); err != nil {
+		return err
+	}
+	return d.setOverrides(networkID, overrides)
+}
+
+//setOverrides reconfigures a given network with given overrides and persists them, the caller must hold the network lock
+func (d *Driver) setOverrides(networkID string, overrides map[string]string) error {
+	networkContext := d.networkContext(networkID)
+	if networkContext == nil {
+		return &UnknownNetworkError{networkID}
+	}
+	params, err := d.profileParams(networkContext.Options)
+	if err != nil {
+		return err
+	}
+	params, err = soxyNetwork.ApplyOverrides(params, overrides)
+	if err != nil {
+		return err
+	}
+	if err = d.reconfigureNetwork(networkContext, params); err != nil {
+		return err
+	}
+	d.Lock()
+	if len(overrides) == 0 {
+		delete(d.overrides, networkID)
+	} else {
+		d.overrides[networkID] = overrides
+	}
+	d.Unlock()
+	return d.saveOverrides()
+}
+
+// pruneState forgets the overrides, the pauses and the endpoints of the networks that don't exist anymore
+func (d *Driver) pruneState(existing []string) {
+	d.Lock()
+	prunedOverrides, prunedPauses := false, false
+	for networkID := range d.overrides {
+		if !contains(existing, networkID) {
+			delete(d.overrides, networkID)
+			prunedOverrides = true
+		}
+	}
+	for networkID := range d.pauses {
+		if !contains(existing, networkID) {
+			delete(d.pauses, networkID)
+			prunedPauses = true
+		}
+	}
+	d.Unlock()
+	d.forgetEndpoints(func(endpoint Endpoint) bool { return !contains(existing, endpoint.NetworkID) })
+	if prunedOverrides {
+		utils.LogIfNotNull(d.saveOverrides())
+	}
+	if prunedPauses {
+		utils.LogIfNotNull(d.savePauses())
+	}
+}
+
+//networkLock returns the mutex serializing the reconfigurations, the pauses and the deletion of a given network, from
+//reading its current settings to persisting the new ones. It is nil if the driver doesn't handle the network
+func (d *Driver) networkLock(networkID string) *sync.Mutex {
+	d.Lock()
+	defer d.Unlock()
+	if _, ok := d.networksIndex[networkID]; !ok {
+		return nil
+	}
+	lock, ok := d.networkLocks[networkID]
+	if !ok {
+		lock = &sync.Mutex{}
+		d.networkLocks[networkID] = lock
+	}
+	return lock
+}
+
+func (d *Driver) saveOverrides() error {
+	d.RLock()
+	defer d.RUnlock()
+	if err := d.overridesStore.Save(d.overrides); err != nil {
+		return utils.LogAndThrowError("couldn't persist the network overrides : %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package driver
+
+import (
+	"github.com/stretchr/testify/assert"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"sync"
+	"testing"
+)
+
+func TestNetworkLocks(t *testing.T) {
+	driver := &Driver{networksIndex: map[string]*soxyNetwork.Context{"network": {ID: "network"}}, networkLocks: map[string]*sync.Mutex{}}
+	lock := driver.networkLock("network")
+	assert.NotNil(t, lock)
+	assert.True(t, lock == driver.networkLock("network"))
+
+	//the networks the driver doesn't handle get no lock
+	assert.Nil(t, driver.networkLock("unknown"))
+	assert.IsType(t, &UnknownNetworkError{}, driver.SetOverrides("unknown", nil))
+	assert.IsType(t, &UnknownNetworkError{}, driver.PatchOverrides("unknown", nil))
+	assert.IsType(t, &UnknownNetworkError{}, driver.Pause("unknown", soxyNetwork.DropMode, false))
+	assert.IsType(t, &UnknownNetworkError{}, driver.Resume("unknown"))
+	assert.Len(t, driver.networkLocks, 1)
+}
@@ -0,0 +1,80 @@
+package driver
+
+import (
+	"github.com/sirupsen/logrus"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/utils"
+)
+
+//Pause stops tunneling a given network traffic without recreating it : the traffic is either let through untunneled
+//(direct mode) or dropped (drop mode). The tunnel process is stopped unless it is kept. The pause is persisted across
+//the driver restarts
+func (d *Driver) Pause(networkID string, mode soxyNetwork.Mode, keepTunnel bool) error {
+	if mode == soxyNetwork.TunnelMode {
+		return d.Resume(networkID)
+	}
+	if _, err := soxyNetwork.ParseMode(string(mode)); err != nil {
+		return err
+	}
+	//the context is read once the network lock is held, so that it isn't replaced by a reconfiguration meanwhile
+	lock := d.networkLock(networkID)
+	if lock == nil {
+		return &UnknownNetworkError{networkID}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	networkContext := d.networkContext(networkID)
+	if networkContext == nil {
+		return &UnknownNetworkError{networkID}
+	}
+	if err := networkContext.SetMode(mode, keepTunnel); err != nil {
+		return err
+	}
+	d.Lock()
+	d.pauses[networkID] = soxyNetwork.Pause{Mode: mode, KeepTunnel: keepTunnel}
+	d.Unlock()
+	return d.savePauses()
+}
+
+//Resume tunnels a paused network traffic again
+func (d *Driver) Resume(networkID string) error {
+	lock := d.networkLock(networkID)
+	if lock == nil {
+		return &UnknownNetworkError{networkID}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	networkContext := d.networkContext(networkID)
+	if networkContext == nil {
+		return &UnknownNetworkError{networkID}
+	}
+	if err := networkContext.SetMode(soxyNetwork.TunnelMode, false); err != nil {
+		return err
+	}
+	d.Lock()
+	delete(d.pauses, networkID)
+	d.Unlock()
+	return d.savePauses()
+}
+
+//restorePause initializes a recovered network in the mode it was paused in before the driver restarted, so that its
+//traffic isn't tunneled in between. It has to be called before the network context is initialized
+func (d *Driver) restorePause(networkContext *soxyNetwork.Context) {
+	d.RLock()
+	pause, ok := d.pauses[networkContext.ID]
+	d.RUnlock()
+	if !ok {
+		return
+	}
+	logrus.Infof("network '%s' was paused, restoring it in mode '%s'", networkContext.ID, pause.Mode)
+	networkContext.InitMode(pause)
+}
+
+func (d *Driver) savePauses() error {
+	d.RLock()
+	defer d.RUnlock()
+	if err := d.pausesStore.Save(d.pauses); err != nil {
+		return utils.LogAndThrowError("couldn't persist the paused networks : %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package driver
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/config"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/utils"
+)
+
+//ContainerResolver resolves the container an endpoint being joined belongs to, by the endpoint or the network namespace
+//of the container
+type ContainerResolver interface {
+	ResolveContainer(networkID string, endpointID string, sandboxKey string) (*soxyNetwork.Container, error)
+}
+
+//SetContainerResolver sets how the containers joining a network are resolved, their labels policies aren't applied on
+//join without one
+func (d *Driver) SetContainerResolver(resolver ContainerResolver) {
+	d.Lock()
+	defer d.Unlock()
+	d.resolver = resolver
+}
+
+//joinPolicy applies the policy set by the labels of the container joining a network and returns that container, nil if
+//it can't be resolved yet : its traffic is then dropped until the watcher maps it, rather than tunneled as if it had no
+//labels
+func (d *Driver) joinPolicy(networkContext *soxyNetwork.Context, endpointID string, sandboxKey string) (*soxyNetwork.Container, error) {
+	d.RLock()
+	resolver := d.resolver
+	d.RUnlock()
+	if resolver == nil {
+		return nil, nil
+	}
+	container, err := resolver.ResolveContainer(networkContext.ID, endpointID, sandboxKey)
+	if err != nil {
+		logrus.Debugf("couldn't resolve the container of endpoint '%s' : %v", endpointID, err)
+		return nil, networkContext.SetEndpointPolicy(endpointID, soxyNetwork.Policy{Pending: true})
+	}
+	return container, d.applyPolicy(networkContext, endpointID, *container)
+}
+
+//applyPolicy applies the policy set by the labels of a container to the traffic of its endpoint
+func (d *Driver) applyPolicy(networkContext *soxyNetwork.Context, endpointID string, container soxyNetwork.Container) error {
+	policy, err := soxyNetwork.ParsePolicy(container.Labels)
+	if err != nil {
+		return utils.LogAndThrowError("container '%s' : %v", container.Name, err)
+	}
+	if policy.Profile != "" {
+		policy.ProfileParams, err = d.profileParams(map[string]string{config.ProfileOption: policy.Profile})
+		if err != nil {
+			return utils.LogAndThrowError("container '%s' : %v", container.Name, err)
+		}
+	}
+	return networkContext.SetEndpointPolicy(endpointID, policy)
+}
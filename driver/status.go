@@ -0,0 +1,115 @@
+package driver
+
+import (
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/supervisor"
+	"sort"
+	"time"
+)
+
+//TorStatus the embedded tor instance state
+type TorStatus struct {
+	SocksPort int64             `json:"socksPort"`
+	DNSPort   int64             `json:"dnsPort"`
+	Process   supervisor.Status `json:"process"`
+	Uptime    string            `json:"uptime"`
+	//Idle whether tor is stopped, or about to be, as no network a container is joined to relies on it
+	Idle bool `json:"idle"`
+}
+
+//SharedTunnelStatus the state of the transparent proxy shared by the networks
+type SharedTunnelStatus struct {
+	Port    int64             `json:"port"`
+	Process supervisor.Status `json:"process"`
+	//Routes the source networks routed through the shared tunnel
+	Routes []string `json:"routes"`
+}
+
+//Status a snapshot of the driver state
+type Status struct {
+	//Healthy whether tor hasn't been given up on and every network traffic is being tunneled
+	Healthy  bool                 `json:"healthy"`
+	Tor      TorStatus            `json:"tor"`
+	Networks []soxyNetwork.Status `json:"networks"`
+	//SharedTunnel the shared tunnel state, if enabled
+	SharedTunnel *SharedTunnelStatus `json:"sharedTunnel,omitempty"`
+	//Endpoints the endpoints registry
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+//Status returns a snapshot of the driver state
+func (d *Driver) Status() Status {
+	torStatus := d.tor.Status()
+	status := Status{
+		Tor: TorStatus{
+			SocksPort: d.tor.Port(),
+			DNSPort:   d.tor.DNSPort,
+			Process:   torStatus,
+			Uptime:    torStatus.Uptime().Round(time.Second).String(),
+			Idle:      d.torIdle(),
+		},
+		Networks: []soxyNetwork.Status{},
+	}
+	status.Healthy = torStatus.State != supervisor.Failed
+	if d.shared != nil {
+		routes := d.shared.Routes()
+		sort.Strings(routes)
+		status.SharedTunnel = &SharedTunnelStatus{Port: d.shared.Port, Process: d.shared.Status(), Routes: routes}
+	}
+	for _, networkContext := range d.networkContexts() {
+		networkStatus := networkContext.Status()
+		status.Healthy = status.Healthy && networkStatus.Health.Healthy
+		status.Networks = append(status.Networks, networkStatus)
+	}
+	sort.Slice(status.Networks, func(i, j int) bool { return status.Networks[i].ID < status.Networks[j].ID })
+	status.Endpoints = d.Endpoints()
+	return status
+}
+
+//Healthy returns whether tor hasn't been given up on and every network traffic is being tunneled, without checking the
+//networks iptables rules
+func (d *Driver) Healthy() bool {
+	if d.tor.Status().State == supervisor.Failed {
+		return false
+	}
+	for _, networkContext := range d.networkContexts() {
+		if !networkContext.Snapshot().Health.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+//networkContexts returns the network contexts, copied so that they are inspected without holding the driver lock
+func (d *Driver) networkContexts() []*soxyNetwork.Context {
+	d.RLock()
+	defer d.RUnlock()
+	networkContexts := make([]*soxyNetwork.Context, 0, len(d.networksIndex))
+	for _, networkContext := range d.networksIndex {
+		networkContexts = append(networkContexts, networkContext)
+	}
+	return networkContexts
+}
+
+//NetworkIDs returns the sorted ids of the networks, without inspecting them
+func (d *Driver) NetworkIDs() []string {
+	d.RLock()
+	defer d.RUnlock()
+	ids := make([]string, 0, len(d.networksIndex))
+	for id := range d.networksIndex {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+//NetworkStatus returns the state of a single network
+func (d *Driver) NetworkStatus(networkID string) (soxyNetwork.Status, error) {
+	d.RLock()
+	networkContext, ok := d.networksIndex[networkID]
+	d.RUnlock()
+	if !ok {
+		return soxyNetwork.Status{}, &UnknownNetworkError{networkID}
+	}
+	return networkContext.Status(), nil
+}
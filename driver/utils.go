@@ -2,85 +2,57 @@ package driver
 
 import (
 	"fmt"
+	"github.com/docker/libnetwork/drivers/bridge"
 	"github.com/docker/libnetwork/iptables"
-	"github.com/docker/libnetwork/netlabel"
-	"github.com/docker/libnetwork/types"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
 	soxyNetwork "github.com/yassine/soxy-driver/network"
-	"net"
 )
 
-func findLinkByAddress(address string) (netlink.Link, error) {
-	links, err := netlink.LinkList()
-	if err != nil {
-		return nil, err
-	}
-	for _, link := range links {
-		addresses, _ := netlink.AddrList(link, netlink.FAMILY_ALL)
-		for _, addr := range addresses {
-			if addr.Contains(net.ParseIP(address)) {
-				return link, nil
-			}
-			// TODO IPv6?
-		}
-	}
-	return nil, fmt.Errorf("link having address '%s' not found", address)
-}
-
-func parseNetworkOptions(data map[string]interface{}) map[string]interface{} {
-	if genData, ok := data[netlabel.GenericData]; ok && genData != nil {
-		result := make(map[string]string)
-		for key, value := range data[netlabel.GenericData].(map[string]interface{}) {
-			result[key] = value.(string)
+//findBridge returns the bridge the bridge driver allocated to a given network : either the one named through the
+//'com.docker.network.bridge.name' option or 'br-' followed by the network id prefix
+func findBridge(networkID string, options map[string]string) (netlink.Link, error) {
+	name := options[bridge.BridgeName]
+	if name == "" {
+		if len(networkID) < 12 {
+			return nil, fmt.Errorf("invalid network id '%s'", networkID)
 		}
-		data[netlabel.GenericData] = result
+		name = "br-" + networkID[:12]
 	}
-	return data
+	return netlink.LinkByName(name)
 }
 
-func protocolValueOf(val uint8) types.Protocol {
-	if val == types.TCP {
-		return types.TCP
-	}
-	if val == types.UDP {
-		return types.UDP
-	}
-	if val == types.ICMP {
-		return types.ICMP
-	}
-	return types.ICMP
-}
-
-func createChain(table iptables.Table, escapeLocal bool) error {
+func createChain(table iptables.Table, localAddresses []string) error {
 	args := []string{"-t", string(table), "-N", soxyNetwork.IptablesSoxyChain}
 	if output, err := iptables.Raw(args...); err != nil || len(output) != 0 {
 		logrus.Debug(fmt.Errorf("couldn't setup soxychain chain in table '%s' : %s", table, err).Error())
 	}
-	if escapeLocal {
-		for _, address := range LocalAddresses {
-			args = []string{"-t", string(table), string(iptables.Insert), soxyNetwork.IptablesSoxyChain,
-				"-d", address,
-				"-j", "RETURN"}
-			if output, err := iptables.Raw(args...); err != nil || len(output) != 0 {
-				logrus.Errorf("couldn't setup in table %s soxychain local addresss escape : %v", address, table)
-				return err
-			}
+	for _, address := range localAddresses {
+		if err := escapeLocalAddress(table, iptables.Insert, address); err != nil {
+			logrus.Errorf("couldn't setup in table %s soxychain local addresss escape : %v", address, table)
+			return err
 		}
 	}
 	return nil
 }
 
-var (
-	//LocalAddresses reserved local addresses
-	LocalAddresses = []string{
-		"0.0.0.0/8",
-		"10.0.0.0/8",
-		"127.0.0.0/8",
-		"169.254.0.0/16",
-		"172.16.0.0/12",
-		"192.168.0.0/16",
-		"224.0.0.0/4",
-		"240.0.0.0/4",
+func escapeLocalAddress(table iptables.Table, action iptables.Action, address string) error {
+	args := []string{"-t", string(table), string(action), soxyNetwork.IptablesSoxyChain,
+		"-d", address,
+		"-j", "RETURN"}
+	if output, err := iptables.Raw(args...); err != nil {
+		return err
+	} else if len(output) != 0 {
+		return iptables.ChainError{Chain: soxyNetwork.IptablesSoxyChain, Output: output}
 	}
-)
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, element := range values {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,300 @@
+package driver
+
+import (
+	"fmt"
+	"github.com/docker/go-plugins-helpers/network"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/sirupsen/logrus"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//DefaultReconcileInterval the period of the reconciliations that aren't triggered by a docker event
+	DefaultReconcileInterval = time.Minute
+	//orphanGracePeriod for how long a network has to be missing from docker before being garbage-collected : docker
+	//lists a network only once the driver created it
+	orphanGracePeriod = 30 * time.Second
+)
+
+//DockerClient the docker API calls the watcher relies on
+type DockerClient interface {
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	ListNetworks() ([]docker.Network, error)
+	NetworkInfo(id string) (*docker.Network, error)
+	InspectContainer(id string) (*docker.Container, error)
+}
+
+//Watcher keeps the driver state in sync with docker : it garbage-collects the networks docker doesn't know anymore, maps
+//the networks endpoints to their containers and applies their labels policies, on every network or container rename event
+//and periodically
+type Watcher struct {
+	driver     *Driver
+	client     DockerClient
+	driverName string
+	interval   time.Duration
+	//missing when the indexed networks docker doesn't list were first found missing
+	missing map[string]time.Time
+	//labels the labels of the containers attached to the networks, by container id
+	labels map[string]map[string]string
+	events chan *docker.APIEvents
+	stop   chan struct{}
+	done   chan struct{}
+	sync.Mutex
+}
+
+//NewWatcher returns a watcher of the networks of a given driver name
+func NewWatcher(driver *Driver, client DockerClient, driverName string, interval time.Duration) *Watcher {
+	return &Watcher{
+		driver:     driver,
+		client:     client,
+		driverName: driverName,
+		interval:   interval,
+		missing:    make(map[string]time.Time),
+		labels:     make(map[string]map[string]string),
+	}
+}
+
+//Start subscribes to the docker events and starts reconciling, it is a no-op if the watcher is already started
+func (w *Watcher) Start() error {
+	w.Lock()
+	defer w.Unlock()
+	if w.stop != nil {
+		return nil
+	}
+	events := make(chan *docker.APIEvents, 64)
+	if err := w.client.AddEventListener(events); err != nil {
+		return err
+	}
+	w.events, w.stop, w.done = events, make(chan struct{}), make(chan struct{})
+	go w.watch(w.events, w.stop, w.done)
+	return nil
+}
+
+//Stop unsubscribes from the docker events and stops reconciling
+func (w *Watcher) Stop() {
+	w.Lock()
+	if w.stop == nil {
+		w.Unlock()
+		return
+	}
+	events, stop, done := w.events, w.stop, w.done
+	w.stop = nil
+	w.Unlock()
+	close(stop)
+	<-done
+	if err := w.client.RemoveEventListener(events); err != nil {
+		logrus.Debugf("couldn't unsubscribe from the docker events : %v", err)
+	}
+}
+
+func (w *Watcher) watch(events chan *docker.APIEvents, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	w.Reconcile()
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if !w.relevant(event) {
+				continue
+			}
+			logrus.Debugf("reconciling on docker event '%s %s' of '%s'", event.Type, event.Action, event.Actor.ID)
+			w.Reconcile()
+		case <-ticker.C:
+			w.Reconcile()
+		}
+	}
+}
+
+//relevant whether a docker event may change the state the watcher reconciles
+func (w *Watcher) relevant(event *docker.APIEvents) bool {
+	if event == nil {
+		return false
+	}
+	switch event.Type {
+	case "network":
+		return event.Actor.Attributes["type"] == w.driverName
+	case "container":
+		return event.Action == "rename"
+	}
+	return false
+}
+
+//Reconcile garbage-collects the indexed networks docker doesn't list anymore, maps the endpoints of the other ones to
+//their containers, applies the policies of the containers that weren't resolved on join and starts the tunnels (and tor)
+//they rely on
+func (w *Watcher) Reconcile() {
+	networks, err := w.client.ListNetworks()
+	if err != nil {
+		logrus.Errorf("couldn't list the docker networks : %v", err)
+		return
+	}
+	listed := make(map[string]bool)
+	for _, dockerNetwork := range networks {
+		if dockerNetwork.Driver == w.driverName {
+			listed[dockerNetwork.ID] = true
+		}
+	}
+	w.collect(listed)
+	attached := make(map[string]bool)
+	for networkID := range listed {
+		if networkContext := w.driver.networkContext(networkID); networkContext != nil {
+			w.mapContainers(networkContext, attached)
+		}
+	}
+	w.forgetLabels(attached)
+	utils.LogIfNotNull(w.driver.syncTor())
+}
+
+//collect removes the indexed networks missing from docker for longer than the grace period
+func (w *Watcher) collect(listed map[string]bool) {
+	w.driver.RLock()
+	var indexed []string
+	for networkID := range w.driver.networksIndex {
+		indexed = append(indexed, networkID)
+	}
+	w.driver.RUnlock()
+
+	w.Lock()
+	var orphans []string
+	now := time.Now()
+	missing := make(map[string]time.Time)
+	for _, networkID := range indexed {
+		if listed[networkID] {
+			continue
+		}
+		since, ok := w.missing[networkID]
+		if !ok {
+			since = now
+		}
+		if now.Sub(since) >= orphanGracePeriod {
+			orphans = append(orphans, networkID)
+			continue
+		}
+		missing[networkID] = since
+	}
+	w.missing = missing
+	w.Unlock()
+
+	for _, networkID := range orphans {
+		logrus.Infof("network '%s' doesn't exist anymore, removing it", networkID)
+		if err := w.driver.DeleteNetwork(&network.DeleteNetworkRequest{NetworkID: networkID}); err != nil {
+			logrus.Errorf("couldn't remove orphan network '%s' : %v", networkID, err)
+		}
+	}
+}
+
+//mapContainers maps the endpoints of a network to the containers docker attached them to, recording these containers
+func (w *Watcher) mapContainers(networkContext *soxyNetwork.Context, attached map[string]bool) {
+	dockerNetwork, err := w.client.NetworkInfo(networkContext.ID)
+	if err != nil {
+		logrus.Debugf("couldn't inspect network '%s' : %v", networkContext.ID, err)
+		return
+	}
+	containers := make(map[string]soxyNetwork.Container)
+	for containerID, endpoint := range dockerNetwork.Containers {
+		attached[containerID] = true
+		//the endpoints created before the driver recorded them are only known from docker
+		address, _, _ := net.ParseCIDR(endpoint.IPv4Address)
+		if address != nil && networkContext.EndpointAddress(endpoint.ID) == nil {
+			networkContext.AddEndpoint(endpoint.ID, address)
+		}
+		w.driver.syncEndpoint(networkContext.ID, endpoint.ID, containerID, address, endpoint.MacAddress)
+		//the endpoints docker attached a container to are joined
+		utils.LogIfNotNull(networkContext.Activate(endpoint.ID))
+		containers[endpoint.ID] = soxyNetwork.Container{
+			ID:     containerID,
+			Name:   strings.TrimPrefix(endpoint.Name, "/"),
+			Labels: w.containerLabels(containerID),
+		}
+	}
+	networkContext.SetContainers(containers)
+	for endpointID, container := range networkContext.Containers() {
+		if networkContext.HasEndpointPolicy(endpointID) {
+			continue
+		}
+		if err := w.driver.applyPolicy(networkContext, endpointID, container); err != nil {
+			//the endpoint is left to the network defaults rather than retried on every reconciliation
+			utils.LogIfNotNull(networkContext.SetEndpointPolicy(endpointID, soxyNetwork.Policy{}))
+		}
+	}
+}
+
+//ResolveContainer resolves the container an endpoint being joined belongs to, by the endpoint docker recorded for it or
+//by the network namespace it is joined in : no container is guessed when neither matches
+func (w *Watcher) ResolveContainer(networkID string, endpointID string, sandboxKey string) (*soxyNetwork.Container, error) {
+	containers, err := w.client.ListContainers(docker.ListContainersOptions{All: true, Filters: map[string][]string{"network": {networkID}}})
+	if err != nil {
+		return nil, err
+	}
+	for _, container := range containers {
+		for _, settings := range container.Networks.Networks {
+			if settings.EndpointID == endpointID {
+				return resolvedContainer(container), nil
+			}
+		}
+	}
+	if sandboxKey == "" {
+		return nil, fmt.Errorf("no container owns endpoint '%s'", endpointID)
+	}
+	for _, container := range containers {
+		inspected, err := w.client.InspectContainer(container.ID)
+		if err != nil || inspected == nil || inspected.NetworkSettings == nil {
+			continue
+		}
+		if inspected.NetworkSettings.SandboxKey == sandboxKey {
+			return resolvedContainer(container), nil
+		}
+	}
+	return nil, fmt.Errorf("no container owns endpoint '%s' nor sandbox '%s'", endpointID, sandboxKey)
+}
+
+func resolvedContainer(container docker.APIContainers) *soxyNetwork.Container {
+	name := ""
+	if len(container.Names) > 0 {
+		name = strings.TrimPrefix(container.Names[0], "/")
+	}
+	return &soxyNetwork.Container{ID: container.ID, Name: name, Labels: container.Labels}
+}
+
+//containerLabels returns the labels of a container, they are only inspected once as they can't change
+func (w *Watcher) containerLabels(containerID string) map[string]string {
+	w.Lock()
+	labels, ok := w.labels[containerID]
+	w.Unlock()
+	if ok {
+		return labels
+	}
+	container, err := w.client.InspectContainer(containerID)
+	if err != nil || container == nil || container.Config == nil {
+		logrus.Debugf("couldn't inspect container '%s' : %v", containerID, err)
+		return nil
+	}
+	w.Lock()
+	w.labels[containerID] = container.Config.Labels
+	w.Unlock()
+	return container.Config.Labels
+}
+
+//forgetLabels forgets the labels of the containers that aren't attached to the networks anymore
+func (w *Watcher) forgetLabels(attached map[string]bool) {
+	w.Lock()
+	defer w.Unlock()
+	for containerID := range w.labels {
+		if !attached[containerID] {
+			delete(w.labels, containerID)
+		}
+	}
+}
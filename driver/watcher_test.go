@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"fmt"
+	"github.com/fsouza/go-dockerclient"
+	"github.com/stretchr/testify/assert"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
+	"github.com/yassine/soxy-driver/state"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fixtureDockerClient struct {
+	networks   []docker.Network
+	inspected  map[string]int
+	containers map[string]*docker.Container
+	listed     []docker.APIContainers
+}
+
+func (c *fixtureDockerClient) AddEventListener(listener chan<- *docker.APIEvents) error { return nil }
+
+func (c *fixtureDockerClient) RemoveEventListener(listener chan *docker.APIEvents) error { return nil }
+
+func (c *fixtureDockerClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	return c.listed, nil
+}
+
+func (c *fixtureDockerClient) ListNetworks() ([]docker.Network, error) { return c.networks, nil }
+
+func (c *fixtureDockerClient) NetworkInfo(id string) (*docker.Network, error) {
+	for i := range c.networks {
+		if c.networks[i].ID == id {
+			return &c.networks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no such network %s", id)
+}
+
+func (c *fixtureDockerClient) InspectContainer(id string) (*docker.Container, error) {
+	c.inspected[id]++
+	return c.containers[id], nil
+}
+
+func TestWatcherRelevantEvents(t *testing.T) {
+	watcher := NewWatcher(&Driver{}, &fixtureDockerClient{}, "soxy-driver", time.Minute)
+	network := func(driverName string) *docker.APIEvents {
+		return &docker.APIEvents{Type: "network", Action: "destroy", Actor: docker.APIActor{Attributes: map[string]string{"type": driverName}}}
+	}
+	assert.True(t, watcher.relevant(network("soxy-driver")))
+	assert.False(t, watcher.relevant(network("bridge")))
+	assert.True(t, watcher.relevant(&docker.APIEvents{Type: "container", Action: "rename"}))
+	assert.False(t, watcher.relevant(&docker.APIEvents{Type: "container", Action: "start"}))
+	assert.False(t, watcher.relevant(nil))
+}
+
+func TestWatcherReconcile(t *testing.T) {
+	client := &fixtureDockerClient{
+		networks: []docker.Network{
+			{ID: "listed", Driver: "soxy-driver", Containers: map[string]docker.Endpoint{
+				"c0ffee": {Name: "web", ID: "endpoint", MacAddress: "02:42:ac:1e:01:02"},
+			}},
+			{ID: "other", Driver: "bridge"},
+		},
+		inspected: map[string]int{},
+		containers: map[string]*docker.Container{
+			"c0ffee": {ID: "c0ffee", Config: &docker.Config{Labels: map[string]string{"role": "web"}}},
+		},
+	}
+	dir, err := ioutil.TempDir("", "soxy-watcher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	driver := &Driver{
+		networksIndex: map[string]*soxyNetwork.Context{
+			"listed":  {ID: "listed"},
+			"missing": {ID: "missing"},
+		},
+		endpoints:      map[string]Endpoint{},
+		endpointsStore: state.NewStore(filepath.Join(dir, "endpoints.json")),
+	}
+	watcher := NewWatcher(driver, client, "soxy-driver", time.Minute)
+
+	watcher.Reconcile()
+	watcher.Reconcile()
+	//the missing network is within its grace period
+	assert.Len(t, driver.networksIndex, 2)
+	assert.Contains(t, watcher.missing, "missing")
+	assert.NotContains(t, watcher.missing, "listed")
+	//the container labels are inspected once
+	assert.Equal(t, 1, client.inspected["c0ffee"])
+	assert.Equal(t, map[string]string{"role": "web"}, watcher.containerLabels("c0ffee"))
+	//the endpoint docker reports is recorded
+	endpoint, ok := driver.endpoint("endpoint")
+	assert.True(t, ok)
+	assert.Equal(t, "listed", endpoint.NetworkID)
+	assert.Equal(t, "02:42:ac:1e:01:02", endpoint.MacAddress)
+	assert.Equal(t, "c0ffee", endpoint.ContainerID)
+}
+
+func TestWatcherResolveContainer(t *testing.T) {
+	client := &fixtureDockerClient{
+		listed: []docker.APIContainers{
+			{ID: "joined", Names: []string{"/db"}, State: "running", Networks: docker.NetworkList{Networks: map[string]docker.ContainerNetwork{
+				"soxy": {NetworkID: "network", EndpointID: "db-endpoint"},
+			}}},
+			{ID: "starting", Names: []string{"/web"}, State: "created", Labels: map[string]string{"soxy.exempt": "true"}},
+		},
+		inspected: map[string]int{},
+		containers: map[string]*docker.Container{
+			"starting": {ID: "starting", NetworkSettings: &docker.NetworkSettings{SandboxKey: "/var/run/docker/netns/4f1e0d6a5b2c"}},
+		},
+	}
+	watcher := NewWatcher(&Driver{}, client, "soxy-driver", time.Minute)
+
+	container, err := watcher.ResolveContainer("network", "db-endpoint", "")
+	assert.Nil(t, err)
+	assert.Equal(t, "db", container.Name)
+
+	container, err = watcher.ResolveContainer("network", "web-endpoint", "/var/run/docker/netns/4f1e0d6a5b2c")
+	assert.Nil(t, err)
+	assert.Equal(t, &soxyNetwork.Container{ID: "starting", Name: "web", Labels: map[string]string{"soxy.exempt": "true"}}, container)
+
+	//a container that isn't started in the sandbox isn't guessed
+	_, err = watcher.ResolveContainer("network", "web-endpoint", "")
+	assert.NotNil(t, err)
+	_, err = watcher.ResolveContainer("network", "web-endpoint", "/var/run/docker/netns/7a8b9c0d1e2f")
+	assert.NotNil(t, err)
+}
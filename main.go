@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"github.com/docker/go-plugins-helpers/network"
 	"github.com/fsouza/go-dockerclient"
 	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/api"
+	"github.com/yassine/soxy-driver/config"
 	"github.com/yassine/soxy-driver/driver"
+	soxyNetwork "github.com/yassine/soxy-driver/network"
 	"github.com/yassine/soxy-driver/utils"
 	"os"
 	"os/signal"
@@ -15,21 +20,47 @@ import (
 const (
 	//DriverName The name of the driver (used when creating a network for example)
 	DriverName = "soxy-driver"
-	//DockerSocket Docker client hook
-	DockerSocket = "unix:///var/run/docker.sock"
 )
 
-func init() {
-	logrus.SetLevel(logrus.DebugLevel)
-}
-
 func main() {
-	client, err := docker.NewClient(DockerSocket)
+	listOptions := flag.Bool("list-options", false, "print the supported network options as JSON and exit")
+	healthcheck := flag.Bool("healthcheck", false, "query the running driver health through its status API and exit")
+	flag.Parse()
+	if *listOptions {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(soxyNetwork.Options); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	configuration, err := config.Load(config.File())
+	if err != nil {
+		panic(err)
+	}
+	logrus.SetLevel(configuration.Level())
+	logrus.SetFormatter(configuration.Formatter())
+	soxyNetwork.SetNamespace(configuration.Namespace)
+	statusSocket, err := api.StatusSocket(configuration.StatusSocket)
+	if err != nil {
+		panic(err)
+	}
+
+	if *healthcheck {
+		if err := api.CheckHealth(statusSocket); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	client, err := docker.NewClient(configuration.DockerSocket)
 	utils.LogIfNotNull(err)
-	soxyDriver := driver.New()
+	soxyDriver := driver.New(configuration)
 	networks, err := client.ListNetworks()
 
-	namespace := os.Getenv("DRIVER_NAMESPACE")
+	namespace := configuration.Namespace
 	driverName := ""
 
 	if len(namespace) == 0 {
@@ -52,20 +83,78 @@ func main() {
 	}
 	soxyDriver.Recover(recoveredNetworks)
 
+	watcher := driver.NewWatcher(soxyDriver, client, driverName, driver.DefaultReconcileInterval)
+	soxyDriver.SetContainerResolver(watcher)
+	if err := watcher.Start(); err != nil {
+		logrus.Errorf("couldn't watch the docker events, the driver state won't be reconciled with docker : %v", err)
+	}
+
+	statusServer := api.NewStatusServer(soxyDriver)
+	go func() {
+		utils.LogIfNotNull(statusServer.Serve(statusSocket))
+	}()
+
+	var managementServer *api.ManagementServer
+	if configuration.ManagementTokenFile != "" {
+		managementSocket, err := api.ManagementSocket(configuration.ManagementSocket)
+		if err != nil {
+			panic(err)
+		}
+		managementServer, err = api.NewManagementServer(soxyDriver, configuration.ManagementTokenFile)
+		if err != nil {
+			panic(err)
+		}
+		go func() {
+			utils.LogIfNotNull(managementServer.Serve(managementSocket))
+		}()
+	}
+
+	var metricsServer *api.MetricsServer
+	if configuration.MetricsAddress != "" {
+		metricsServer = api.NewMetricsServer(soxyDriver, func(networkID string) (string, error) {
+			dockerNetwork, err := client.NetworkInfo(networkID)
+			if err != nil {
+				return "", err
+			}
+			return dockerNetwork.Name, nil
+		})
+		go func() {
+			utils.LogIfNotNull(metricsServer.Serve(configuration.MetricsAddress))
+		}()
+	}
+
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	go func() {
 		for sig := range c {
+			if sig == syscall.SIGHUP {
+				//Reload the configuration
+				configuration, err := config.Load(config.File())
+				if err != nil {
+					logrus.Error("Keeping the current configuration : ", err)
+					continue
+				}
+				soxyDriver.Reconfigure(configuration)
+				continue
+			}
+			watcher.Stop()
 			if sig == syscall.SIGTERM {
 				//Shutdown the driver
 				soxyDriver.ShutDown()
 			}
+			statusServer.Close()
+			if managementServer != nil {
+				managementServer.Close()
+			}
+			if metricsServer != nil {
+				metricsServer.Close()
+			}
 			os.Remove("/run/docker/plugins/" + driverName + ".sock")
 			os.Exit(0)
 		}
 	}()
 
-	h := network.NewHandler(&soxyDriver)
+	h := network.NewHandler(soxyDriver)
 	serveError := h.ServeUnix(driverName, 0)
 	if serveError != nil {
 		logrus.Error(serveError)
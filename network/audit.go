@@ -0,0 +1,81 @@
+package network
+
+import (
+	"github.com/yassine/soxy-driver/audit"
+	"github.com/yassine/soxy-driver/tunnel"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"strconv"
+	"time"
+)
+
+//outputObserver a tunnel backend whose log lines can be observed
+type outputObserver interface {
+	OnOutput(callback func(line string))
+}
+
+//connectionReporter a tunnel backend reporting its connections itself once closed
+type connectionReporter interface {
+	reportConnections(callback func(connection tunnel.Connection))
+	stopReportingConnections()
+}
+
+//startAudit opens the network audit sink and follows the tunnel log, if the network connections are audited
+func (networkContext *Context) startAudit() error {
+	if networkContext.Audit == audit.Off {
+		return nil
+	}
+	sink, err := audit.Open(networkContext.Audit, networkContext.ID)
+	if err != nil {
+		return utils.LogAndThrowError("couldn't open the audit of network '%s' : %v", networkContext.ID, err)
+	}
+	upstream := net.JoinHostPort(networkContext.ProxyAddress, strconv.FormatInt(networkContext.ProxyPort, 10))
+	tracker := audit.NewTracker(sink, networkContext.ID, upstream, networkContext.endpointByAddress)
+	networkContext.lock.Lock()
+	networkContext.tracker = tracker
+	networkContext.lock.Unlock()
+	if reporter, ok := networkContext.tunnel.(connectionReporter); ok {
+		reporter.reportConnections(func(connection tunnel.Connection) {
+			tracker.Record(audit.Record{
+				Time:          connection.Accepted,
+				Source:        connection.Source.String(),
+				Destination:   connection.Destination.String(),
+				Upstream:      connection.Upstream,
+				Host:          connection.Host,
+				BytesSent:     connection.BytesSent,
+				BytesReceived: connection.BytesReceived,
+				Duration:      time.Since(connection.Accepted).Seconds(),
+				Result:        connection.Result,
+			})
+		})
+		return nil
+	}
+	networkContext.tunnel.(outputObserver).OnOutput(tracker.Line)
+	return nil
+}
+
+//stopAudit records the connections still open and closes the network audit sink
+func (networkContext *Context) stopAudit() {
+	networkContext.lock.Lock()
+	tracker := networkContext.tracker
+	networkContext.tracker = nil
+	networkContext.lock.Unlock()
+	if reporter, ok := networkContext.tunnel.(connectionReporter); ok && tracker != nil {
+		reporter.stopReportingConnections()
+	}
+	if tracker != nil {
+		utils.LogIfNotNull(tracker.Close())
+	}
+}
+
+//endpointByAddress returns the id of the network endpoint with a given address, empty if unknown
+func (networkContext *Context) endpointByAddress(address net.IP) string {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	for endpointID, endpointAddress := range networkContext.endpoints {
+		if endpointAddress.Equal(address) {
+			return endpointID
+		}
+	}
+	return ""
+}
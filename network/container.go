@@ -0,0 +1,39 @@
+package network
+
+//Container the container an endpoint is attached to
+type Container struct {
+	ID     string            `json:"id"`
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+//SetContainers replaces the containers the network endpoints are attached to, by endpoint id
+func (networkContext *Context) SetContainers(containers map[string]Container) {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	networkContext.containers = make(map[string]Container)
+	for endpointID, container := range containers {
+		if _, ok := networkContext.endpoints[endpointID]; ok {
+			networkContext.containers[endpointID] = container
+		}
+	}
+}
+
+//EndpointContainer returns the container an endpoint is attached to, false if unknown
+func (networkContext *Context) EndpointContainer(endpointID string) (Container, bool) {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	container, ok := networkContext.containers[endpointID]
+	return container, ok
+}
+
+//Containers returns the containers the network endpoints are attached to, by endpoint id
+func (networkContext *Context) Containers() map[string]Container {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	containers := make(map[string]Container)
+	for endpointID, container := range networkContext.containers {
+		containers[endpointID] = container
+	}
+	return containers
+}
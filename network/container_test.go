@@ -0,0 +1,21 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestSetContainers(t *testing.T) {
+	networkContext := &Context{endpoints: map[string]net.IP{}}
+	networkContext.AddEndpoint("endpoint", net.ParseIP("172.30.1.2"))
+	networkContext.SetContainers(map[string]Container{
+		"endpoint": {ID: "c0ffee", Name: "web"},
+		"unknown":  {ID: "deadbeef", Name: "gone"},
+	})
+	assert.Equal(t, map[string]Container{"endpoint": {ID: "c0ffee", Name: "web"}}, networkContext.Containers())
+
+	networkContext.RemoveEndpoint("endpoint")
+	_, ok := networkContext.EndpointContainer("endpoint")
+	assert.False(t, ok)
+}
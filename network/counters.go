@@ -0,0 +1,59 @@
+package network
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"strconv"
+	"strings"
+)
+
+//RuleCounter the packets and bytes matched by a network rule of the driver chain
+type RuleCounter struct {
+	Table       string
+	Target      string
+	Protocol    string
+	Destination string
+	Packets     uint64
+	Bytes       uint64
+}
+
+//ReadCounters reads the counters of the driver chain rules of a given table, grouped by the bridge they match
+func ReadCounters(table iptables.Table) (map[string][]RuleCounter, error) {
+	output, err := iptables.Raw("-t", string(table), "-L", IptablesSoxyChain, "-n", "-v", "-x")
+	if err != nil {
+		return nil, err
+	}
+	return parseCounters(table, string(output)), nil
+}
+
+//parseCounters parses the verbose listing of a chain : pkts bytes target prot opt in out source destination [extra]
+func parseCounters(table iptables.Table, output string) map[string][]RuleCounter {
+	counters := make(map[string][]RuleCounter)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 9 {
+			continue
+		}
+		packets, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		bytes, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		bridge := fields[5]
+		//the rules that aren't bound to a network bridge (e.g. the local addresses ones) are skipped
+		if bridge == "*" {
+			continue
+		}
+		counters[bridge] = append(counters[bridge], RuleCounter{
+			Table:       string(table),
+			Target:      fields[2],
+			Protocol:    fields[3],
+			Destination: fields[8],
+			Packets:     packets,
+			Bytes:       bytes,
+		})
+	}
+	return counters
+}
@@ -0,0 +1,24 @@
+package network
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseCounters(t *testing.T) {
+	output := `Chain SOXY_CHAIN (2 references)
+    pkts      bytes target     prot opt in     out     source               destination
+      12      720 RETURN     all  --  *      *       0.0.0.0/0            10.0.0.0/8
+       3      180 RETURN     all  --  br-a   *       0.0.0.0/0            10.20.0.0/16
+      42     2520 REDIRECT   tcp  --  br-a   *       0.0.0.0/0            0.0.0.0/0            tcp flags:0x17/0x02 redir ports 12345
+       7      420 REDIRECT   tcp  --  br-b   *       0.0.0.0/0            0.0.0.0/0            tcp flags:0x17/0x02 redir ports 23456
+`
+	counters := parseCounters(iptables.Nat, output)
+	assert.Len(t, counters, 2)
+	assert.Equal(t, []RuleCounter{
+		{Table: "nat", Target: "RETURN", Protocol: "all", Destination: "10.20.0.0/16", Packets: 3, Bytes: 180},
+		{Table: "nat", Target: "REDIRECT", Protocol: "tcp", Destination: "0.0.0.0/0", Packets: 42, Bytes: 2520},
+	}, counters["br-a"])
+	assert.Equal(t, uint64(7), counters["br-b"][0].Packets)
+}
@@ -0,0 +1,119 @@
+package network
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/utils"
+	"time"
+)
+
+//idleTimeout for how long the tunnel of a network no container is joined to keeps running, 0 if the tunnels are started
+//with their network and never stopped for being idle
+var idleTimeout time.Duration
+
+//SetIdleTimeout sets for how long the tunnel of a network no container is joined to keeps running : when set, the tunnels
+//are started on the first join rather than with their network
+func SetIdleTimeout(timeout time.Duration) {
+	idleTimeout = timeout
+}
+
+//IdleTimeout returns for how long the tunnel of a network no container is joined to keeps running, 0 if disabled
+func IdleTimeout() time.Duration {
+	return idleTimeout
+}
+
+//Activate records an endpoint joined to the network and starts the network tunnel if it was left stopped for lack of
+//joined endpoints, a failed tunnel isn't restarted
+func (networkContext *Context) Activate(endpointID string) error {
+	networkContext.modeLock.Lock()
+	defer networkContext.modeLock.Unlock()
+	networkContext.lock.Lock()
+	if networkContext.active == nil {
+		networkContext.active = make(map[string]bool)
+	}
+	networkContext.active[endpointID] = true
+	networkContext.cancelIdleStop()
+	failed := networkContext.failure != ""
+	networkContext.lock.Unlock()
+	if failed || !networkContext.tunnelWanted(networkContext.Mode()) {
+		return nil
+	}
+	if err := networkContext.startTunnel(); err != nil {
+		return utils.LogAndThrowError("couldn't start network '%s' tunnel : %v", networkContext.ID, err)
+	}
+	return nil
+}
+
+//Deactivate records an endpoint left the network, the network tunnel is stopped once no endpoint joined it for the idle
+//timeout
+func (networkContext *Context) Deactivate(endpointID string) {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	if !networkContext.active[endpointID] {
+		return
+	}
+	delete(networkContext.active, endpointID)
+	if len(networkContext.active) > 0 || idleTimeout <= 0 {
+		return
+	}
+	networkContext.cancelIdleStop()
+	generation := networkContext.idleGeneration
+	networkContext.idleTimer = time.AfterFunc(idleTimeout, func() { networkContext.stopIdleTunnel(generation) })
+}
+
+//Active whether an endpoint is joined to the network
+func (networkContext *Context) Active() bool {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	return len(networkContext.active) > 0
+}
+
+//UsesTor whether the network traffic relies on the embedded tor instance, as its proxy, its dns resolver or the proxy of
+//one of the profiles its endpoints are tunneled through
+func (networkContext *Context) UsesTor() bool {
+	if networkContext.FallbackProxy || networkContext.DNSMode == TorDNS {
+		return true
+	}
+	networkContext.policyLock.Lock()
+	defer networkContext.policyLock.Unlock()
+	for _, tunnel := range networkContext.profileTunnels {
+		if tunnel.context.FallbackProxy {
+			return true
+		}
+	}
+	return false
+}
+
+//tunnelWanted whether the tunnel process has to run in a given mode : the tunnel of a network no endpoint is joined to
+//is left stopped when an idle timeout is set
+func (networkContext *Context) tunnelWanted(pause Pause) bool {
+	return pause.runsTunnel() && (idleTimeout <= 0 || networkContext.Active())
+}
+
+//idle whether the network tunnel is stopped, or about to be, for lack of joined endpoints, the caller must hold the lock
+func (networkContext *Context) idle() bool {
+	return idleTimeout > 0 && len(networkContext.active) == 0
+}
+
+//cancelIdleStop cancels the pending stop of the network tunnel, if any, the caller must hold the lock
+func (networkContext *Context) cancelIdleStop() {
+	networkContext.idleGeneration++
+	if networkContext.idleTimer != nil {
+		networkContext.idleTimer.Stop()
+		networkContext.idleTimer = nil
+	}
+}
+
+//stopIdleTunnel stops the network tunnel unless an endpoint joined the network since the stop was scheduled
+func (networkContext *Context) stopIdleTunnel(generation uint64) {
+	networkContext.modeLock.Lock()
+	defer networkContext.modeLock.Unlock()
+	networkContext.lock.Lock()
+	if generation != networkContext.idleGeneration || len(networkContext.active) > 0 {
+		networkContext.lock.Unlock()
+		return
+	}
+	networkContext.idleTimer = nil
+	networkContext.lock.Unlock()
+	logrus.Infof("no container joined network '%s' for %s, stopping its tunnel", networkContext.ID, idleTimeout)
+	utils.LogIfNotNull(networkContext.tunnel.Stop())
+}
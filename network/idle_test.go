@@ -0,0 +1,82 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/tunnel"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeBackend struct {
+	state supervisor.State
+	//configuration the latest configuration the backend was given
+	configuration *tunnel.Configuration
+	sync.Mutex
+}
+
+func (b *fakeBackend) OnFailure(callback func(supervisor.Status)) {}
+
+func (b *fakeBackend) Configure(configuration *tunnel.Configuration) error {
+	b.Lock()
+	defer b.Unlock()
+	b.configuration = configuration
+	return nil
+}
+
+func (b *fakeBackend) Start() error {
+	b.Lock()
+	defer b.Unlock()
+	b.state = supervisor.Running
+	return nil
+}
+
+func (b *fakeBackend) Stop() error {
+	b.Lock()
+	defer b.Unlock()
+	b.state = supervisor.Stopped
+	return nil
+}
+
+func (b *fakeBackend) Health() supervisor.Status {
+	b.Lock()
+	defer b.Unlock()
+	return supervisor.Status{State: b.state}
+}
+
+func TestIdleTunnelIsStoppedAfterTheLastLeave(t *testing.T) {
+	SetIdleTimeout(20 * time.Millisecond)
+	defer SetIdleTimeout(0)
+	backend := &fakeBackend{state: supervisor.Stopped}
+	networkContext := &Context{ID: "4f1e0d6a5b2c", tunnel: backend, active: make(map[string]bool), mode: TunnelMode}
+	assert.False(t, networkContext.tunnelWanted(networkContext.Mode()))
+	assert.True(t, networkContext.Health().Idle)
+
+	assert.Nil(t, networkContext.Activate("e1"))
+	assert.Equal(t, supervisor.Running, backend.Health().State)
+	assert.False(t, networkContext.Health().Idle)
+
+	//a join within the idle timeout keeps the tunnel running
+	networkContext.Deactivate("e1")
+	assert.Nil(t, networkContext.Activate("e2"))
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, supervisor.Running, backend.Health().State)
+
+	networkContext.Deactivate("e2")
+	assert.Equal(t, supervisor.Running, backend.Health().State)
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, supervisor.Stopped, backend.Health().State)
+	assert.True(t, networkContext.Health().Idle)
+}
+
+func TestTunnelIsNeverIdleWithoutTimeout(t *testing.T) {
+	backend := &fakeBackend{state: supervisor.Running}
+	networkContext := &Context{ID: "4f1e0d6a5b2c", tunnel: backend, active: make(map[string]bool), mode: TunnelMode}
+	assert.True(t, networkContext.tunnelWanted(networkContext.Mode()))
+	assert.Nil(t, networkContext.Activate("e1"))
+	networkContext.Deactivate("e1")
+	assert.Nil(t, networkContext.idleTimer)
+	assert.False(t, networkContext.Health().Idle)
+	assert.Equal(t, supervisor.Running, backend.Health().State)
+}
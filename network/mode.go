@@ -0,0 +1,105 @@
+package network
+
+import (
+	"fmt"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/utils"
+	"strings"
+)
+
+//Mode how a network egress traffic is handled
+type Mode string
+
+const (
+	//TunnelMode the traffic is tunneled, the default
+	TunnelMode Mode = "tunnel"
+	//DirectMode the traffic is let through untunneled, as on a plain bridge network
+	DirectMode Mode = "direct"
+	//DropMode the egress traffic is dropped
+	DropMode Mode = "drop"
+)
+
+//Modes the available network modes
+var Modes = []Mode{TunnelMode, DirectMode, DropMode}
+
+//Pause the mode a paused network is in
+type Pause struct {
+	Mode Mode `json:"mode"`
+	//KeepTunnel whether the tunnel process keeps running while the network is paused
+	KeepTunnel bool `json:"keepTunnel"`
+}
+
+//ParseMode parses a network mode (case insensitive)
+func ParseMode(value string) (Mode, error) {
+	for _, mode := range Modes {
+		if strings.EqualFold(strings.TrimSpace(value), string(mode)) {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown mode '%s' (available choices : tunnel, direct, drop)", value)
+}
+
+//Mode returns how the network egress traffic is currently handled
+func (networkContext *Context) Mode() Pause {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	return Pause{Mode: networkContext.mode, KeepTunnel: networkContext.keepTunnel}
+}
+
+//InitMode sets the mode a network context is initialized in, before Init : its rules are the only ones programmed and the
+//tunnel process isn't started unless the mode runs it. Use SetMode once initialized
+func (networkContext *Context) InitMode(pause Pause) {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	networkContext.mode = pause.Mode
+	networkContext.keepTunnel = pause.KeepTunnel
+}
+
+//SetMode switches the network to a given mode without recreating it : the rules of the new mode are added before the
+//ones of the current mode are removed so that no traffic leaks untunneled in between. The tunnel process is started when
+//tunneling, unless no endpoint is joined to the network and an idle timeout is set, and stopped otherwise, unless it is kept
+func (networkContext *Context) SetMode(mode Mode, keepTunnel bool) error {
+	networkContext.modeLock.Lock()
+	defer networkContext.modeLock.Unlock()
+	current := networkContext.Mode()
+	if networkContext.tunnelWanted(Pause{Mode: mode, KeepTunnel: keepTunnel}) {
+		if err := networkContext.startTunnel(); err != nil {
+			return utils.LogAndThrowError("couldn't start network '%s' tunnel : %v", networkContext.ID, err)
+		}
+	}
+	if mode != current.Mode {
+		if err := addRules(networkContext.modeRules(mode)); err != nil {
+			if !networkContext.tunnelWanted(current) {
+				utils.LogIfNotNull(networkContext.tunnel.Stop())
+			}
+			return utils.LogAndThrowError("couldn't switch network '%s' to mode '%s' : %v", networkContext.ID, mode, err)
+		}
+		utils.LogIfNotNull(deleteRules(networkContext.modeRules(current.Mode)))
+	}
+	networkContext.lock.Lock()
+	networkContext.mode = mode
+	networkContext.keepTunnel = keepTunnel
+	networkContext.lock.Unlock()
+	if mode != TunnelMode && !keepTunnel {
+		utils.LogIfNotNull(networkContext.tunnel.Stop())
+	}
+	logrus.Infof("network '%s' is now in mode '%s'", networkContext.ID, mode)
+	return nil
+}
+
+//runsTunnel whether the tunnel process runs in the mode
+func (pause Pause) runsTunnel() bool {
+	return pause.Mode == TunnelMode || pause.KeepTunnel
+}
+
+//modeRules returns the iptables rules implementing a given mode, in the order they are added
+func (networkContext *Context) modeRules(mode Mode) []rule {
+	switch mode {
+	case DirectMode:
+		return nil
+	case DropMode:
+		return []rule{{iptables.Filter, "FORWARD", true, networkContext.onBridge(append(purposeTag("drop-mode"), "-j", "DROP")...)}}
+	}
+	return networkContext.tunnelRules()
+}
@@ -1,11 +1,18 @@
 package network
 
 import (
+	"fmt"
 	"github.com/docker/libnetwork/iptables"
 	"github.com/sirupsen/logrus"
-	"github.com/yassine/soxy-driver/redsocks"
+	"github.com/yassine/soxy-driver/audit"
+	"github.com/yassine/soxy-driver/secret"
+	"github.com/yassine/soxy-driver/supervisor"
 	"github.com/yassine/soxy-driver/utils"
+	"net"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -14,10 +21,27 @@ const (
 	proxyType         = "soxy.proxytype"
 	proxyUser         = "soxy.proxyuser"
 	proxyPassword     = "soxy.proxypassword"
+	proxyPasswordFile = "soxy.proxypassword.file"
+	proxyPasswordEnv  = "soxy.proxypassword.env"
+	proxyPasswordRef  = "soxy.proxypassword.secret"
 	tunnelBindAddress = "soxy.tunnelBindAddress"
 	tunnelPort        = "soxy.tunnelPort"
 	blockUDP          = "soxy.blockUDP"
+	dnsMode           = "soxy.dns"
+	bypass            = "soxy.bypass"
+	routes            = "soxy.routes"
+	tunnelBackend     = "soxy.tunnelBackend"
+	tunnelBinary      = "soxy.tunnelBinary"
+	tunnelArgs        = "soxy.tunnelArgs"
+	tunnelTemplate    = "soxy.tunnelTemplate"
+	auditMode         = "soxy.audit"
 	defaultChainName  = "SOXY_CHAIN"
+	//ruleComment the comment the driver rules are tagged with
+	ruleComment = "soxy-driver"
+	//TorDNS dns requests are resolved through the embedded tor instance
+	TorDNS = "tor"
+	//DirectDNS dns requests are let through untunneled
+	DirectDNS = "direct"
 )
 
 //IptablesSoxyChain The Soxy driver custom iptables chain name
@@ -29,10 +53,14 @@ type Context struct {
 	ID string
 	// The associated linux network bridge name
 	BridgeName string
+	//Subnets the network IPv4 pools, the first one being handled by the bridge driver
+	Subnets []Subnet
 	//ProxyPort the proxy address
 	ProxyAddress string
 	//ProxyPort the proxy port
 	ProxyPort int64
+	//ProxyUpstreams the proxies (host:port) tried in order whenever the tunnel starts, if any
+	ProxyUpstreams []string
 	//ProxyPassword the proxy password (if authentication applies)
 	ProxyPassword string
 	//ProxyType the proxy type. Available options : as per redsocks support
@@ -49,21 +77,107 @@ type Context struct {
 	TunnelDNSPort int64
 	//TunnelDNS tunnel the dns resolution through tor
 	BlockUDP bool
-	//Redsocks The redsocks context associated with a given network
-	redsocks *redsocks.Context
+	//DNSMode how the network dns requests are resolved : tor or direct
+	DNSMode string
+	//Bypass the destinations that aren't tunneled
+	Bypass []*net.IPNet
+	//Routes the static routes added to the containers, their destinations aren't tunneled
+	Routes []Route
+	//FallbackProxy whether the network is tunneled through the embedded tor instance
+	FallbackProxy bool
+	//Options the network options as given when the network was created
+	Options map[string]string
+	//params the network options completed with the profile ones
+	params map[string]string
+	//Backend the name of the transparent proxy implementation
+	Backend string
+	//Audit where the network connections are audited : off, file or syslog
+	Audit string
+	//tracker the network connections audit, while the network is initialized
+	tracker *audit.Tracker
+	//tunnel the transparent proxy associated with a given network
+	tunnel TunnelBackend
+	//failure the reason why the network tunnel is considered permanently failed
+	failure string
+	//killSwitch whether the network egress traffic is currently dropped
+	killSwitch bool
+	//endpoints the addresses of the network endpoints
+	endpoints map[string]net.IP
+	//containers the containers the network endpoints are attached to, as last reconciled with docker
+	containers map[string]Container
+	//policies the policies applied to the network endpoints, as set through their container labels
+	policies map[string]*endpointPolicy
+	//profileTunnels the tunnels of the profiles the endpoints policies rely on, by profile name
+	profileTunnels map[string]*profileTunnel
+	//defaultProxyPort the proxy port used when none is set, the one of the embedded tor instance
+	defaultProxyPort int64
+	//mode how the network egress traffic is currently handled
+	mode Mode
+	//keepTunnel whether the tunnel process keeps running while the network isn't in tunnel mode
+	keepTunnel bool
+	//active the endpoints currently joined to the network, see idle.go
+	active map[string]bool
+	//idleTimer the pending stop of the tunnel of the network no endpoint is joined to
+	idleTimer *time.Timer
+	//idleGeneration invalidates the pending stops of the tunnel whenever an endpoint joins the network
+	idleGeneration uint64
+	lock           sync.Mutex
+	//modeLock serializes the mode switches
+	modeLock sync.Mutex
+	//policyLock serializes the endpoints policies changes
+	policyLock sync.Mutex
+}
+
+//Health describes the state of a network tunnel
+type Health struct {
+	//Healthy whether traffic is being tunneled
+	Healthy bool `json:"healthy"`
+	//Reason the reason of the failure if the network is unhealthy
+	Reason string `json:"reason,omitempty"`
+	//KillSwitch whether the network egress traffic is being dropped
+	KillSwitch bool `json:"killSwitch"`
+	//Idle whether the tunnel process is stopped, or about to be, as no container is joined to the network
+	Idle bool `json:"idle"`
+	//Tunnel the tunnel process state
+	Tunnel supervisor.Status `json:"tunnel"`
 }
 
 //NewContext returns a new network context
 func NewContext(networkID string, bridgeName string, params map[string]string, defaultProxyPort int64, dnsPort int64) (*Context, error) {
 
+	err := ValidateOptions(params)
+
+	if err != nil {
+		logrus.Error(err.Error())
+		return nil, err
+	}
+
+	options := params
+	params, err = expandProxyURL(params)
+
+	if err != nil {
+		logrus.Error(err.Error())
+		return nil, err
+	}
+
 	networkContext := &Context{
-		ID:            networkID,
-		BridgeName:    bridgeName,
-		TunnelDNSPort: dnsPort,
+		ID:               networkID,
+		BridgeName:       bridgeName,
+		TunnelDNSPort:    dnsPort,
+		Options:          options,
+		params:           params,
+		endpoints:        make(map[string]net.IP),
+		containers:       make(map[string]Container),
+		policies:         make(map[string]*endpointPolicy),
+		profileTunnels:   make(map[string]*profileTunnel),
+		defaultProxyPort: defaultProxyPort,
+		active:           make(map[string]bool),
+		mode:             TunnelMode,
 	}
-	err := parseNetworkConfiguration(networkContext, params, defaultProxyPort)
+	err = parseNetworkConfiguration(networkContext, params, defaultProxyPort)
 
 	if err != nil {
+		logrus.Error(err.Error())
 		return nil, err
 	}
 
@@ -73,190 +187,413 @@ func NewContext(networkID string, bridgeName string, params map[string]string, d
 		return nil, err
 	}
 
-	redsocksContext, redsocksError := redsocks.NewContext(buildRedsocksConfig(networkContext))
+	backend, err := newTunnelBackend(networkContext, params)
 
-	if redsocksError != nil {
-		return nil, err
+	if err != nil {
+		return nil, utils.LogAndThrowError("error while creating the tunnel backend : %v", err)
 	}
 
-	networkContext.redsocks = redsocksContext
+	err = backend.Configure(buildTunnelConfig(networkContext, params))
+
+	if err != nil {
+		return nil, utils.LogAndThrowError("error while configuring the tunnel backend : %v", err)
+	}
+
+	if networkContext.Audit != audit.Off {
+		_, observable := backend.(outputObserver)
+		if _, reporting := backend.(connectionReporter); !observable && !reporting {
+			return nil, utils.LogAndThrowError("param '%s' isn't supported by the '%s' tunnel backend (only by the '%s' and '%s' ones)",
+				auditMode, networkContext.Backend, RedsocksBackend, SharedBackend)
+		}
+	}
+
+	networkContext.tunnel = backend
+	backend.OnFailure(func(status supervisor.Status) {
+		networkContext.Fail(fmt.Sprintf("%s gave up after %d restarts, last exit code %d", status.Name, status.Restarts, status.LastExitCode))
+	})
 
 	return networkContext, nil
 }
 
-//Init initialize the network context
+//Params returns the options the network context was created from
+func (networkContext *Context) Params() map[string]string {
+	return networkContext.params
+}
+
+//Inherit takes over the state of the context a network is reconfigured from : options, subnets, mode, endpoints, their
+//containers, policies and the joined ones
+func (networkContext *Context) Inherit(previous *Context) {
+	networkContext.Options = previous.Options
+	networkContext.Subnets = previous.Subnets
+	previous.lock.Lock()
+	defer previous.lock.Unlock()
+	networkContext.mode = previous.mode
+	networkContext.keepTunnel = previous.keepTunnel
+	for endpointID, address := range previous.endpoints {
+		networkContext.endpoints[endpointID] = address
+	}
+	for endpointID := range previous.active {
+		networkContext.active[endpointID] = true
+	}
+	for endpointID, container := range previous.containers {
+		networkContext.containers[endpointID] = container
+	}
+	for endpointID, applied := range previous.policies {
+		networkContext.policies[endpointID] = &endpointPolicy{Policy: applied.Policy, address: applied.address}
+	}
+}
+
+//AddEndpoint records the address of an endpoint created on the network
+func (networkContext *Context) AddEndpoint(endpointID string, address net.IP) {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	networkContext.endpoints[endpointID] = address
+}
+
+//RemoveEndpoint forgets a deleted endpoint
+func (networkContext *Context) RemoveEndpoint(endpointID string) {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	delete(networkContext.endpoints, endpointID)
+	delete(networkContext.containers, endpointID)
+}
+
+//EndpointAddress returns the address of a network endpoint, nil if unknown
+func (networkContext *Context) EndpointAddress(endpointID string) net.IP {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	return networkContext.endpoints[endpointID]
+}
+
+//Health returns the network tunnel health
+func (networkContext *Context) Health() Health {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	return Health{
+		Healthy:    networkContext.failure == "",
+		Reason:     networkContext.failure,
+		KillSwitch: networkContext.killSwitch,
+		Idle:       networkContext.idle(),
+		Tunnel:     networkContext.tunnel.Health(),
+	}
+}
+
+//Fail marks the network tunnel as permanently failed and drops the network egress traffic so that nothing leaks untunneled
+func (networkContext *Context) Fail(reason string) {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	logrus.Errorf("network '%s' tunnel failed : %s", networkContext.ID, reason)
+	networkContext.failure = reason
+	if !networkContext.killSwitch {
+		err := networkContext.programKillSwitch(iptables.Insert)
+		if err != nil {
+			logrus.Errorf("couldn't engage the kill-switch of network '%s' : %v", networkContext.ID, err)
+			return
+		}
+		networkContext.killSwitch = true
+	}
+}
+
+//Hold drops the network egress traffic until the returned release function is called, so that nothing leaks untunneled
+//while the network context is replaced
+func (networkContext *Context) Hold() (func(), error) {
+	if err := networkContext.programKillSwitch(iptables.Insert); err != nil {
+		return nil, err
+	}
+	return func() {
+		utils.LogIfNotNull(networkContext.programKillSwitch(iptables.Delete))
+	}, nil
+}
+
+//Init initialize the network context, a failed initialization leaves neither rules, processes nor files behind
 func (networkContext *Context) Init() error {
-	err := networkContext.programNetworkIfaceRules(iptables.Append)
+	err := networkContext.validateRoutes()
 	if err != nil {
 		logrus.Error(err.Error())
+		return err
 	}
-	err = networkContext.redsocks.Startup()
+	err = networkContext.startAudit()
 	if err != nil {
 		logrus.Error(err.Error())
+		return err
 	}
-	return err
+	err = networkContext.addGateways()
+	if err != nil {
+		logrus.Error(err.Error())
+		networkContext.stopAudit()
+		return err
+	}
+	err = networkContext.createPolicyChain()
+	if err != nil {
+		logrus.Error(err.Error())
+		networkContext.deleteGateways()
+		networkContext.stopAudit()
+		return err
+	}
+	err = networkContext.addNetworkIfaceRules()
+	if err != nil {
+		logrus.Error(err.Error())
+		networkContext.deletePolicyChain()
+		networkContext.deleteGateways()
+		networkContext.stopAudit()
+		return err
+	}
+	if networkContext.tunnelWanted(networkContext.Mode()) {
+		err = networkContext.startTunnel()
+		if err != nil {
+			logrus.Error(err.Error())
+			utils.LogIfNotNull(networkContext.tunnel.Stop())
+			utils.LogIfNotNull(networkContext.deleteNetworkIfaceRules())
+			networkContext.deletePolicyChain()
+			networkContext.deleteGateways()
+			networkContext.stopAudit()
+			return err
+		}
+	}
+	networkContext.programPolicies()
+	return nil
 }
 
 //Cleanup cleans-up the network context
 func (networkContext *Context) Cleanup() error {
-	err := networkContext.programNetworkIfaceRules(iptables.Delete)
+	networkContext.lock.Lock()
+	if networkContext.killSwitch {
+		utils.LogIfNotNull(networkContext.programKillSwitch(iptables.Delete))
+		networkContext.killSwitch = false
+	}
+	networkContext.cancelIdleStop()
+	networkContext.lock.Unlock()
+	networkContext.unprogramPolicies()
+	err := networkContext.deleteNetworkIfaceRules()
 	if err != nil {
 		logrus.Error(err.Error())
 	}
-	err = networkContext.redsocks.Shutdown()
+	networkContext.deletePolicyChain()
+	networkContext.deleteGateways()
+	err = networkContext.tunnel.Stop()
 	if err != nil {
 		logrus.Error(err.Error())
 	}
+	networkContext.stopAudit()
 	return err
 }
 
-func buildRedsocksConfig(networkContext *Context) *redsocks.Configuration {
-	return &redsocks.Configuration{
-		ProxyAddress:      networkContext.ProxyAddress,
-		ProxyPassword:     networkContext.ProxyPassword,
-		ProxyPort:         networkContext.ProxyPort,
-		ProxyType:         networkContext.ProxyType,
-		ProxyUser:         networkContext.ProxyUser,
-		TunnelBindAddress: networkContext.TunnelBindAddress,
-		TunnelPort:        networkContext.TunnelPort,
+//rule an iptables rule programmed for a given network
+type rule struct {
+	table iptables.Table
+	chain string
+	//insert whether the rule is inserted at the top of its chain rather than appended
+	insert bool
+	args   []string
+}
+
+func (r rule) String() string {
+	return fmt.Sprintf("-t %s %s %s", r.table, r.chain, strings.Join(r.args, " "))
+}
+
+//taggedArgs returns the rule arguments along with the comment telling the driver rules apart from the other tools ones,
+//unless the rule already carries its own tag (see purposeTag)
+func (r rule) taggedArgs() []string {
+	for _, arg := range r.args {
+		if arg == "--comment" {
+			return r.args
+		}
+	}
+	var args []string
+	tagged := false
+	for _, arg := range r.args {
+		if arg == "-j" && !tagged {
+			args = append(args, "-m", "comment", "--comment", ruleTag())
+			tagged = true
+		}
+		args = append(args, arg)
+	}
+	if !tagged {
+		args = append(args, "-m", "comment", "--comment", ruleTag())
+	}
+	return args
+}
+
+//ruleTag the comment the driver rules are tagged with, each driver namespace has its own
+func ruleTag() string {
+	if utils.Namespace() == "" {
+		return ruleComment
 	}
+	return ruleComment + ":" + utils.Namespace()
 }
 
-func (networkContext *Context) programNetworkIfaceRules(action iptables.Action) error {
+//purposeTag returns the comment match tagging a driver rule along with its purpose : the rules that would otherwise be
+//identical (e.g. the kill-switch and the drop mode ones) are told apart, so that removing one never removes the other
+func purposeTag(purpose string) []string {
+	return []string{"-m", "comment", "--comment", ruleTag() + "/" + purpose}
+}
 
+func (r rule) program(action iptables.Action) error {
+	if r.insert && action == iptables.Append {
+		action = iptables.Insert
+	}
+	args := append([]string{"-t", string(r.table), string(action), r.chain}, r.taggedArgs()...)
+	if output, err := iptables.Raw(args...); err != nil {
+		return err
+	} else if len(output) != 0 {
+		return iptables.ChainError{Chain: r.chain, Output: output}
+	}
+	return nil
+}
+
+//rules returns the iptables rules of the network current mode, in the order they are added
+func (networkContext *Context) rules() []rule {
+	return append(networkContext.modeRules(networkContext.Mode().Mode), networkContext.subnetRules()...)
+}
+
+//tunnelRules returns the iptables rules tunneling the network traffic, in the order they are added
+func (networkContext *Context) tunnelRules() []rule {
 	/**********************
 	 ****** Routing *******
 	 **********************/
 
 	//Pre-routing: go to the chain
-	args := []string{"-t", string(iptables.Nat), string(action), "PREROUTING",
-		"-i", networkContext.BridgeName,
-		"-j", IptablesSoxyChain}
+	rules := []rule{{iptables.Nat, "PREROUTING", false, networkContext.onBridge("-j", IptablesSoxyChain)}}
 
-	if output, err := iptables.Raw(args...); err != nil {
-		return err
-	} else if len(output) != 0 {
-		return iptables.ChainError{Chain: "PREROUTING", Output: output}
+	//the containers rules come first, see policy.go
+	rules = append(rules, rule{iptables.Nat, IptablesSoxyChain, false, networkContext.onBridge("-j", networkContext.policyChain())})
+
+	//bypassed destinations aren't tunneled
+	for _, destination := range networkContext.Bypass {
+		rules = append(rules, rule{iptables.Nat, IptablesSoxyChain, false,
+			networkContext.onBridge("-d", destination.String(), "-j", "RETURN")})
 	}
 
-	//udp dns is redirected through tor
-	args = []string{"-t", string(iptables.Nat), string(action), IptablesSoxyChain,
-		"-i", networkContext.BridgeName,
-		"-p", "udp",
-		"--dport", "53",
-		"-j", "REDIRECT",
-		"--to-ports", strconv.Itoa(int(networkContext.TunnelDNSPort)),
+	//routed destinations are reached through their next hop rather than the tunnel
+	for _, route := range networkContext.Routes {
+		rules = append(rules, rule{iptables.Nat, IptablesSoxyChain, false,
+			networkContext.onBridge("-d", route.Destination.String(), "-j", "RETURN")})
 	}
 
-	if output, err := iptables.Raw(args...); err != nil {
-		logrus.Errorf("forwardToRedSocks DNS error : %v", err)
-	} else if len(output) != 0 {
-		logrus.Errorf("forwardToRedSocks DNS output error : %v", output)
+	//udp dns is redirected through tor
+	if networkContext.DNSMode == TorDNS {
+		rules = append(rules, rule{iptables.Nat, IptablesSoxyChain, false,
+			networkContext.onBridge("-p", "udp", "--dport", "53", "-j", "REDIRECT", "--to-ports", strconv.Itoa(int(networkContext.TunnelDNSPort)))})
 	}
 
 	//TCP traffic is redirected through the tunnel
-	args = []string{"-t", string(iptables.Nat), string(action), IptablesSoxyChain,
-		"-i", networkContext.BridgeName,
-		"-p", "tcp",
-		"--syn",
-		"-j", "REDIRECT",
-		"--to-ports", strconv.Itoa(int(networkContext.TunnelPort))}
-
-	if output, err := iptables.Raw(args...); err != nil {
-		logrus.Errorf("forwardToRedSocks error : %v", err)
-	} else if len(output) != 0 {
-		logrus.Errorf("forwardToRedSocks output error : %v", output)
-	}
+	rules = append(rules, rule{iptables.Nat, IptablesSoxyChain, false,
+		networkContext.onBridge("-p", "tcp", "--syn", "-j", "REDIRECT", "--to-ports", strconv.Itoa(int(networkContext.TunnelPort)))})
 
 	/*************************
 	 ******* Filtering *******
 	 *************************/
 
 	if networkContext.BlockUDP {
-
-		altAction := action
-		if action == iptables.Append {
-			altAction = iptables.Insert
+		dnsPort := strconv.Itoa(int(networkContext.TunnelDNSPort))
+		if networkContext.DNSMode == DirectDNS {
+			dnsPort = "53"
 		}
+		rules = append(rules,
+			rule{iptables.Filter, "FORWARD", true, networkContext.onBridge("-j", IptablesSoxyChain)},
+			rule{iptables.Filter, IptablesSoxyChain, false, networkContext.onBridge("-p", "udp", "--dport", dnsPort, "-j", "RETURN")},
+			rule{iptables.Filter, IptablesSoxyChain, false, networkContext.onBridge("-p", "udp", "-j", "DROP")},
+		)
+	}
 
-		//FORWARD
+	return rules
+}
 
-		args := []string{"-t", string(iptables.Filter), string(altAction), "FORWARD",
-			"-i", networkContext.BridgeName,
-			"-j", IptablesSoxyChain}
+//onBridge returns rule arguments matching the traffic coming from the network bridge
+func (networkContext *Context) onBridge(args ...string) []string {
+	return append([]string{"-i", networkContext.BridgeName}, args...)
+}
 
-		if output, err := iptables.Raw(args...); err != nil {
-			logrus.Errorf("error '%s' while filter -i forward", err.Error())
-			return err
-		} else if len(output) != 0 {
-			logrus.Errorf("error '%s' while filter -i forward", err.Error())
-			return iptables.ChainError{Chain: "FORWARD", Output: output}
-		}
+//addNetworkIfaceRules adds the network rules, the ones already added are removed if any of them fails
+func (networkContext *Context) addNetworkIfaceRules() error {
+	return addRules(networkContext.rules())
+}
 
-		args = []string{"-t", string(iptables.Filter), string(action), IptablesSoxyChain,
-			"-i", networkContext.BridgeName,
-			"-p", "udp",
-			"--dport", strconv.Itoa(int(networkContext.TunnelDNSPort)),
-			"-j", "RETURN",
-		}
+//deleteNetworkIfaceRules removes the network rules in reverse order, carrying on when one of them fails
+func (networkContext *Context) deleteNetworkIfaceRules() error {
+	return deleteRules(networkContext.rules())
+}
 
-		if output, err := iptables.Raw(args...); err != nil {
-			logrus.Errorf("forwardToRedSocks Authorizing DNS traffic error : %v", err)
-		} else if len(output) != 0 {
-			logrus.Errorf("forwardToRedSocks Authorizing DNS traffic output error : %v", output)
+//addRules adds the given rules, the ones already added are removed if any of them fails
+func addRules(rules []rule) error {
+	undo := &utils.Undo{}
+	for _, r := range rules {
+		if err := r.program(iptables.Append); err != nil {
+			undo.Rollback()
+			return fmt.Errorf("couldn't add rule '%s' : %v", r, err)
 		}
+		added := r
+		undo.Push("rule "+added.String(), func() error { return added.program(iptables.Delete) })
+	}
+	return nil
+}
 
-		args = []string{"-t", string(iptables.Filter), string(action), IptablesSoxyChain,
-			"-i", networkContext.BridgeName,
-			"-p", "udp",
-			"-j", "DROP",
-		}
-		if output, err := iptables.Raw(args...); err != nil {
-			logrus.Errorf("forwardToRedSocks Blocking UDP traffic error : %v", err)
-		} else if len(output) != 0 {
-			logrus.Errorf("forwardToRedSocks Blocking UDP traffic output error : %v", output)
+//deleteRules removes the given rules in reverse order, carrying on when one of them fails
+func deleteRules(rules []rule) error {
+	var err error
+	for i := len(rules) - 1; i >= 0; i-- {
+		if deleteErr := rules[i].program(iptables.Delete); deleteErr != nil {
+			logrus.Errorf("couldn't delete rule '%s' : %v", rules[i], deleteErr)
+			if err == nil {
+				err = deleteErr
+			}
 		}
-
 	}
+	return err
+}
 
-	return nil
+func (networkContext *Context) programKillSwitch(action iptables.Action) error {
+	return networkContext.killSwitchRule().program(action)
+}
+
+//killSwitchRule the rule dropping the network egress traffic while its tunnel is down or its context replaced
+func (networkContext *Context) killSwitchRule() rule {
+	return rule{iptables.Filter, "FORWARD", true, networkContext.onBridge(append(purposeTag("kill-switch"), "-j", "DROP")...)}
 }
+
 func parseNetworkConfiguration(networkContext *Context, params map[string]string, defaultProxyPort int64) error {
 
 	var err error
 
 	for k, v := range params {
-		logrus.Debugf("Param: '%s' is equal to :  '%s'", k, v)
+		logrus.Debugf("Param: '%s' is equal to :  '%s'", k, RedactOption(k, v))
 	}
 
 	if val, ok := params[proxyAddress]; ok {
 		networkContext.ProxyAddress = val
-		if err != nil {
-			return utils.LogAndThrowError("error while parsing ProxyAddress param : {}", val)
-		}
 	} else {
 		networkContext.ProxyAddress = "localhost"
 	}
 
 	if val, ok := params[proxyPort]; ok {
-		networkContext.ProxyPort, err = strconv.ParseInt(val, 10, 32)
-		if err != nil {
-			return utils.LogAndThrowError("error while parsing ProxyPort param : {}", val)
-		}
+		networkContext.ProxyPort, _ = strconv.ParseInt(strings.TrimSpace(val), 10, 32)
 	} else {
 		networkContext.ProxyPort = defaultProxyPort
+		networkContext.FallbackProxy = networkContext.ProxyAddress == "localhost"
+	}
+
+	//the first upstream stands for the network proxy until the tunnel picks the first reachable one
+	if val, ok := params[proxyUpstreams]; ok {
+		networkContext.ProxyUpstreams, _ = parseUpstreams(val)
+		if len(networkContext.ProxyUpstreams) > 0 {
+			host, port, _ := net.SplitHostPort(networkContext.ProxyUpstreams[0])
+			networkContext.ProxyAddress = host
+			networkContext.ProxyPort, _ = strconv.ParseInt(port, 10, 64)
+			networkContext.FallbackProxy = false
+		}
 	}
 
 	if val, ok := params[proxyType]; ok {
-		networkContext.ProxyType = val
+		networkContext.ProxyType = strings.ToLower(strings.TrimSpace(val))
 	}
 
 	if val, ok := params[proxyUser]; ok {
 		networkContext.ProxyUser = val
 	}
 
-	if val, ok := params[proxyPassword]; ok {
-		networkContext.ProxyPassword = val
+	networkContext.ProxyPassword, err = resolveProxyPassword(params)
+	if err != nil {
+		return err
 	}
 
 	if val, ok := params[tunnelBindAddress]; ok {
@@ -264,27 +601,92 @@ func parseNetworkConfiguration(networkContext *Context, params map[string]string
 	}
 
 	if val, ok := params[tunnelPort]; ok {
-		networkContext.TunnelPort, err = strconv.ParseInt(val, 10, 32)
-		if err != nil {
-			logrus.Warningf("error while parsing param '%s' :found value '%s'", tunnelPort, val)
-			networkContext.TunnelPort = utils.FindAvailablePort()
-		}
+		networkContext.TunnelPort, _ = strconv.ParseInt(strings.TrimSpace(val), 10, 32)
 	} else {
 		networkContext.TunnelPort = utils.FindAvailablePort()
 	}
 
+	if val, ok := params[tunnelBackend]; ok {
+		networkContext.Backend = strings.ToLower(strings.TrimSpace(val))
+	} else if sharedTunnel != nil {
+		networkContext.Backend = SharedBackend
+	} else {
+		networkContext.Backend = RedsocksBackend
+	}
+
+	if val, ok := params[dnsMode]; ok {
+		networkContext.DNSMode = strings.ToLower(strings.TrimSpace(val))
+	} else {
+		networkContext.DNSMode = TorDNS
+	}
+
+	if val, ok := params[bypass]; ok {
+		networkContext.Bypass, _ = parseDestinations(val)
+	}
+
+	if val, ok := params[routes]; ok {
+		networkContext.Routes, _ = parseRoutes(val)
+	}
+
 	if val, ok := params[blockUDP]; ok {
-		b, err := strconv.ParseBool(params[blockUDP])
-		if err != nil {
-			logrus.Warningf("param '%s' is invalid boolean '%s'", blockUDP, val)
-			networkContext.BlockUDP = true
-		} else {
-			networkContext.BlockUDP = b
-		}
+		networkContext.BlockUDP, _ = strconv.ParseBool(strings.TrimSpace(val))
+	}
+
+	if val, ok := params[auditMode]; ok {
+		networkContext.Audit = strings.ToLower(strings.TrimSpace(val))
+	} else {
+		networkContext.Audit = audit.Off
 	}
 
 	return nil
 }
+
+func parseDestinations(value string) ([]*net.IPNet, error) {
+	var destinations []*net.IPNet
+	for _, destination := range strings.Split(value, ",") {
+		destination = strings.TrimSpace(destination)
+		if destination == "" {
+			continue
+		}
+		if !strings.Contains(destination, "/") {
+			destination += "/32"
+		}
+		_, network, err := net.ParseCIDR(destination)
+		if err != nil || network.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid ipv4 destination '%s'", destination)
+		}
+		destinations = append(destinations, network)
+	}
+	return destinations, nil
+}
+
+func resolveProxyPassword(params map[string]string) (string, error) {
+	var sources []string
+	for _, key := range []string{proxyPassword, proxyPasswordFile, proxyPasswordEnv, proxyPasswordRef} {
+		if _, ok := params[key]; ok {
+			sources = append(sources, key)
+		}
+	}
+	if len(sources) > 1 {
+		return "", &ConflictingOptionsError{Key: sources[0], Conflicts: sources[1:]}
+	}
+	var password string
+	var err error
+	if val, ok := params[proxyPasswordFile]; ok {
+		password, err = secret.FromFile(val)
+	} else if val, ok := params[proxyPasswordEnv]; ok {
+		password, err = secret.FromEnv(val)
+	} else if val, ok := params[proxyPasswordRef]; ok {
+		password, err = secret.FromStore(val)
+	} else {
+		password = params[proxyPassword]
+	}
+	if err != nil {
+		return "", fmt.Errorf("error while resolving the proxy password : %v", err)
+	}
+	return password, nil
+}
+
 func preconditions(networkContext *Context) error {
 	if networkContext.ProxyAddress == "" {
 		return utils.LogAndThrowError("Proxy address is mandatory")
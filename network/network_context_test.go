@@ -0,0 +1,73 @@
+package network
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	bypassed, _ := parseDestinations("10.20.0.0/16")
+	networkContext := &Context{
+		BridgeName:    "br-test",
+		TunnelPort:    12345,
+		TunnelDNSPort: 5353,
+		DNSMode:       TorDNS,
+		Bypass:        bypassed,
+		BlockUDP:      true,
+	}
+	var rules []string
+	for _, r := range networkContext.rules() {
+		rules = append(rules, r.String())
+	}
+	assert.Equal(t, []string{
+		"-t nat PREROUTING -i br-test -j " + IptablesSoxyChain,
+		"-t nat " + IptablesSoxyChain + " -i br-test -j SOXY-br-test",
+		"-t nat " + IptablesSoxyChain + " -i br-test -d 10.20.0.0/16 -j RETURN",
+		"-t nat " + IptablesSoxyChain + " -i br-test -p udp --dport 53 -j REDIRECT --to-ports 5353",
+		"-t nat " + IptablesSoxyChain + " -i br-test -p tcp --syn -j REDIRECT --to-ports 12345",
+		"-t filter FORWARD -i br-test -j " + IptablesSoxyChain,
+		"-t filter " + IptablesSoxyChain + " -i br-test -p udp --dport 5353 -j RETURN",
+		"-t filter " + IptablesSoxyChain + " -i br-test -p udp -j DROP",
+	}, rules)
+	assert.True(t, networkContext.rules()[5].insert)
+	assert.Equal(t, iptables.Filter, networkContext.rules()[5].table)
+}
+
+func TestModeRules(t *testing.T) {
+	networkContext := &Context{BridgeName: "br-test", TunnelPort: 12345, TunnelDNSPort: 5353, DNSMode: DirectDNS, mode: DirectMode}
+	assert.Empty(t, networkContext.rules())
+
+	networkContext.mode = DropMode
+	assert.Len(t, networkContext.rules(), 1)
+	assert.Equal(t, "-t filter FORWARD -i br-test -m comment --comment "+ruleTag()+"/drop-mode -j DROP", networkContext.rules()[0].String())
+	assert.True(t, networkContext.rules()[0].insert)
+
+	//the kill-switch rule is told apart from the drop mode one, so that removing either leaves the other
+	killSwitch := networkContext.killSwitchRule()
+	assert.Equal(t, "-t filter FORWARD -i br-test -m comment --comment "+ruleTag()+"/kill-switch -j DROP", killSwitch.String())
+	assert.NotEqual(t, killSwitch.taggedArgs(), networkContext.rules()[0].taggedArgs())
+	assert.True(t, tagged(killSwitch.taggedArgs()))
+
+	networkContext.mode = TunnelMode
+	assert.Equal(t, "-t nat PREROUTING -i br-test -j "+IptablesSoxyChain, networkContext.rules()[0].String())
+}
+
+func TestInitMode(t *testing.T) {
+	networkContext := &Context{BridgeName: "br-test", TunnelPort: 12345, TunnelDNSPort: 5353, DNSMode: DirectDNS, mode: TunnelMode}
+	networkContext.InitMode(Pause{Mode: DropMode})
+	assert.Equal(t, networkContext.modeRules(DropMode), networkContext.rules())
+	assert.False(t, networkContext.tunnelWanted(networkContext.Mode()))
+
+	networkContext.InitMode(Pause{Mode: DirectMode, KeepTunnel: true})
+	assert.Empty(t, networkContext.rules())
+	assert.True(t, networkContext.tunnelWanted(networkContext.Mode()))
+}
+
+func TestParseMode(t *testing.T) {
+	mode, err := ParseMode(" Direct ")
+	assert.Nil(t, err)
+	assert.Equal(t, DirectMode, mode)
+	_, err = ParseMode("bridge")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,265 @@
+package network
+
+import (
+	"fmt"
+	"github.com/yassine/soxy-driver/audit"
+	"github.com/yassine/soxy-driver/config"
+	"github.com/yassine/soxy-driver/redsocks"
+	"github.com/yassine/soxy-driver/secret"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//OptionPrefix the prefix of the network options handled by the driver, other options are left untouched
+const OptionPrefix = "soxy."
+
+//OptionType the type of a network option value
+type OptionType string
+
+const (
+	//StringOption any value
+	StringOption OptionType = "string"
+	//IntegerOption an integer within the option bounds
+	IntegerOption OptionType = "integer"
+	//BooleanOption a boolean (true, false, 1, 0 ...)
+	BooleanOption OptionType = "boolean"
+	//ChoiceOption one of the option choices (case insensitive)
+	ChoiceOption OptionType = "choice"
+	//HostOption an ip address or a hostname
+	HostOption OptionType = "host"
+	//IPv4Option an ipv4 address
+	IPv4Option OptionType = "ipv4"
+	//DestinationsOption a comma separated list of ipv4 addresses or CIDRs
+	DestinationsOption OptionType = "destinations"
+	//ProxyURLOption a proxy url
+	ProxyURLOption OptionType = "proxy-url"
+	//UpstreamsOption a comma separated list of proxies (host:port)
+	UpstreamsOption OptionType = "upstreams"
+	//RoutesOption a comma separated list of ipv4 CIDRs, each optionally followed by 'via' and a next hop address
+	RoutesOption OptionType = "routes"
+)
+
+//OptionSpec describes a network option supported by the driver
+type OptionSpec struct {
+	Key         string     `json:"key"`
+	Type        OptionType `json:"type"`
+	Description string     `json:"description"`
+	Default     string     `json:"default,omitempty"`
+	Choices     []string   `json:"choices,omitempty"`
+	Min         int64      `json:"min,omitempty"`
+	Max         int64      `json:"max,omitempty"`
+	Secret      bool       `json:"secret,omitempty"`
+}
+
+//UnknownOptionError a 'soxy.' prefixed option the driver doesn't support
+type UnknownOptionError struct {
+	Key string
+	//Suggestion the supported option the key is likely a typo of, if any
+	Suggestion string
+}
+
+func (e *UnknownOptionError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("unknown param '%s' (did you mean '%s' ?)", e.Key, e.Suggestion)
+	}
+	return fmt.Sprintf("unknown param '%s'", e.Key)
+}
+
+//InvalidOptionError an option whose value doesn't match its specification
+type InvalidOptionError struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e *InvalidOptionError) Error() string {
+	return fmt.Sprintf("param '%s' has invalid value '%s' (%s)", e.Key, RedactOption(e.Key, e.Value), e.Reason)
+}
+
+//RedactOption returns the value to be logged for a given option, the values of the secret options are redacted as a
+//whole as they may not be parsed
+func RedactOption(key string, value string) string {
+	for _, spec := range Options {
+		if spec.Key == key && spec.Secret {
+			return secret.Redacted
+		}
+	}
+	return secret.Redact(key, value)
+}
+
+//ConflictingOptionsError options that can't be given together
+type ConflictingOptionsError struct {
+	Key       string
+	Conflicts []string
+}
+
+func (e *ConflictingOptionsError) Error() string {
+	return fmt.Sprintf("param '%s' can't be combined with %s", e.Key, strings.Join(e.Conflicts, ", "))
+}
+
+//Options the network options supported by the driver
+var Options = buildOptions()
+
+func buildOptions() []OptionSpec {
+	options := []OptionSpec{
+		{Key: proxyURL, Type: ProxyURLOption, Description: "The whole upstream as an url : <type>://[user[:password]@]host:port", Secret: true},
+		{Key: proxyAddress, Type: HostOption, Description: "The address of the proxy through which the traffic is redirected", Default: "localhost"},
+		{Key: proxyPort, Type: IntegerOption, Description: "The proxy port", Min: 1, Max: 65535, Default: "the embedded tor instance socks port"},
+		{Key: proxyUpstreams, Type: UpstreamsOption, Description: "The proxies (host:port, comma separated) tried in order whenever the tunnel starts, the first reachable one is used rather than the proxy address and port"},
+		{Key: proxyType, Type: ChoiceOption, Description: "The proxy type", Choices: redsocks.ProxyTypes, Default: "socks5"},
+		{Key: proxyUser, Type: StringOption, Description: "The proxy user if the proxy requires authentication"},
+		{Key: proxyPassword, Type: StringOption, Description: "The proxy password if the proxy requires authentication", Secret: true},
+		{Key: proxyPasswordFile, Type: StringOption, Description: "The path of a file of the driver secret files directory containing the proxy password"},
+		{Key: proxyPasswordEnv, Type: StringOption, Description: "The name of a driver environment variable, starting with the secret environment prefix, containing the proxy password"},
+		{Key: proxyPasswordRef, Type: StringOption, Description: "The name of a secret in the driver secret store containing the proxy password"},
+		{Key: config.ProfileOption, Type: StringOption, Description: "The name of a proxy profile defined in the driver configuration"},
+		{Key: tunnelBindAddress, Type: IPv4Option, Description: "The address the tunnel listens on"},
+		{Key: tunnelPort, Type: IntegerOption, Description: "The port the tunnel listens on", Min: 1, Max: 65535, Default: "a random available port"},
+		{Key: blockUDP, Type: BooleanOption, Description: "Whether outgoing UDP traffic (except DNS) is blocked", Default: "false"},
+		{Key: dnsMode, Type: ChoiceOption, Description: "How DNS requests are resolved", Choices: []string{TorDNS, DirectDNS}, Default: TorDNS},
+		{Key: bypass, Type: DestinationsOption, Description: "The destinations that aren't tunneled"},
+		{Key: routes, Type: RoutesOption, Description: "The static routes added to the containers, their destinations aren't tunneled"},
+		{Key: tunnelBackend, Type: ChoiceOption, Description: "The transparent proxy implementation the traffic is redirected to", Choices: []string{RedsocksBackend, ExecBackend, SharedBackend}, Default: "shared when the driver shared tunnel is enabled, redsocks otherwise"},
+		{Key: tunnelBinary, Type: StringOption, Description: "The transparent proxy binary run by the exec backend, one of the binaries the driver configuration allows"},
+		{Key: tunnelArgs, Type: StringOption, Description: "The exec backend arguments go template", Default: "-c {{.ConfigFile}} when a template is given"},
+		{Key: tunnelTemplate, Type: StringOption, Description: "The path of the exec backend configuration file go template, within the template directory the driver configuration allows"},
+		{Key: auditMode, Type: ChoiceOption, Description: "Where a record of every tunneled connection is written (redsocks and shared backends only)", Choices: audit.Modes, Default: audit.Off},
+		{Key: redsocks.OnProxyFailOption, Type: ChoiceOption, Description: "What redsocks does with client connections when the proxy is unreachable", Choices: redsocks.OnProxyFailValues},
+		{Key: redsocks.DiscloseSrcOption, Type: ChoiceOption, Description: "How the client address is disclosed to http-connect proxies", Choices: redsocks.DiscloseSrcValues},
+		{Key: redsocks.SpliceOption, Type: BooleanOption, Description: "Whether redsocks relays data using splice(2)"},
+		{Key: redsocks.DebugOption, Type: BooleanOption, Description: "Whether redsocks debug logging is enabled", Default: "false"},
+	}
+	descriptions := map[string]string{
+		redsocks.ListenqOption:           "The tunnel listening socket backlog",
+		redsocks.MinAcceptBackoffOption:  "The minimal delay (ms) before accepting again once the file descriptors are exhausted",
+		redsocks.MaxAcceptBackoffOption:  "The maximal delay (ms) before accepting again once the file descriptors are exhausted",
+		redsocks.IdleTimeoutOption:       "The delay (s) after which idle half-closed connections are dropped",
+		redsocks.KeepaliveTimeOption:     "The tcp keepalive idle time (s) of tunneled connections",
+		redsocks.KeepaliveIntervalOption: "The tcp keepalive probes interval (s) of tunneled connections",
+		redsocks.KeepaliveProbesOption:   "The tcp keepalive probes count of tunneled connections",
+	}
+	for _, bounds := range redsocks.IntegerOptions {
+		options = append(options, OptionSpec{Key: bounds.Key, Type: IntegerOption, Description: descriptions[bounds.Key], Min: bounds.Min, Max: bounds.Max})
+	}
+	return options
+}
+
+//ValidateOptions checks the 'soxy.' prefixed options against their specification
+func ValidateOptions(params map[string]string) error {
+	specs := make(map[string]OptionSpec)
+	for _, spec := range Options {
+		specs[spec.Key] = spec
+	}
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, OptionPrefix) {
+			continue
+		}
+		spec, ok := specs[key]
+		if !ok {
+			return &UnknownOptionError{Key: key, Suggestion: suggestOption(key)}
+		}
+		if reason := spec.check(params[key]); reason != "" {
+			return &InvalidOptionError{Key: key, Value: params[key], Reason: reason}
+		}
+	}
+	return nil
+}
+
+//check returns why a given value doesn't match the option specification, empty if it does
+func (spec OptionSpec) check(value string) string {
+	switch spec.Type {
+	case IntegerOption:
+		parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || parsed < spec.Min || parsed > spec.Max {
+			return fmt.Sprintf("expected an integer between %d and %d", spec.Min, spec.Max)
+		}
+	case BooleanOption:
+		if _, err := strconv.ParseBool(strings.TrimSpace(value)); err != nil {
+			return "expected a boolean"
+		}
+	case ChoiceOption:
+		for _, choice := range spec.Choices {
+			if strings.EqualFold(strings.TrimSpace(value), choice) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("available choices : %s", strings.Join(spec.Choices, ", "))
+	case HostOption:
+		if !utils.IsHost(value) {
+			return "expected an ip address or a hostname"
+		}
+	case IPv4Option:
+		if net.ParseIP(value).To4() == nil {
+			return "expected an ipv4 address"
+		}
+	case DestinationsOption:
+		if _, err := parseDestinations(value); err != nil {
+			return err.Error()
+		}
+	case ProxyURLOption:
+		if _, err := parseProxyURL(value); err != nil {
+			return err.Error()
+		}
+	case RoutesOption:
+		if _, err := parseRoutes(value); err != nil {
+			return err.Error()
+		}
+	case UpstreamsOption:
+		if _, err := parseUpstreams(value); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+//suggestOption returns the supported option an unknown key is the closest to, if close enough to be a typo
+func suggestOption(key string) string {
+	suggestion, best := "", 3
+	for _, spec := range Options {
+		if strings.EqualFold(spec.Key, key) {
+			return spec.Key
+		}
+		if distance := levenshtein(strings.ToLower(key), strings.ToLower(spec.Key)); distance < best {
+			suggestion, best = spec.Key, distance
+		}
+	}
+	return suggestion
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minimum(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minimum(values ...int) int {
+	result := values[0]
+	for _, value := range values[1:] {
+		if value < result {
+			result = value
+		}
+	}
+	return result
+}
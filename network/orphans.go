@@ -0,0 +1,133 @@
+package network
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"strings"
+)
+
+//ruleChain a chain the driver adds network rules to
+type ruleChain struct {
+	table iptables.Table
+	chain string
+}
+
+//RemoveOrphanRules removes the network rules bound to other bridges than the given ones, e.g. the rules left behind by
+//the networks removed while the driver was down. The drop and masquerade rules of the built-in chains are only removed
+//when tagged by the driver namespace and their bridge doesn't exist anymore, the containers chains another namespace may
+//own only when their bridge doesn't exist anymore
+func RemoveOrphanRules(bridges []string) {
+	chains := []ruleChain{
+		{iptables.Nat, "PREROUTING"},
+		{iptables.Nat, IptablesSoxyChain},
+		{iptables.Nat, "POSTROUTING"},
+		{iptables.Filter, "FORWARD"},
+		{iptables.Filter, IptablesSoxyChain},
+	}
+	for _, target := range chains {
+		output, err := iptables.Raw("-t", string(target.table), "-S", target.chain)
+		if err != nil {
+			logrus.Debugf("couldn't list the '%s' chain of table '%s' : %v", target.chain, target.table, err)
+			continue
+		}
+		for _, args := range orphanRules(target.chain, string(output), bridges, linkExists) {
+			logrus.Infof("removing orphan rule '-t %s %s %s'", target.table, target.chain, strings.Join(args, " "))
+			deleteArgs := append([]string{"-t", string(target.table), string(iptables.Delete), target.chain}, args...)
+			if output, err := iptables.Raw(deleteArgs...); err != nil || len(output) != 0 {
+				logrus.Errorf("couldn't remove orphan rule '%s' : %v %s", strings.Join(args, " "), err, output)
+			}
+		}
+	}
+	output, err := iptables.Raw("-t", string(iptables.Nat), "-S")
+	if err != nil {
+		logrus.Debugf("couldn't list the chains of table '%s' : %v", iptables.Nat, err)
+		return
+	}
+	for _, chain := range orphanChains(string(output), bridges, linkExists) {
+		logrus.Infof("removing orphan chain '%s'", chain)
+		iptables.Raw("-t", string(iptables.Nat), "-F", chain)
+		if output, err := iptables.Raw("-t", string(iptables.Nat), "-X", chain); err != nil || len(output) != 0 {
+			logrus.Errorf("couldn't remove orphan chain '%s' : %v %s", chain, err, output)
+		}
+	}
+}
+
+//orphanChains returns the containers chains of the bridges that don't exist anymore, among the ones listed by 'iptables -S'
+func orphanChains(listing string, bridges []string, linkExists func(name string) bool) []string {
+	var orphans []string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "-N" || !strings.HasPrefix(fields[1], policyChainPrefix) {
+			continue
+		}
+		bridge := strings.TrimPrefix(fields[1], policyChainPrefix)
+		if !knownBridge(bridges, bridge) && !linkExists(bridge) {
+			orphans = append(orphans, fields[1])
+		}
+	}
+	return orphans
+}
+
+//orphanRules returns the arguments of the orphan rules among the ones of a chain, as listed by 'iptables -S'
+func orphanRules(chain string, listing string, bridges []string, linkExists func(name string) bool) [][]string {
+	var orphans [][]string
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] != "-A" || fields[1] != chain {
+			continue
+		}
+		args := fields[2:]
+		in, jump := argValue(args, "-i"), argValue(args, "-j")
+		orphan := false
+		switch {
+		case chain == IptablesSoxyChain:
+			orphan = in != "" && !knownBridge(bridges, in)
+		case jump == IptablesSoxyChain:
+			orphan = in != "" && !knownBridge(bridges, in)
+		case !tagged(args):
+			//the rules of the built-in chains below may be owned by docker or other tools
+		case jump == "DROP" && in != "":
+			//the kill-switch, the drop mode and the pending containers rules
+			orphan = !knownBridge(bridges, in) && !linkExists(in)
+		case jump == "MASQUERADE" && argValue(args, "!") == "-o":
+			//the secondary subnets rule
+			out := argValue(args, "-o")
+			orphan = out != "" && !knownBridge(bridges, out) && !linkExists(out)
+		}
+		if orphan {
+			orphans = append(orphans, args)
+		}
+	}
+	return orphans
+}
+
+//tagged whether a listed rule carries the comment of the driver namespace, along with its purpose or not
+func tagged(args []string) bool {
+	comment := strings.Trim(argValue(args, "--comment"), `"`)
+	return comment == ruleTag() || strings.HasPrefix(comment, ruleTag()+"/")
+}
+
+//argValue returns the value following a given flag, empty if absent
+func argValue(args []string, flag string) string {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func knownBridge(bridges []string, name string) bool {
+	for _, bridge := range bridges {
+		if bridge == name {
+			return true
+		}
+	}
+	return false
+}
+
+func linkExists(name string) bool {
+	_, err := netlink.LinkByName(name)
+	return err == nil
+}
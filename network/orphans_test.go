@@ -0,0 +1,50 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestOrphanRules(t *testing.T) {
+	links := map[string]bool{"br-live": true, "br-foreign": true}
+	linkExists := func(name string) bool { return links[name] }
+	bridges := []string{"br-live"}
+
+	prerouting := "-P PREROUTING ACCEPT\n" +
+		"-A PREROUTING -m addrtype --dst-type LOCAL -j DOCKER\n" +
+		"-A PREROUTING -i br-live -j " + IptablesSoxyChain + "\n" +
+		"-A PREROUTING -i br-gone -j " + IptablesSoxyChain + "\n"
+	assert.Equal(t, [][]string{{"-i", "br-gone", "-j", IptablesSoxyChain}}, orphanRules("PREROUTING", prerouting, bridges, linkExists))
+
+	chain := "-N " + IptablesSoxyChain + "\n" +
+		"-A " + IptablesSoxyChain + " -d 10.0.0.0/8 -j RETURN\n" +
+		"-A " + IptablesSoxyChain + " -i br-gone -p tcp -m tcp --tcp-flags FIN,SYN,RST,ACK SYN -j REDIRECT --to-ports 12345\n"
+	assert.Len(t, orphanRules(IptablesSoxyChain, chain, bridges, linkExists), 1)
+
+	tag := " -m comment --comment " + ruleTag()
+	forward := "-A FORWARD -i br-gone" + tag + " -j DROP\n" +
+		"-A FORWARD -i br-gone -j DROP\n" +
+		"-A FORWARD -i br-foreign" + tag + " -j DROP\n" +
+		"-A FORWARD -i br-gone -s 172.30.2.5/32" + tag + " -j DROP\n" +
+		"-A FORWARD -i br-gone -m comment --comment soxy-driver:other -j DROP\n"
+	assert.Equal(t, [][]string{
+		{"-i", "br-gone", "-m", "comment", "--comment", ruleTag(), "-j", "DROP"},
+		{"-i", "br-gone", "-s", "172.30.2.5/32", "-m", "comment", "--comment", ruleTag(), "-j", "DROP"},
+	}, orphanRules("FORWARD", forward, bridges, linkExists))
+
+	postrouting := "-A POSTROUTING -s 172.30.2.0/24 ! -o br-gone" + tag + " -j MASQUERADE\n" +
+		"-A POSTROUTING -s 172.30.3.0/24 ! -o br-gone -j MASQUERADE\n" +
+		"-A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE\n"
+	links["docker0"] = true
+	assert.Equal(t, [][]string{{"-s", "172.30.2.0/24", "!", "-o", "br-gone", "-m", "comment", "--comment", ruleTag(), "-j", "MASQUERADE"}},
+		orphanRules("POSTROUTING", postrouting, bridges, linkExists))
+
+	chains := "-P PREROUTING ACCEPT\n-N DOCKER\n-N SOXY-br-live\n-N SOXY-br-foreign\n-N SOXY-br-gone\n"
+	assert.Equal(t, []string{"SOXY-br-gone"}, orphanChains(chains, bridges, linkExists))
+}
+
+func TestRuleTaggedArgs(t *testing.T) {
+	redirect := rule{args: []string{"-i", "br-test", "-p", "tcp", "-j", "REDIRECT", "--to-ports", "12345"}}
+	assert.Equal(t, []string{"-i", "br-test", "-p", "tcp", "-m", "comment", "--comment", ruleTag(), "-j", "REDIRECT", "--to-ports", "12345"}, redirect.taggedArgs())
+	assert.Equal(t, []string{"-i", "br-test", "-p", "tcp", "-j", "REDIRECT", "--to-ports", "12345"}, redirect.args)
+}
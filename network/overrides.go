@@ -0,0 +1,75 @@
+package network
+
+import (
+	"fmt"
+)
+
+//OverridableOptions the options that can be changed on an existing network through the management API, the file and
+//environment password references aren't as they could point to the driver own secrets
+var OverridableOptions = []string{proxyURL, proxyAddress, proxyPort, proxyType, proxyUser,
+	proxyPassword, proxyPasswordRef, bypass, blockUDP}
+
+var passwordOptions = []string{proxyPassword, proxyPasswordFile, proxyPasswordEnv, proxyPasswordRef}
+
+//ValidateOverrides checks that the given options can be changed at runtime and that their values are valid
+func ValidateOverrides(overrides map[string]string) error {
+	for key, value := range overrides {
+		if !isOverridable(key) {
+			return &InvalidOptionError{Key: key, Value: value, Reason: fmt.Sprintf("only %v can be changed on an existing network", OverridableOptions)}
+		}
+	}
+	return ValidateOptions(overrides)
+}
+
+//ApplyOverrides returns the network params with the given overrides applied : an overridden upstream url or password
+//replaces the one the network was created with
+func ApplyOverrides(params map[string]string, overrides map[string]string) (map[string]string, error) {
+	if len(overrides) == 0 {
+		return params, nil
+	}
+	base, err := expandProxyURL(params)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for key, value := range base {
+		result[key] = value
+	}
+	if value, ok := overrides[proxyURL]; ok {
+		upstream, err := parseProxyURL(value)
+		if err != nil {
+			return nil, &InvalidOptionError{Key: proxyURL, Value: value, Reason: err.Error()}
+		}
+		for _, key := range proxyURLKeys {
+			delete(result, key)
+		}
+		if _, ok := upstream[proxyPassword]; ok {
+			deleteKeys(result, passwordOptions)
+		}
+	}
+	for _, key := range passwordOptions {
+		if _, ok := overrides[key]; ok {
+			deleteKeys(result, passwordOptions)
+			break
+		}
+	}
+	for key, value := range overrides {
+		result[key] = value
+	}
+	return result, nil
+}
+
+func isOverridable(key string) bool {
+	for _, overridable := range OverridableOptions {
+		if key == overridable {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteKeys(params map[string]string, keys []string) {
+	for _, key := range keys {
+		delete(params, key)
+	}
+}
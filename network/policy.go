@@ -0,0 +1,379 @@
+package network
+
+import (
+	"fmt"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/config"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	//ExemptLabel the container label exempting a container traffic from the network tunnel
+	ExemptLabel = "soxy.exempt"
+	//BypassLabel the container label listing destinations the container reaches without the network tunnel
+	BypassLabel = "soxy.bypass"
+	//ProfileLabel the container label naming the profile the container traffic is tunneled through
+	ProfileLabel = config.ProfileOption
+	//policyChainPrefix the prefix of the chains holding the containers rules of a network
+	policyChainPrefix = "SOXY-"
+)
+
+//Policy how the traffic of a container attached to a network is tunneled, as set through its labels
+type Policy struct {
+	//Exempt whether the container traffic isn't tunneled at all
+	Exempt bool `json:"exempt,omitempty"`
+	//Bypass the destinations the container reaches without tunnel
+	Bypass []string `json:"bypass,omitempty"`
+	//Profile the name of the profile the container traffic is tunneled through rather than the network one
+	Profile string `json:"profile,omitempty"`
+	//ProfileParams the network options of the profile
+	ProfileParams map[string]string `json:"-"`
+	//Pending whether the container couldn't be resolved yet : its traffic is dropped until its labels are known
+	Pending bool `json:"pending,omitempty"`
+}
+
+//ParsePolicy returns the policy set by the labels of a container, the profile params are left to the caller
+func ParsePolicy(labels map[string]string) (Policy, error) {
+	policy := Policy{}
+	if value, ok := labels[ExemptLabel]; ok {
+		exempt, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return policy, fmt.Errorf("invalid label '%s' value '%s' : a boolean is expected", ExemptLabel, value)
+		}
+		policy.Exempt = exempt
+	}
+	if value, ok := labels[BypassLabel]; ok {
+		destinations, err := parseDestinations(value)
+		if err != nil {
+			return policy, fmt.Errorf("invalid label '%s' value '%s' : %v", BypassLabel, value, err)
+		}
+		for _, destination := range destinations {
+			policy.Bypass = append(policy.Bypass, destination.String())
+		}
+	}
+	policy.Profile = strings.TrimSpace(labels[ProfileLabel])
+	if policy.Exempt && policy.Profile != "" {
+		return policy, fmt.Errorf("labels '%s' and '%s' are mutually exclusive", ExemptLabel, ProfileLabel)
+	}
+	return policy, nil
+}
+
+//IsZero whether the policy leaves the container traffic to the network defaults
+func (policy Policy) IsZero() bool {
+	return !policy.Exempt && len(policy.Bypass) == 0 && policy.Profile == "" && !policy.Pending
+}
+
+//endpointPolicy a policy applied to a network endpoint
+type endpointPolicy struct {
+	Policy
+	address net.IP
+	//programmed whether the policy rules are added and its profile tunnel acquired
+	programmed bool
+	rules      []rule
+}
+
+//profileTunnel a tunnel shared by the endpoints of a network using a given profile
+type profileTunnel struct {
+	context   *Context
+	endpoints int
+}
+
+//policyChain the chain holding the network containers rules, the traffic of the network bridge is sent through it first
+func (networkContext *Context) policyChain() string {
+	return policyChainPrefix + networkContext.BridgeName
+}
+
+//createPolicyChain creates the network containers chain, flushing it if it already exists
+func (networkContext *Context) createPolicyChain() error {
+	chain := networkContext.policyChain()
+	if output, err := iptables.Raw("-t", string(iptables.Nat), "-N", chain); err != nil || len(output) != 0 {
+		logrus.Debugf("couldn't create chain '%s', flushing it : %v %s", chain, err, output)
+		if output, err = iptables.Raw("-t", string(iptables.Nat), "-F", chain); err != nil {
+			return err
+		} else if len(output) != 0 {
+			return iptables.ChainError{Chain: chain, Output: output}
+		}
+	}
+	return nil
+}
+
+//deletePolicyChain removes the network containers chain
+func (networkContext *Context) deletePolicyChain() {
+	chain := networkContext.policyChain()
+	iptables.Raw("-t", string(iptables.Nat), "-F", chain)
+	if output, err := iptables.Raw("-t", string(iptables.Nat), "-X", chain); err != nil || len(output) != 0 {
+		logrus.Errorf("couldn't remove chain '%s' : %v %s", chain, err, output)
+	}
+}
+
+//policyRules returns the rules applying a policy to the traffic of a given address
+func (networkContext *Context) policyRules(address net.IP, policy Policy, tunnelPort int64) []rule {
+	chain := networkContext.policyChain()
+	source := []string{"-s", address.String() + "/32"}
+	if policy.Pending {
+		//the traffic skips the network tunnel and is dropped once forwarded
+		return []rule{
+			{iptables.Nat, chain, false, append(source, "-j", "ACCEPT")},
+			{iptables.Filter, "FORWARD", true, append([]string{"-i", networkContext.BridgeName}, append(source, "-j", "DROP")...)},
+		}
+	}
+	if policy.Exempt {
+		return []rule{{iptables.Nat, chain, false, append(source, "-j", "ACCEPT")}}
+	}
+	var rules []rule
+	for _, destination := range policy.Bypass {
+		rules = append(rules, rule{iptables.Nat, chain, false, append(source, "-d", destination, "-j", "ACCEPT")})
+	}
+	if policy.Profile != "" {
+		rules = append(rules, rule{iptables.Nat, chain, false,
+			append(source, "-p", "tcp", "--syn", "-j", "REDIRECT", "--to-ports", strconv.Itoa(int(tunnelPort)))})
+	}
+	return rules
+}
+
+//SetEndpointPolicy applies a policy to the traffic of an endpoint, replacing its current one if any. The profile tunnel
+//the policy relies on is started if needed
+func (networkContext *Context) SetEndpointPolicy(endpointID string, policy Policy) error {
+	networkContext.policyLock.Lock()
+	defer networkContext.policyLock.Unlock()
+	address := networkContext.EndpointAddress(endpointID)
+	if address == nil {
+		return fmt.Errorf("unknown endpoint '%s'", endpointID)
+	}
+	applied := &endpointPolicy{Policy: policy, address: address}
+	if err := networkContext.programPolicy(applied); err != nil {
+		return err
+	}
+	networkContext.lock.Lock()
+	previous := networkContext.policies[endpointID]
+	networkContext.policies[endpointID] = applied
+	networkContext.lock.Unlock()
+	if previous != nil {
+		networkContext.unprogramPolicy(previous)
+	}
+	if policy.Pending {
+		logrus.Infof("dropping the traffic of endpoint '%s' on network '%s' until its container is resolved", endpointID, networkContext.ID)
+	} else if !policy.IsZero() {
+		logrus.Infof("applied the policy of endpoint '%s' on network '%s'", endpointID, networkContext.ID)
+	}
+	return nil
+}
+
+//RemoveEndpointPolicy removes the policy applied to an endpoint, stopping the profile tunnel it relied on if unused
+func (networkContext *Context) RemoveEndpointPolicy(endpointID string) {
+	networkContext.policyLock.Lock()
+	defer networkContext.policyLock.Unlock()
+	networkContext.lock.Lock()
+	previous := networkContext.policies[endpointID]
+	delete(networkContext.policies, endpointID)
+	networkContext.lock.Unlock()
+	if previous != nil {
+		networkContext.unprogramPolicy(previous)
+	}
+}
+
+//HasEndpointPolicy whether a policy, possibly a zero one, was applied to an endpoint, a pending one aside
+func (networkContext *Context) HasEndpointPolicy(endpointID string) bool {
+	networkContext.lock.Lock()
+	defer networkContext.lock.Unlock()
+	applied, ok := networkContext.policies[endpointID]
+	return ok && !applied.Pending
+}
+
+//programPolicy starts the profile tunnel of a policy, if any, and adds its rules
+func (networkContext *Context) programPolicy(applied *endpointPolicy) error {
+	var tunnelPort int64
+	policy := applied.Policy
+	if applied.Profile != "" {
+		tunnel, err := networkContext.acquireProfileTunnel(applied.Profile, applied.ProfileParams)
+		if err != nil {
+			return err
+		}
+		tunnelPort = tunnel.context.TunnelPort
+		if shared, ok := tunnel.context.tunnel.(*sharedBackend); ok {
+			shared.routeAddress(applied.address)
+		}
+		//neither the profile bypassed destinations nor the network routed ones are tunneled
+		policy.Bypass = append([]string{}, policy.Bypass...)
+		for _, destination := range tunnel.context.Bypass {
+			policy.Bypass = append(policy.Bypass, destination.String())
+		}
+		for _, route := range networkContext.Routes {
+			policy.Bypass = append(policy.Bypass, route.Destination.String())
+		}
+	}
+	rules := networkContext.policyRules(applied.address, policy, tunnelPort)
+	if err := addRules(rules); err != nil {
+		if applied.Profile != "" {
+			networkContext.unrouteProfileAddress(applied)
+			networkContext.releaseProfileTunnel(applied.Profile)
+		}
+		return utils.LogAndThrowError("couldn't apply the policy of '%s' on network '%s' : %v", applied.address, networkContext.ID, err)
+	}
+	applied.rules = rules
+	applied.programmed = true
+	return nil
+}
+
+//unprogramPolicy removes the rules of a policy and releases its profile tunnel, if any
+func (networkContext *Context) unprogramPolicy(applied *endpointPolicy) {
+	if !applied.programmed {
+		return
+	}
+	utils.LogIfNotNull(deleteRules(applied.rules))
+	applied.rules = nil
+	applied.programmed = false
+	if applied.Profile != "" {
+		networkContext.unrouteProfileAddress(applied)
+		networkContext.releaseProfileTunnel(applied.Profile)
+	}
+}
+
+//unrouteProfileAddress removes the shared tunnel route of the address of a policy, if its profile relies on it
+func (networkContext *Context) unrouteProfileAddress(applied *endpointPolicy) {
+	if tunnel, ok := networkContext.profileTunnels[applied.Profile]; ok {
+		if shared, ok := tunnel.context.tunnel.(*sharedBackend); ok {
+			shared.unrouteAddress(applied.address)
+		}
+	}
+}
+
+//acquireProfileTunnel returns the tunnel of a given profile, starting it if no endpoint uses it yet
+func (networkContext *Context) acquireProfileTunnel(profile string, params map[string]string) (*profileTunnel, error) {
+	if tunnel, ok := networkContext.profileTunnels[profile]; ok {
+		tunnel.endpoints++
+		return tunnel, nil
+	}
+	tunnelContext, err := networkContext.newProfileContext(params)
+	if err != nil {
+		return nil, fmt.Errorf("invalid profile '%s' : %v", profile, err)
+	}
+	tunnelContext.tunnel.OnFailure(func(status supervisor.Status) {
+		networkContext.Fail(fmt.Sprintf("profile '%s' %s gave up after %d restarts, last exit code %d", profile, status.Name, status.Restarts, status.LastExitCode))
+	})
+	if err = tunnelContext.startTunnel(); err != nil {
+		utils.LogIfNotNull(tunnelContext.tunnel.Stop())
+		return nil, utils.LogAndThrowError("couldn't start the profile '%s' tunnel of network '%s' : %v", profile, networkContext.ID, err)
+	}
+	tunnel := &profileTunnel{context: tunnelContext, endpoints: 1}
+	networkContext.profileTunnels[profile] = tunnel
+	return tunnel, nil
+}
+
+//newProfileContext returns the context of a profile tunnel, built from the profile params : it runs on the network
+//backend and subnets rather than on the default ones, a shared tunnel listening on the network gateways
+func (networkContext *Context) newProfileContext(params map[string]string) (*Context, error) {
+	profileParams := make(map[string]string)
+	for key, value := range params {
+		profileParams[key] = value
+	}
+	profileParams[tunnelBackend] = networkContext.Backend
+	for _, key := range []string{tunnelBinary, tunnelArgs, tunnelTemplate} {
+		if value, ok := networkContext.params[key]; ok {
+			profileParams[key] = value
+		}
+	}
+	tunnelContext, err := NewContext(networkContext.ID, networkContext.BridgeName, profileParams, networkContext.defaultProxyPort, networkContext.TunnelDNSPort)
+	if err != nil {
+		return nil, err
+	}
+	tunnelContext.Subnets = networkContext.Subnets
+	if shared, ok := tunnelContext.tunnel.(*sharedBackend); ok {
+		shared.profile = true
+	}
+	return tunnelContext, nil
+}
+
+//releaseProfileTunnel stops the tunnel of a given profile once no endpoint uses it anymore
+func (networkContext *Context) releaseProfileTunnel(profile string) {
+	tunnel, ok := networkContext.profileTunnels[profile]
+	if !ok {
+		return
+	}
+	tunnel.endpoints--
+	if tunnel.endpoints > 0 {
+		return
+	}
+	delete(networkContext.profileTunnels, profile)
+	utils.LogIfNotNull(tunnel.context.tunnel.Stop())
+}
+
+//programPolicies applies the policies the network context inherited, the ones that fail are left to the network defaults
+func (networkContext *Context) programPolicies() {
+	networkContext.policyLock.Lock()
+	defer networkContext.policyLock.Unlock()
+	networkContext.lock.Lock()
+	policies := make(map[string]*endpointPolicy)
+	for endpointID, applied := range networkContext.policies {
+		policies[endpointID] = applied
+	}
+	networkContext.lock.Unlock()
+	for endpointID, applied := range policies {
+		if err := networkContext.programPolicy(applied); err != nil {
+			logrus.Errorf("endpoint '%s' of network '%s' falls back to the network tunnel : %v", endpointID, networkContext.ID, err)
+		}
+	}
+}
+
+//unprogramPolicies removes the rules of every policy and stops the profile tunnels, the policies are kept
+func (networkContext *Context) unprogramPolicies() {
+	networkContext.policyLock.Lock()
+	defer networkContext.policyLock.Unlock()
+	networkContext.lock.Lock()
+	var policies []*endpointPolicy
+	for _, applied := range networkContext.policies {
+		policies = append(policies, applied)
+	}
+	networkContext.lock.Unlock()
+	for _, applied := range policies {
+		networkContext.unprogramPolicy(applied)
+	}
+}
+
+//EndpointTunnel how the traffic of an endpoint is tunneled, its policy taken into account
+type EndpointTunnel struct {
+	//Exempt whether the endpoint traffic isn't tunneled at all
+	Exempt bool
+	//Pending whether the endpoint traffic is dropped until its container is resolved
+	Pending bool
+	//Profile the profile the endpoint traffic is tunneled through, empty when it's the network one
+	Profile    string
+	Upstream   Upstream
+	TunnelPort int64
+	Health     supervisor.Status
+}
+
+//EndpointTunnel returns how the traffic of a given endpoint is tunneled
+func (networkContext *Context) EndpointTunnel(endpointID string) EndpointTunnel {
+	networkContext.policyLock.Lock()
+	defer networkContext.policyLock.Unlock()
+	networkContext.lock.Lock()
+	applied := networkContext.policies[endpointID]
+	networkContext.lock.Unlock()
+	if applied != nil && applied.programmed {
+		if applied.Pending {
+			return EndpointTunnel{Pending: true}
+		}
+		if applied.Exempt {
+			return EndpointTunnel{Exempt: true}
+		}
+		if tunnel, ok := networkContext.profileTunnels[applied.Profile]; ok {
+			return EndpointTunnel{
+				Profile:    applied.Profile,
+				Upstream:   tunnel.context.upstream(),
+				TunnelPort: tunnel.context.TunnelPort,
+				Health:     tunnel.context.tunnel.Health(),
+			}
+		}
+	}
+	return EndpointTunnel{
+		Upstream:   networkContext.upstream(),
+		TunnelPort: networkContext.TunnelPort,
+		Health:     networkContext.tunnel.Health(),
+	}
+}
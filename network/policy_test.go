@@ -0,0 +1,86 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/tunnel"
+	"net"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy(map[string]string{"soxy.bypass": "10.1.0.0/16,192.168.1.10", "soxy.profile": " corp ", "other": "label"})
+	assert.Nil(t, err)
+	assert.Equal(t, Policy{Bypass: []string{"10.1.0.0/16", "192.168.1.10/32"}, Profile: "corp"}, policy)
+
+	policy, err = ParsePolicy(map[string]string{})
+	assert.Nil(t, err)
+	assert.True(t, policy.IsZero())
+
+	invalid := []map[string]string{
+		{"soxy.exempt": "maybe"},
+		{"soxy.bypass": "nowhere"},
+		{"soxy.exempt": "true", "soxy.profile": "corp"},
+	}
+	for _, labels := range invalid {
+		_, err = ParsePolicy(labels)
+		assert.NotNil(t, err, "labels %v should be rejected", labels)
+	}
+}
+
+func TestPolicyRules(t *testing.T) {
+	networkContext := &Context{BridgeName: "br-test"}
+	address := net.ParseIP("172.30.1.2")
+	var rules []string
+	for _, r := range networkContext.policyRules(address, Policy{Bypass: []string{"10.1.0.0/16"}, Profile: "corp"}, 23456) {
+		rules = append(rules, r.String())
+	}
+	assert.Equal(t, []string{
+		"-t nat SOXY-br-test -s 172.30.1.2/32 -d 10.1.0.0/16 -j ACCEPT",
+		"-t nat SOXY-br-test -s 172.30.1.2/32 -p tcp --syn -j REDIRECT --to-ports 23456",
+	}, rules)
+
+	exempt := networkContext.policyRules(address, Policy{Exempt: true, Bypass: []string{"10.1.0.0/16"}}, 0)
+	assert.Len(t, exempt, 1)
+	assert.Equal(t, "-t nat SOXY-br-test -s 172.30.1.2/32 -j ACCEPT", exempt[0].String())
+
+	rules = nil
+	for _, r := range networkContext.policyRules(address, Policy{Pending: true}, 0) {
+		rules = append(rules, r.String())
+	}
+	assert.Equal(t, []string{
+		"-t nat SOXY-br-test -s 172.30.1.2/32 -j ACCEPT",
+		"-t filter FORWARD -i br-test -s 172.30.1.2/32 -j DROP",
+	}, rules)
+}
+
+func TestProfileContext(t *testing.T) {
+	SetSharedTunnel(tunnel.NewShared("", 12345))
+	defer SetSharedTunnel(nil)
+	_, pool, _ := net.ParseCIDR("172.30.0.0/16")
+	subnets := []Subnet{{Pool: pool, Gateway: &net.IPNet{IP: net.ParseIP("172.30.0.1"), Mask: pool.Mask}}}
+	params := map[string]string{"soxy.proxyaddress": "proxy.corp", "soxy.proxyport": "1080", "soxy.proxytype": "socks5"}
+
+	//a redsocks network profile tunnel doesn't default to the shared tunnel
+	networkContext := &Context{ID: "4f1e0d6a5b2c", BridgeName: "br-4f1e0d6a5b2c", Backend: RedsocksBackend, Subnets: subnets,
+		params: map[string]string{tunnelBackend: RedsocksBackend}}
+	tunnelContext, err := networkContext.newProfileContext(params)
+	assert.Nil(t, err)
+	assert.Equal(t, RedsocksBackend, tunnelContext.Backend)
+	assert.Equal(t, subnets, tunnelContext.Subnets)
+	assert.NotContains(t, params, tunnelBackend)
+
+	//a shared network profile tunnel listens on the network gateways, only the profile addresses are routed
+	networkContext.Backend = SharedBackend
+	networkContext.params = map[string]string{}
+	tunnelContext, err = networkContext.newProfileContext(params)
+	assert.Nil(t, err)
+	backend := tunnelContext.tunnel.(*sharedBackend)
+	assert.Nil(t, backend.Start())
+	assert.Equal(t, []net.IP{subnets[0].Gateway.IP}, backend.gateways)
+	assert.Empty(t, sharedTunnel.Routes())
+	backend.routeAddress(net.ParseIP("172.30.0.5"))
+	assert.Equal(t, []string{"172.30.0.5/32"}, sharedTunnel.Routes())
+	backend.unrouteAddress(net.ParseIP("172.30.0.5"))
+	assert.Nil(t, backend.Stop())
+	assert.Empty(t, backend.gateways)
+}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+//Route a static route added to the containers joining the network
+type Route struct {
+	Destination *net.IPNet
+	//NextHop the gateway the destination is reached through, nil when the destination is directly connected to the
+	//container interface
+	NextHop net.IP
+}
+
+func (route Route) String() string {
+	if route.NextHop == nil {
+		return route.Destination.String()
+	}
+	return route.Destination.String() + " via " + route.NextHop.String()
+}
+
+//parseRoutes parses a comma separated list of routes : an ipv4 CIDR, followed by 'via' and the next hop address unless
+//the destination is directly connected, e.g. '10.8.0.0/16 via 172.30.0.5,192.168.50.0/24'
+func parseRoutes(value string) ([]Route, error) {
+	var routes []Route
+	for _, element := range strings.Split(value, ",") {
+		fields := strings.Fields(element)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 1 && (len(fields) != 3 || fields[1] != "via") {
+			return nil, fmt.Errorf("invalid route '%s' : '<cidr>' or '<cidr> via <next hop>' expected", strings.TrimSpace(element))
+		}
+		_, destination, err := net.ParseCIDR(fields[0])
+		if err != nil || destination.IP.To4() == nil {
+			return nil, fmt.Errorf("invalid route destination '%s' : an ipv4 CIDR is expected", fields[0])
+		}
+		route := Route{Destination: destination}
+		if len(fields) == 3 {
+			route.NextHop = net.ParseIP(fields[2]).To4()
+			if route.NextHop == nil {
+				return nil, fmt.Errorf("invalid route next hop '%s' : an ipv4 address is expected", fields[2])
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+//validateRoutes checks that the routes next hops belong to the network subnets, the containers couldn't reach them otherwise
+func (networkContext *Context) validateRoutes() error {
+	for _, route := range networkContext.Routes {
+		if route.NextHop == nil {
+			continue
+		}
+		reachable := false
+		for _, subnet := range networkContext.Subnets {
+			reachable = reachable || subnet.Pool.Contains(route.NextHop)
+		}
+		if !reachable {
+			return fmt.Errorf("the next hop of route '%s' doesn't belong to the network subnets", route)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := parseRoutes("10.8.0.0/16 via 172.30.0.5, 192.168.50.0/24")
+	assert.Nil(t, err)
+	assert.Len(t, routes, 2)
+	assert.Equal(t, "10.8.0.0/16 via 172.30.0.5", routes[0].String())
+	assert.Nil(t, routes[1].NextHop)
+	assert.Equal(t, "192.168.50.0/24", routes[1].String())
+
+	invalid := []string{"10.8.0.0", "10.8.0.0/16 through 172.30.0.5", "10.8.0.0/16 via gateway", "fd00::/64"}
+	for _, value := range invalid {
+		_, err = parseRoutes(value)
+		assert.NotNil(t, err, "routes %q should be rejected", value)
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	_, pool, _ := net.ParseCIDR("172.30.0.0/24")
+	routes, _ := parseRoutes("10.8.0.0/16 via 172.30.0.5,192.168.50.0/24")
+	networkContext := &Context{Subnets: []Subnet{{Pool: pool}}, Routes: routes}
+	assert.Nil(t, networkContext.validateRoutes())
+
+	networkContext.Routes, _ = parseRoutes("10.8.0.0/16 via 172.31.0.5")
+	assert.NotNil(t, networkContext.validateRoutes())
+}
+
+func TestRoutedDestinationsArentTunneled(t *testing.T) {
+	routes, _ := parseRoutes("10.8.0.0/16 via 172.30.0.5")
+	networkContext := &Context{BridgeName: "br-test", TunnelPort: 12345, DNSMode: DirectDNS, Routes: routes}
+	assert.Contains(t, networkContext.rules()[2].String(), "-i br-test -d 10.8.0.0/16 -j RETURN")
+}
@@ -0,0 +1,141 @@
+package network
+
+import (
+	"fmt"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/tunnel"
+	"net"
+	"sync"
+)
+
+//sharedTunnel the transparent proxy owned by the driver, nil unless enabled
+var sharedTunnel *tunnel.Shared
+
+//SetSharedTunnel sets the transparent proxy shared by the networks : when set, the networks that don't pick a tunnel
+//backend are tunneled through it rather than through their own process
+func SetSharedTunnel(shared *tunnel.Shared) {
+	sharedTunnel = shared
+}
+
+//sharedBackend a network tunnel backend relying on the shared tunnel : while started, the connections coming from the
+//network subnets are tunneled through the network upstream
+type sharedBackend struct {
+	shared         *tunnel.Shared
+	networkContext *Context
+	configuration  *tunnel.Configuration
+	//sources the routed source networks, while started
+	sources []*net.IPNet
+	//gateways the gateway addresses the shared tunnel listens on for the network, while started
+	gateways []net.IP
+	//profile whether the backend tunnels a profile : only the addresses relying on it are routed, see routeAddress
+	profile bool
+	started bool
+	sync.Mutex
+}
+
+func newSharedBackend(networkContext *Context, params map[string]string) (TunnelBackend, error) {
+	if sharedTunnel == nil {
+		return nil, fmt.Errorf("the '%s' tunnel backend requires the driver shared tunnel to be enabled", SharedBackend)
+	}
+	for _, key := range []string{tunnelPort, tunnelBindAddress} {
+		if _, ok := params[key]; ok {
+			return nil, fmt.Errorf("param '%s' isn't supported by the '%s' tunnel backend", key, SharedBackend)
+		}
+	}
+	//the traffic of every network is redirected to the shared tunnel port
+	networkContext.TunnelPort = sharedTunnel.Port
+	return &sharedBackend{shared: sharedTunnel, networkContext: networkContext}, nil
+}
+
+//Configure checks the shared tunnel supports the network proxy type
+func (b *sharedBackend) Configure(configuration *tunnel.Configuration) error {
+	if err := tunnel.ValidateSharedConfiguration(configuration); err != nil {
+		return err
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.configuration = configuration
+	return nil
+}
+
+//Start routes the connections of the network subnets through the network upstream, the shared tunnel listening on
+//the network gateways where their traffic is redirected
+func (b *sharedBackend) Start() error {
+	b.Lock()
+	defer b.Unlock()
+	if b.started {
+		return nil
+	}
+	for _, subnet := range b.networkContext.Subnets {
+		if subnet.Gateway == nil {
+			continue
+		}
+		if err := b.shared.Listen(subnet.Gateway.IP); err != nil {
+			b.stop()
+			return err
+		}
+		b.gateways = append(b.gateways, subnet.Gateway.IP)
+	}
+	for _, subnet := range b.networkContext.Subnets {
+		if !b.profile {
+			b.shared.Route(subnet.Pool, b.configuration)
+			b.sources = append(b.sources, subnet.Pool)
+		}
+	}
+	b.started = true
+	return nil
+}
+
+//Stop removes the routes of the network subnets, their new connections are closed
+func (b *sharedBackend) Stop() error {
+	b.Lock()
+	defer b.Unlock()
+	b.stop()
+	return nil
+}
+
+//stop removes the network routes and gateways, the caller must hold the lock
+func (b *sharedBackend) stop() {
+	for _, source := range b.sources {
+		b.shared.Unroute(source)
+	}
+	for _, gateway := range b.gateways {
+		b.shared.Unlisten(gateway)
+	}
+	b.sources, b.gateways = nil, nil
+	b.started = false
+}
+
+//Health returns the shared tunnel state, stopped while the network isn't routed
+func (b *sharedBackend) Health() supervisor.Status {
+	status := b.shared.Status()
+	b.Lock()
+	defer b.Unlock()
+	if !b.started {
+		return supervisor.Status{Name: status.Name, State: supervisor.Stopped}
+	}
+	return status
+}
+
+//OnFailure is a no-op : the shared tunnel runs within the driver, there is no process to give up on
+func (b *sharedBackend) OnFailure(callback func(supervisor.Status)) {}
+
+//reportConnections hands the network connections to a given callback once closed
+func (b *sharedBackend) reportConnections(callback func(connection tunnel.Connection)) {
+	b.shared.Observe(b.networkContext.ID, callback)
+}
+
+//stopReportingConnections stops handing the network connections
+func (b *sharedBackend) stopReportingConnections() {
+	b.shared.Unobserve(b.networkContext.ID)
+}
+
+//routeAddress routes the connections of a given address through the backend upstream rather than the one of its network
+func (b *sharedBackend) routeAddress(address net.IP) {
+	b.shared.Route(&net.IPNet{IP: address, Mask: net.CIDRMask(32, 32)}, b.configuration)
+}
+
+//unrouteAddress removes the route of a given address, its connections are tunneled through its network upstream again
+func (b *sharedBackend) unrouteAddress(address net.IP) {
+	b.shared.Unroute(&net.IPNet{IP: address, Mask: net.CIDRMask(32, 32)})
+}
@@ -0,0 +1,37 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/tunnel"
+	"net"
+	"testing"
+)
+
+func TestSharedBackend(t *testing.T) {
+	_, err := newSharedBackend(&Context{}, map[string]string{})
+	assert.NotNil(t, err)
+
+	SetSharedTunnel(tunnel.NewShared("", 12345))
+	defer SetSharedTunnel(nil)
+	_, pool, _ := net.ParseCIDR("172.30.0.0/16")
+	networkContext := &Context{ID: "4f1e0d6a5b2c", Subnets: []Subnet{{Pool: pool}}}
+	_, err = newSharedBackend(networkContext, map[string]string{tunnelPort: "2000"})
+	assert.NotNil(t, err)
+
+	backend, err := newSharedBackend(networkContext, map[string]string{})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12345), networkContext.TunnelPort)
+	assert.NotNil(t, backend.Configure(&tunnel.Configuration{ProxyType: "http-relay"}))
+	assert.Nil(t, backend.Configure(&tunnel.Configuration{NetworkID: networkContext.ID}))
+
+	assert.Nil(t, backend.Start())
+	assert.Equal(t, []string{"172.30.0.0/16"}, sharedTunnel.Routes())
+	backend.(*sharedBackend).routeAddress(net.ParseIP("172.30.0.5"))
+	assert.Len(t, sharedTunnel.Routes(), 2)
+	backend.(*sharedBackend).unrouteAddress(net.ParseIP("172.30.0.5"))
+
+	assert.Nil(t, backend.Stop())
+	assert.Empty(t, sharedTunnel.Routes())
+	assert.Equal(t, supervisor.Stopped, backend.Health().State)
+}
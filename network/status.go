@@ -0,0 +1,123 @@
+package network
+
+import (
+	"github.com/docker/libnetwork/iptables"
+	"sort"
+	"time"
+)
+
+//Upstream the proxy a network traffic is tunneled through
+type Upstream struct {
+	Address string `json:"address"`
+	Port    int64  `json:"port"`
+	Type    string `json:"type,omitempty"`
+	User    string `json:"user,omitempty"`
+	//Upstreams the proxies the tunnel picks the first reachable one of whenever it starts, if any
+	Upstreams []string `json:"upstreams,omitempty"`
+	//Fallback whether the proxy is the embedded tor instance
+	Fallback bool `json:"fallback"`
+}
+
+//RuleStatus whether a network iptables rule is currently programmed
+type RuleStatus struct {
+	Rule    string `json:"rule"`
+	Present bool   `json:"present"`
+}
+
+//EndpointStatus an endpoint of a network
+type EndpointStatus struct {
+	ID      string `json:"id"`
+	Address string `json:"address"`
+	//Container the container the endpoint is attached to, once known
+	Container *Container `json:"container,omitempty"`
+	//Policy the policy applied to the endpoint traffic, if its container labels set one
+	Policy *Policy `json:"policy,omitempty"`
+}
+
+//Status a snapshot of a network state
+type Status struct {
+	ID         string           `json:"id"`
+	Bridge     string           `json:"bridge"`
+	Mode       Pause            `json:"mode"`
+	Subnets    []string         `json:"subnets"`
+	Upstream   Upstream         `json:"upstream"`
+	Backend    string           `json:"backend"`
+	TunnelPort int64            `json:"tunnelPort"`
+	DNSPort    int64            `json:"dnsPort"`
+	DNSMode    string           `json:"dnsMode"`
+	BlockUDP   bool             `json:"blockUDP"`
+	Bypass     []string         `json:"bypass"`
+	Routes     []string         `json:"routes"`
+	Health     Health           `json:"health"`
+	Uptime     string           `json:"uptime"`
+	Rules      []RuleStatus     `json:"rules"`
+	Endpoints  []EndpointStatus `json:"endpoints"`
+}
+
+//upstream returns the proxy the network traffic is tunneled through
+func (networkContext *Context) upstream() Upstream {
+	return Upstream{
+		Address:   networkContext.ProxyAddress,
+		Port:      networkContext.ProxyPort,
+		Type:      networkContext.ProxyType,
+		User:      networkContext.ProxyUser,
+		Upstreams: networkContext.ProxyUpstreams,
+		Fallback:  networkContext.FallbackProxy,
+	}
+}
+
+//Status returns a snapshot of the network state, checking whether each of its iptables rules is programmed
+func (networkContext *Context) Status() Status {
+	status := networkContext.Snapshot()
+	for _, r := range networkContext.rules() {
+		status.Rules = append(status.Rules, RuleStatus{Rule: r.String(), Present: iptables.Exists(r.table, r.chain, r.taggedArgs()...)})
+	}
+	return status
+}
+
+//Snapshot returns a snapshot of the network state without checking its iptables rules
+func (networkContext *Context) Snapshot() Status {
+	health := networkContext.Health()
+	status := Status{
+		ID:         networkContext.ID,
+		Bridge:     networkContext.BridgeName,
+		Mode:       networkContext.Mode(),
+		Upstream:   networkContext.upstream(),
+		Backend:    networkContext.Backend,
+		TunnelPort: networkContext.TunnelPort,
+		DNSPort:    networkContext.TunnelDNSPort,
+		DNSMode:    networkContext.DNSMode,
+		BlockUDP:   networkContext.BlockUDP,
+		Health:     health,
+		Uptime:     health.Tunnel.Uptime().Round(time.Second).String(),
+		Subnets:    []string{},
+		Bypass:     []string{},
+		Routes:     []string{},
+		Rules:      []RuleStatus{},
+		Endpoints:  []EndpointStatus{},
+	}
+	for _, subnet := range networkContext.Subnets {
+		status.Subnets = append(status.Subnets, subnet.Pool.String())
+	}
+	for _, destination := range networkContext.Bypass {
+		status.Bypass = append(status.Bypass, destination.String())
+	}
+	for _, route := range networkContext.Routes {
+		status.Routes = append(status.Routes, route.String())
+	}
+	networkContext.lock.Lock()
+	for endpointID, address := range networkContext.endpoints {
+		endpoint := EndpointStatus{ID: endpointID, Address: address.String()}
+		if container, ok := networkContext.containers[endpointID]; ok {
+			endpoint.Container = &container
+		}
+		if applied, ok := networkContext.policies[endpointID]; ok && !applied.IsZero() {
+			policy := applied.Policy
+			endpoint.Policy = &policy
+		}
+		status.Endpoints = append(status.Endpoints, endpoint)
+	}
+	networkContext.lock.Unlock()
+	sort.Slice(status.Endpoints, func(i, j int) bool { return status.Endpoints[i].ID < status.Endpoints[j].ID })
+	return status
+}
@@ -0,0 +1,85 @@
+package network
+
+import (
+	"fmt"
+	"github.com/docker/libnetwork/iptables"
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+)
+
+//Subnet an IPv4 pool of a network and the gateway of its endpoints
+type Subnet struct {
+	Pool    *net.IPNet
+	Gateway *net.IPNet
+}
+
+//secondarySubnets the subnets the bridge driver doesn't handle : it only supports one pool per network, the driver
+//assigns the other pools gateways to the bridge and masquerades their traffic
+func (networkContext *Context) secondarySubnets() []Subnet {
+	if len(networkContext.Subnets) < 2 {
+		return nil
+	}
+	return networkContext.Subnets[1:]
+}
+
+//subnetRules the rules letting the secondary subnets traffic that isn't tunneled (e.g. UDP) reach the outside
+func (networkContext *Context) subnetRules() []rule {
+	var rules []rule
+	for _, subnet := range networkContext.secondarySubnets() {
+		rules = append(rules, rule{iptables.Nat, "POSTROUTING", false,
+			[]string{"-s", subnet.Pool.String(), "!", "-o", networkContext.BridgeName, "-j", "MASQUERADE"}})
+	}
+	return rules
+}
+
+//addGateways assigns the secondary subnets gateways to the network bridge, the ones already assigned are removed if any of them fails
+func (networkContext *Context) addGateways() error {
+	subnets := networkContext.secondarySubnets()
+	if len(subnets) == 0 {
+		return nil
+	}
+	link, err := netlink.LinkByName(networkContext.BridgeName)
+	if err != nil {
+		return fmt.Errorf("couldn't find the bridge '%s' : %v", networkContext.BridgeName, err)
+	}
+	undo := &utils.Undo{}
+	for _, subnet := range subnets {
+		address := &netlink.Addr{IPNet: subnet.Gateway}
+		if err = netlink.AddrAdd(link, address); err != nil {
+			undo.Rollback()
+			return fmt.Errorf("couldn't assign the gateway '%s' to the bridge '%s' : %v", subnet.Gateway, networkContext.BridgeName, err)
+		}
+		undo.Push("gateway "+subnet.Gateway.String(), func() error { return netlink.AddrDel(link, address) })
+	}
+	return nil
+}
+
+//deleteGateways removes the secondary subnets gateways from the network bridge
+func (networkContext *Context) deleteGateways() {
+	subnets := networkContext.secondarySubnets()
+	if len(subnets) == 0 {
+		return
+	}
+	link, err := netlink.LinkByName(networkContext.BridgeName)
+	if err != nil {
+		logrus.Debugf("bridge '%s' already removed : %v", networkContext.BridgeName, err)
+		return
+	}
+	for _, subnet := range subnets {
+		if err = netlink.AddrDel(link, &netlink.Addr{IPNet: subnet.Gateway}); err != nil {
+			logrus.Errorf("couldn't remove the gateway '%s' from the bridge '%s' : %v", subnet.Gateway, networkContext.BridgeName, err)
+		}
+	}
+}
+
+//GatewayOf returns the gateway of the subnet a given endpoint address belongs to, nil if it's the primary subnet or none
+func (networkContext *Context) GatewayOf(address net.IP) net.IP {
+	for _, subnet := range networkContext.secondarySubnets() {
+		if subnet.Pool.Contains(address) {
+			return subnet.Gateway.IP
+		}
+	}
+	return nil
+}
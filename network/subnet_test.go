@@ -0,0 +1,27 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestSecondarySubnets(t *testing.T) {
+	networkContext := &Context{
+		BridgeName: "br-test",
+		Subnets:    []Subnet{fixtureSubnet("172.21.1.1/24"), fixtureSubnet("172.21.2.1/24")},
+	}
+	assert.Nil(t, networkContext.GatewayOf(net.ParseIP("172.21.1.10")))
+	assert.Equal(t, "172.21.2.1", networkContext.GatewayOf(net.ParseIP("172.21.2.10")).String())
+	assert.Nil(t, networkContext.GatewayOf(nil))
+
+	rules := networkContext.subnetRules()
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, "-t nat POSTROUTING -s 172.21.2.0/24 ! -o br-test -j MASQUERADE", rules[0].String())
+	}
+}
+
+func fixtureSubnet(gateway string) Subnet {
+	ip, pool, _ := net.ParseCIDR(gateway)
+	return Subnet{Pool: pool, Gateway: &net.IPNet{IP: ip, Mask: pool.Mask}}
+}
@@ -0,0 +1,61 @@
+package network
+
+import (
+	"fmt"
+	"github.com/yassine/soxy-driver/redsocks"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/tunnel"
+)
+
+const (
+	//RedsocksBackend the default tunnel backend
+	RedsocksBackend = "redsocks"
+	//ExecBackend a tunnel backend running an arbitrary transparent proxy binary
+	ExecBackend = "exec"
+	//SharedBackend a tunnel backend relying on the transparent proxy shared by the networks, see shared.go
+	SharedBackend = "shared"
+)
+
+//TunnelBackend a transparent proxy implementation the network TCP traffic is redirected to
+type TunnelBackend interface {
+	//Configure validates and sets the configuration the backend would be started with
+	Configure(configuration *tunnel.Configuration) error
+	//Start starts the backend process
+	Start() error
+	//Stop stops the backend process
+	Stop() error
+	//Health returns the backend process state
+	Health() supervisor.Status
+	//OnFailure registers a callback invoked when the backend process is given up on
+	OnFailure(callback func(supervisor.Status))
+}
+
+func newTunnelBackend(networkContext *Context, params map[string]string) (TunnelBackend, error) {
+	switch networkContext.Backend {
+	case RedsocksBackend:
+		return redsocks.New(), nil
+	case ExecBackend:
+		binary := params[tunnelBinary]
+		if binary == "" {
+			return nil, fmt.Errorf("param '%s' is mandatory when using the '%s' tunnel backend", tunnelBinary, ExecBackend)
+		}
+		return tunnel.NewExec(binary, params[tunnelArgs], params[tunnelTemplate]), nil
+	case SharedBackend:
+		return newSharedBackend(networkContext, params)
+	}
+	return nil, fmt.Errorf("unknown tunnel backend '%s'", networkContext.Backend)
+}
+
+func buildTunnelConfig(networkContext *Context, params map[string]string) *tunnel.Configuration {
+	return &tunnel.Configuration{
+		NetworkID:         networkContext.ID,
+		ProxyAddress:      networkContext.ProxyAddress,
+		ProxyPassword:     networkContext.ProxyPassword,
+		ProxyPort:         networkContext.ProxyPort,
+		ProxyType:         networkContext.ProxyType,
+		ProxyUser:         networkContext.ProxyUser,
+		TunnelBindAddress: networkContext.TunnelBindAddress,
+		TunnelPort:        networkContext.TunnelPort,
+		Options:           params,
+	}
+}
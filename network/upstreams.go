@@ -0,0 +1,75 @@
+package network
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	proxyUpstreams = "soxy.proxyupstreams"
+	//upstreamDialTimeout the delay after which an upstream is considered unreachable when the tunnel starts
+	upstreamDialTimeout = 2 * time.Second
+)
+
+//parseUpstreams parses a comma separated list of proxies (host:port), in order of preference
+func parseUpstreams(value string) ([]string, error) {
+	var upstreams []string
+	for _, upstream := range strings.Split(value, ",") {
+		upstream = strings.TrimSpace(upstream)
+		if upstream == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(upstream)
+		if err != nil || !utils.IsHost(host) {
+			return nil, fmt.Errorf("invalid upstream '%s', expected host:port", upstream)
+		}
+		if parsed, err := strconv.ParseInt(port, 10, 64); err != nil || parsed < 1 || parsed > 65535 {
+			return nil, fmt.Errorf("invalid upstream '%s', expected a port between 1 and 65535", upstream)
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("expected at least one upstream")
+	}
+	return upstreams, nil
+}
+
+//selectUpstream returns the first of the given upstreams accepting connections, the first one if none does
+func selectUpstream(upstreams []string) (string, int64) {
+	selected := upstreams[0]
+	for _, upstream := range upstreams {
+		connection, err := net.DialTimeout("tcp", upstream, upstreamDialTimeout)
+		if err == nil {
+			connection.Close()
+			selected = upstream
+			break
+		}
+		logrus.Warnf("upstream '%s' is unreachable : %v", upstream, err)
+	}
+	host, port, _ := net.SplitHostPort(selected)
+	parsed, _ := strconv.ParseInt(port, 10, 64)
+	return host, parsed
+}
+
+//startTunnel starts the network tunnel. When the network has several upstreams, the stopped tunnel is first configured
+//with the first reachable one : the upstream is picked whenever the tunnel starts rather than when the network options
+//are rendered, so that they don't depend on the upstreams reachability
+func (networkContext *Context) startTunnel() error {
+	state := networkContext.tunnel.Health().State
+	if len(networkContext.ProxyUpstreams) > 1 && (state == supervisor.Stopped || state == supervisor.Failed) {
+		configuration := buildTunnelConfig(networkContext, networkContext.params)
+		configuration.ProxyAddress, configuration.ProxyPort = selectUpstream(networkContext.ProxyUpstreams)
+		logrus.Infof("network '%s' tunneled through upstream '%s'", networkContext.ID,
+			net.JoinHostPort(configuration.ProxyAddress, strconv.FormatInt(configuration.ProxyPort, 10)))
+		if err := networkContext.tunnel.Configure(configuration); err != nil {
+			return err
+		}
+	}
+	return networkContext.tunnel.Start()
+}
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/supervisor"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestParseUpstreams(t *testing.T) {
+	upstreams, err := parseUpstreams(" proxy1.corp:3128, 10.0.0.1:1080 ,")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"proxy1.corp:3128", "10.0.0.1:1080"}, upstreams)
+	for _, invalid := range []string{"", "proxy1.corp", "proxy1.corp:0", "proxy1.corp:http", "bad_host!:1080"} {
+		_, err = parseUpstreams(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+}
+
+func TestStartTunnelPicksTheFirstReachableUpstream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	reachable := listener.Addr().(*net.TCPAddr)
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	backend := &fakeBackend{state: supervisor.Stopped}
+	networkContext := &Context{ID: "network", params: map[string]string{}, tunnel: backend}
+	assert.Nil(t, parseNetworkConfiguration(networkContext, map[string]string{
+		proxyUpstreams: unreachable + "," + reachable.String(),
+		tunnelBackend:  RedsocksBackend,
+	}, 9050))
+	assert.Equal(t, "127.0.0.1", networkContext.ProxyAddress)
+	assert.False(t, networkContext.FallbackProxy)
+
+	assert.Nil(t, networkContext.startTunnel())
+	assert.Equal(t, "127.0.0.1", backend.configuration.ProxyAddress)
+	assert.Equal(t, strconv.Itoa(reachable.Port), strconv.FormatInt(backend.configuration.ProxyPort, 10))
+
+	//a running tunnel keeps its upstream
+	backend.configuration = nil
+	assert.Nil(t, networkContext.startTunnel())
+	assert.Nil(t, backend.configuration)
+}
@@ -2,15 +2,20 @@ package network
 
 import (
 	"github.com/yassine/soxy-driver/utils"
-	"os"
 	"strings"
 )
 
+//SetNamespace sets the driver namespace and updates the soxy chain name accordingly
+func SetNamespace(namespace string) {
+	utils.SetNamespace(namespace)
+	IptablesSoxyChain = iptablesSoxyChainName()
+}
+
 func iptablesSoxyChainName() string {
-	if len(os.Getenv("DRIVER_NAMESPACE")) == 0 {
+	if len(utils.Namespace()) == 0 {
 		return defaultChainName
 	}
-	parts := []string{strings.TrimSpace(os.Getenv("DRIVER_NAMESPACE")), defaultChainName}
+	parts := []string{utils.Namespace(), defaultChainName}
 	preResult := utils.GetMD5Hash(strings.Join(parts, "__"))[0:15]
 	parts = []string{preResult, defaultChainName}
 	return strings.Join(parts, "__")
@@ -0,0 +1,208 @@
+package redsocks
+
+import (
+	"fmt"
+	"github.com/yassine/soxy-driver/tunnel"
+	"github.com/yassine/soxy-driver/utils"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	//OnProxyFailOption what redsocks does with the client connection when the proxy is unreachable
+	OnProxyFailOption = "soxy.redsocksOnProxyFail"
+	//DiscloseSrcOption how the client address is disclosed to http-connect proxies
+	DiscloseSrcOption = "soxy.redsocksDiscloseSrc"
+	//ListenqOption the tunnel listening socket backlog
+	ListenqOption = "soxy.redsocksListenq"
+	//MinAcceptBackoffOption the minimal delay (ms) before accepting again once the file descriptors are exhausted
+	MinAcceptBackoffOption = "soxy.redsocksMinAcceptBackoff"
+	//MaxAcceptBackoffOption the maximal delay (ms) before accepting again once the file descriptors are exhausted
+	MaxAcceptBackoffOption = "soxy.redsocksMaxAcceptBackoff"
+	//IdleTimeoutOption the delay (s) after which idle half-closed connections are dropped
+	IdleTimeoutOption = "soxy.redsocksIdleTimeout"
+	//KeepaliveTimeOption the tcp keepalive idle time (s) of tunneled connections
+	KeepaliveTimeOption = "soxy.redsocksKeepaliveTime"
+	//KeepaliveIntervalOption the tcp keepalive probes interval (s) of tunneled connections
+	KeepaliveIntervalOption = "soxy.redsocksKeepaliveInterval"
+	//KeepaliveProbesOption the tcp keepalive probes count of tunneled connections
+	KeepaliveProbesOption = "soxy.redsocksKeepaliveProbes"
+	//SpliceOption whether redsocks relays data using splice(2)
+	SpliceOption = "soxy.redsocksSplice"
+	//DebugOption whether redsocks debug logging is enabled
+	DebugOption = "soxy.redsocksDebug"
+)
+
+var (
+	//ProxyTypes the proxy types supported by redsocks
+	ProxyTypes = []string{"socks4", "socks5", "http-connect", "http-relay"}
+	//OnProxyFailValues the values accepted by the 'soxy.redsocksOnProxyFail' option
+	OnProxyFailValues = []string{"close", "forward_http_err"}
+	//DiscloseSrcValues the values accepted by the 'soxy.redsocksDiscloseSrc' option
+	DiscloseSrcValues = []string{"false", "X-Forwarded-For", "Forwarded_ip", "Forwarded_ipport"}
+	//IntegerOptions the integer tuning options and their accepted ranges
+	IntegerOptions = []IntegerBounds{
+		{ListenqOption, 1, 65535},
+		{MinAcceptBackoffOption, 1, 3600000},
+		{MaxAcceptBackoffOption, 1, 3600000},
+		{IdleTimeoutOption, 1, 86400},
+		{KeepaliveTimeOption, 1, 86400},
+		{KeepaliveIntervalOption, 1, 86400},
+		{KeepaliveProbesOption, 1, 100},
+	}
+)
+
+//IntegerBounds the accepted range of an integer tuning option
+type IntegerBounds struct {
+	Key      string
+	Min, Max int64
+}
+
+//Tuning advanced redsocks settings, zero values are left to redsocks defaults
+type Tuning struct {
+	OnProxyFail       string
+	DiscloseSrc       string
+	Listenq           int64
+	MinAcceptBackoff  int64
+	MaxAcceptBackoff  int64
+	IdleTimeout       int64
+	KeepaliveTime     int64
+	KeepaliveInterval int64
+	KeepaliveProbes   int64
+	//Splice 'on', 'off' or empty if unset
+	Splice string
+	Debug  bool
+}
+
+//ParseTuning parses and validates the redsocks tuning network options
+func ParseTuning(options map[string]string) (*Tuning, error) {
+	tuning := &Tuning{}
+	var err error
+
+	if val, ok := options[OnProxyFailOption]; ok {
+		if tuning.OnProxyFail, err = parseChoice(OnProxyFailOption, val, OnProxyFailValues); err != nil {
+			return nil, err
+		}
+	}
+	if val, ok := options[DiscloseSrcOption]; ok {
+		if tuning.DiscloseSrc, err = parseChoice(DiscloseSrcOption, val, DiscloseSrcValues); err != nil {
+			return nil, err
+		}
+	}
+
+	integers := map[string]*int64{
+		ListenqOption:           &tuning.Listenq,
+		MinAcceptBackoffOption:  &tuning.MinAcceptBackoff,
+		MaxAcceptBackoffOption:  &tuning.MaxAcceptBackoff,
+		IdleTimeoutOption:       &tuning.IdleTimeout,
+		KeepaliveTimeOption:     &tuning.KeepaliveTime,
+		KeepaliveIntervalOption: &tuning.KeepaliveInterval,
+		KeepaliveProbesOption:   &tuning.KeepaliveProbes,
+	}
+	for _, bounds := range IntegerOptions {
+		if val, ok := options[bounds.Key]; ok {
+			if *integers[bounds.Key], err = parseRange(bounds.Key, val, bounds.Min, bounds.Max); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if tuning.MinAcceptBackoff != 0 && tuning.MaxAcceptBackoff != 0 && tuning.MinAcceptBackoff > tuning.MaxAcceptBackoff {
+		return nil, fmt.Errorf("param '%s' (%d) can't be greater than param '%s' (%d)",
+			MinAcceptBackoffOption, tuning.MinAcceptBackoff, MaxAcceptBackoffOption, tuning.MaxAcceptBackoff)
+	}
+
+	if val, ok := options[SpliceOption]; ok {
+		splice, err := parseBool(SpliceOption, val)
+		if err != nil {
+			return nil, err
+		}
+		tuning.Splice = onOff(splice)
+	}
+	if val, ok := options[DebugOption]; ok {
+		if tuning.Debug, err = parseBool(DebugOption, val); err != nil {
+			return nil, err
+		}
+	}
+	return tuning, nil
+}
+
+func parseChoice(key string, value string, choices []string) (string, error) {
+	for _, choice := range choices {
+		if strings.EqualFold(strings.TrimSpace(value), choice) {
+			return choice, nil
+		}
+	}
+	return "", fmt.Errorf("param '%s' has invalid value '%s' (available choices : %s)", key, value, strings.Join(choices, ", "))
+}
+
+func parseRange(key string, value string, min int64, max int64) (int64, error) {
+	parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || parsed < min || parsed > max {
+		return 0, fmt.Errorf("param '%s' has invalid value '%s' (expected an integer between %d and %d)", key, value, min, max)
+	}
+	return parsed, nil
+}
+
+func parseBool(key string, value string) (bool, error) {
+	parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+	if err != nil {
+		return false, fmt.Errorf("param '%s' has invalid boolean value '%s'", key, value)
+	}
+	return parsed, nil
+}
+
+func onOff(value bool) string {
+	if value {
+		return "on"
+	}
+	return "off"
+}
+
+//validateConfiguration rejects the configuration values that can't be represented in a redsocks configuration file, and normalizes the proxy type
+func validateConfiguration(configuration *tunnel.Configuration) error {
+	if !utils.IsHost(configuration.ProxyAddress) {
+		return fmt.Errorf("invalid proxy address '%s' : expected an ip address or a hostname", configuration.ProxyAddress)
+	}
+	if configuration.ProxyPort < 1 || configuration.ProxyPort > 65535 {
+		return fmt.Errorf("invalid proxy port '%d'", configuration.ProxyPort)
+	}
+	if configuration.TunnelPort < 1 || configuration.TunnelPort > 65535 {
+		return fmt.Errorf("invalid tunnel port '%d'", configuration.TunnelPort)
+	}
+	if configuration.TunnelBindAddress != "" && net.ParseIP(configuration.TunnelBindAddress).To4() == nil {
+		return fmt.Errorf("invalid tunnel bind address '%s' : expected an ipv4 address", configuration.TunnelBindAddress)
+	}
+	if configuration.ProxyType != "" {
+		proxyType, err := parseChoice("proxy type", configuration.ProxyType, ProxyTypes)
+		if err != nil {
+			return fmt.Errorf("invalid proxy type '%s' (available choices : %s)", configuration.ProxyType, strings.Join(ProxyTypes, ", "))
+		}
+		configuration.ProxyType = proxyType
+	}
+	if !representable(configuration.ProxyUser) {
+		return fmt.Errorf("invalid proxy user : control characters (e.g. new lines) aren't supported")
+	}
+	if !representable(configuration.ProxyPassword) {
+		return fmt.Errorf("invalid proxy password : control characters (e.g. new lines) aren't supported")
+	}
+	return nil
+}
+
+func representable(value string) bool {
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+//escape renders a value as a redsocks configuration quoted string
+func escape(value string) (string, error) {
+	if !representable(value) {
+		return "", fmt.Errorf("a configuration value contains control characters")
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + replacer.Replace(value) + `"`, nil
+}
@@ -2,8 +2,9 @@ package redsocks
 
 import (
 	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/tunnel"
 	"github.com/yassine/soxy-driver/utils"
-	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
@@ -13,100 +14,110 @@ import (
 
 //Context A base structure representing a Redsocks execution context
 type Context struct {
-	Command    *exec.Cmd
 	Configfile *os.File
-	isRunning  bool
-	*Configuration
+	process    *supervisor.Process
+	*tunnel.Configuration
+	//Tuning the advanced redsocks settings
+	Tuning *Tuning
 	sync.Mutex
 }
 
-//Configuration redsocks configuration params
-type Configuration struct {
-	//BindPort the port on which redsocks would listen to tunnel traffic
-	BindPort int64
-	//BindAddress the address on which redsocks would listen to tunnel traffic
-	BindAddress  string
-	ProxyAddress string
-	//ProxyPort the proxy port
-	ProxyPort int64
-	//ProxyType the proxy type. Available options : as per redsocks support
-	ProxyType string
-	//ProxyUser the proxy user (if authentication applies)
-	ProxyUser string
-	//ProxyPassword the proxy password (if authentication applies)
-	ProxyPassword string
-	//TunnelPort the port through which traffic is tunneled
-	TunnelPort int64
-	//TunnelBindAddress the tunnel bind address
-	TunnelBindAddress string
+//New Creates a Redsocks context, it has to be configured before being started
+func New() *Context {
+	redsocks := &Context{}
+	redsocks.process = supervisor.New("redsocks", redsocks.command, supervisor.DefaultPolicy)
+	return redsocks
 }
 
-//Startup Start redsocks with the given configuration
-func (r *Context) Startup() error {
-	err := r.startup()
-	return err
-}
-
-//Shutdown Stops redsocks with the given configuration
-func (r *Context) Shutdown() error {
-	err := r.shutdown()
-	if err != nil {
-		logrus.Error(err.Error())
+//Configure validates and sets the configuration redsocks would be started with
+func (r *Context) Configure(configuration *tunnel.Configuration) error {
+	if err := validateConfiguration(configuration); err != nil {
+		return err
 	}
-	return err
-}
-
-//NewContext New Creates and initialize a Redsocks context
-func NewContext(configuration *Configuration) (*Context, error) {
-	redsocks := &Context{
-		isRunning:     false,
-		Configuration: configuration,
+	tuning, err := ParseTuning(configuration.Options)
+	if err != nil {
+		return err
 	}
-	configFile := tempFileConfig(configuration)
-	command := exec.Command("redsocks", "-c", configFile.Name())
-	command.Stdin = os.Stdin
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
-	redsocks.Command = command
-	redsocks.Configfile = configFile
-	return redsocks, nil
+	r.process.SetLogging(logrus.Fields{"network_id": configuration.NetworkID}, configuration.NetworkID)
+	r.Lock()
+	defer r.Unlock()
+	r.Configuration = configuration
+	r.Tuning = tuning
+	return nil
 }
 
-func (r *Context) startup() error {
+//Start renders the redsocks configuration file and starts redsocks
+func (r *Context) Start() error {
 	r.Lock()
 	defer r.Unlock()
-	if !r.isRunning {
-		r.isRunning = true
-		err := r.Command.Start()
-		utils.LogIfNotNull(err)
+	if r.Configfile == nil {
+		configFile, err := tempFileConfig(r)
+		if err != nil {
+			return err
+		}
+		r.Configfile = configFile
 	}
-	return nil
+	err := r.process.Start()
+	utils.LogIfNotNull(err)
+	return err
 }
 
-func (r *Context) shutdown() error {
+//Stop Stops redsocks and removes its configuration file
+func (r *Context) Stop() error {
 	//Kill the process
-	err := r.Command.Process.Kill()
+	err := r.process.Stop()
 	utils.LogIfNotNull(err)
+	r.Lock()
+	defer r.Unlock()
 	//Remove config file
-	err = os.Remove(r.Configfile.Name())
-	utils.LogIfNotNull(err)
+	if r.Configfile != nil {
+		err = os.Remove(r.Configfile.Name())
+		utils.LogIfNotNull(err)
+		r.Configfile = nil
+	}
 	return err
 }
 
-func (r *Context) wait() {
-	r.Command.Process.Wait()
+//Health returns the state of the supervised redsocks process
+func (r *Context) Health() supervisor.Status {
+	return r.process.Status()
+}
+
+//OnFailure registers a callback invoked when redsocks keeps crashing and is given up on
+func (r *Context) OnFailure(callback func(supervisor.Status)) {
+	r.process.OnFailure(callback)
 }
-func tempFileConfig(configuration *Configuration) *os.File {
+
+//OnOutput registers a callback redsocks log lines are handed to, on top of being logged
+func (r *Context) OnOutput(callback func(line string)) {
+	r.process.OnOutput(callback)
+}
+
+func (r *Context) command() *exec.Cmd {
+	command := exec.Command("redsocks", "-c", r.Configfile.Name())
+	command.Stdin = os.Stdin
+	return command
+}
+
+func tempFileConfig(r *Context) (*os.File, error) {
 	t := template.Must(template.New("configTemplate").Funcs(template.FuncMap{
 		"isSet":    isSet,
 		"isStrSet": isStrSet,
+		"escape":   escape,
+		"onOff":    onOff,
 	}).Parse(redSocksConfigurationTemplate))
-	tempFile, _ := ioutil.TempFile("/tmp", "redsocks")
-	err := t.Execute(tempFile, configuration)
+	tempFile, err := utils.CreateRuntimeFile("redsocks")
+	if err != nil {
+		return nil, err
+	}
+	defer tempFile.Close()
+	err = t.Execute(tempFile, r)
 	if err != nil {
 		logrus.Error(err)
+		os.Remove(tempFile.Name())
+		return nil, err
 	}
-	return tempFile
+	return tempFile, nil
 }
 
 func isSet(i net.IPAddr) bool {
@@ -118,22 +129,32 @@ func isStrSet(s string) bool {
 }
 
 const redSocksConfigurationTemplate = `  base {
-    log_debug = off;
+    log_debug = {{ onOff .Tuning.Debug }};
     log_info = on;
     log = stderr;
     daemon = off;
     redirector = iptables;
+    {{ with .Tuning.IdleTimeout }}connpres_idle_timeout = {{.}};{{ end }}
+    {{ with .Tuning.KeepaliveTime }}tcp_keepalive_time = {{.}};{{ end }}
+    {{ with .Tuning.KeepaliveInterval }}tcp_keepalive_intvl = {{.}};{{ end }}
+    {{ with .Tuning.KeepaliveProbes }}tcp_keepalive_probes = {{.}};{{ end }}
   }
   redsocks {
     {{ if ((isStrSet .TunnelBindAddress)) }}local_ip   = {{.TunnelBindAddress}};
     {{ else }}local_ip   = 0.0.0.0;{{ end }}
     local_port = {{.TunnelPort}};
-    ip         = {{.ProxyAddress}};
+    ip         = {{ escape .ProxyAddress }};
     port       = {{.ProxyPort}};
-    {{ if (isStrSet .ProxyType) }}type   = {{.ProxyType}};
+    {{ if (isStrSet .ProxyType) }}type   = {{ escape .ProxyType }};
     {{ else }}type   = socks5;{{ end }}
-    {{ if (isStrSet .ProxyUser) }}login   = {{.ProxyUser}};{{else}}//nothing{{ end }}
-    {{ if (isStrSet .ProxyPassword) }}password   = {{.ProxyPassword}};{{else}}//nothing{{ end }}
+    {{ if (isStrSet .ProxyUser) }}login   = {{ escape .ProxyUser }};{{else}}//nothing{{ end }}
+    {{ if (isStrSet .ProxyPassword) }}password   = {{ escape .ProxyPassword }};{{else}}//nothing{{ end }}
+    {{ with .Tuning.OnProxyFail }}on_proxy_fail = {{ escape . }};{{ end }}
+    {{ with .Tuning.DiscloseSrc }}disclose_src = {{ escape . }};{{ end }}
+    {{ with .Tuning.Listenq }}listenq = {{.}};{{ end }}
+    {{ with .Tuning.MinAcceptBackoff }}min_accept_backoff = {{.}};{{ end }}
+    {{ with .Tuning.MaxAcceptBackoff }}max_accept_backoff = {{.}};{{ end }}
+    {{ with .Tuning.Splice }}splice = {{.}};{{ end }}
   }
 
   `
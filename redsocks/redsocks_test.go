@@ -0,0 +1,110 @@
+package redsocks
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/tunnel"
+	"github.com/yassine/soxy-driver/utils"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	runtimeDir, _ := ioutil.TempDir("", "soxy-runtime")
+	os.Setenv(utils.RuntimeDirEnv, runtimeDir)
+	code := m.Run()
+	os.RemoveAll(runtimeDir)
+	os.Exit(code)
+}
+
+func TestParseTuning(t *testing.T) {
+	tuning, err := ParseTuning(map[string]string{
+		OnProxyFailOption:      "FORWARD_HTTP_ERR",
+		DiscloseSrcOption:      "x-forwarded-for",
+		ListenqOption:          "256",
+		MinAcceptBackoffOption: "10",
+		MaxAcceptBackoffOption: "1000",
+		SpliceOption:           "true",
+		DebugOption:            "1",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "forward_http_err", tuning.OnProxyFail)
+	assert.Equal(t, "X-Forwarded-For", tuning.DiscloseSrc)
+	assert.Equal(t, int64(256), tuning.Listenq)
+	assert.Equal(t, "on", tuning.Splice)
+	assert.True(t, tuning.Debug)
+}
+
+func TestParseTuningRejectsInvalidValues(t *testing.T) {
+	invalid := []map[string]string{
+		{OnProxyFailOption: "retry"},
+		{DiscloseSrcOption: "X-Real-IP"},
+		{ListenqOption: "0"},
+		{ListenqOption: "many"},
+		{IdleTimeoutOption: "-1"},
+		{SpliceOption: "sometimes"},
+		{MinAcceptBackoffOption: "500", MaxAcceptBackoffOption: "100"},
+	}
+	for _, options := range invalid {
+		_, err := ParseTuning(options)
+		assert.NotNil(t, err, "options %v should be rejected", options)
+	}
+}
+
+func TestConfigurationRendering(t *testing.T) {
+	redsocks := New()
+	err := redsocks.Configure(&tunnel.Configuration{
+		ProxyAddress: "proxy.local",
+		ProxyPort:    1080,
+		TunnelPort:   12345,
+		Options: map[string]string{
+			OnProxyFailOption: "close",
+			ListenqOption:     "64",
+		},
+	})
+	assert.Nil(t, err)
+	configFile, err := tempFileConfig(redsocks)
+	assert.Nil(t, err)
+	defer os.Remove(configFile.Name())
+	content, _ := ioutil.ReadFile(configFile.Name())
+	assert.Contains(t, string(content), "log_debug = off;")
+	assert.Contains(t, string(content), "local_port = 12345;")
+	assert.Contains(t, string(content), `on_proxy_fail = "close";`)
+	assert.Contains(t, string(content), "listenq = 64;")
+	assert.NotContains(t, string(content), "splice")
+}
+
+func TestConfigurationEscapesCredentials(t *testing.T) {
+	redsocks := New()
+	err := redsocks.Configure(&tunnel.Configuration{
+		ProxyAddress:  "10.0.0.1",
+		ProxyPort:     1080,
+		TunnelPort:    12345,
+		ProxyType:     "HTTP-CONNECT",
+		ProxyUser:     `us"er`,
+		ProxyPassword: `p;}\ass`,
+	})
+	assert.Nil(t, err)
+	configFile, err := tempFileConfig(redsocks)
+	assert.Nil(t, err)
+	defer os.Remove(configFile.Name())
+	info, _ := os.Stat(configFile.Name())
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, _ := ioutil.ReadFile(configFile.Name())
+	assert.Contains(t, string(content), `type   = "http-connect";`)
+	assert.Contains(t, string(content), `login   = "us\"er";`)
+	assert.Contains(t, string(content), `password   = "p;}\\ass";`)
+}
+
+func TestConfigureRejectsUnrepresentableValues(t *testing.T) {
+	invalid := []*tunnel.Configuration{
+		{ProxyAddress: "proxy;local", ProxyPort: 1080, TunnelPort: 1},
+		{ProxyAddress: "10.0.0.1", ProxyPort: 0, TunnelPort: 1},
+		{ProxyAddress: "10.0.0.1", ProxyPort: 1080, TunnelPort: 1, ProxyType: "socks6"},
+		{ProxyAddress: "10.0.0.1", ProxyPort: 1080, TunnelPort: 1, ProxyPassword: "pass\n}\nredsocks {"},
+		{ProxyAddress: "10.0.0.1", ProxyPort: 1080, TunnelPort: 1, TunnelBindAddress: "any"},
+	}
+	for _, configuration := range invalid {
+		assert.NotNil(t, New().Configure(configuration), "configuration %+v should be rejected", configuration)
+	}
+}
@@ -0,0 +1,133 @@
+package secret
+
+import (
+	"fmt"
+	"github.com/yassine/soxy-driver/utils"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	//StoreDirEnv the environment variable overriding the driver secret store directory
+	StoreDirEnv = "SOXY_SECRETS_DIR"
+	//Redacted the placeholder secret values are replaced with in logs and errors
+	Redacted        = "*****"
+	defaultStoreDir = "/run/secrets"
+)
+
+//Settings where the secret references may point to : the network options are set by anyone allowed to create networks,
+//they mustn't read every file and environment variable of the driver
+type Settings struct {
+	//FileDir the directory the secret files have to be in, the file references are rejected if empty
+	FileDir string
+	//EnvPrefix the prefix the secret environment variables have to start with, the env references are rejected if empty
+	EnvPrefix string
+}
+
+var (
+	settings Settings
+	lock     sync.RWMutex
+)
+
+//Configure sets where the secret references resolved from now on may point to
+func Configure(value Settings) {
+	lock.Lock()
+	defer lock.Unlock()
+	settings = value
+}
+
+func current() Settings {
+	lock.RLock()
+	defer lock.RUnlock()
+	return settings
+}
+
+//FromFile reads a secret from a file of the allowed directory, the trailing new line is ignored
+func FromFile(path string) (string, error) {
+	dir := current().FileDir
+	if dir == "" {
+		return "", fmt.Errorf("secret files are disabled, no secret files directory is configured")
+	}
+	//the links are followed so that none points out of the allowed directory
+	resolved, err := utils.ResolveWithin(dir, path)
+	if err != nil {
+		return "", fmt.Errorf("invalid secret file : %v", err)
+	}
+	return ReadFile(resolved)
+}
+
+//FromEnv reads a secret from a driver environment variable starting with the allowed prefix
+func FromEnv(name string) (string, error) {
+	prefix := current().EnvPrefix
+	if prefix == "" {
+		return "", fmt.Errorf("secret environment variables are disabled, no secret environment prefix is configured")
+	}
+	if !strings.HasPrefix(name, prefix) || name == prefix {
+		return "", fmt.Errorf("secret environment variable '%s' doesn't start with the prefix '%s'", name, prefix)
+	}
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("the secret environment variable '%s' isn't set", name)
+	}
+	return value, nil
+}
+
+//FromStore reads a secret from the driver secret store (docker swarm secrets are mounted there by default)
+func FromStore(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid secret name '%s'", name)
+	}
+	dir := os.Getenv(StoreDirEnv)
+	if dir == "" {
+		dir = defaultStoreDir
+	}
+	value, err := ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("secret '%s' not found in the secret store '%s'", name, dir)
+	}
+	return value, nil
+}
+
+//ReadFile reads a secret from a file set by the driver configuration, regardless of the secret files directory
+func ReadFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("couldn't read the secret file '%s'", path)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+//IsSecretKey tells whether the values of a given option key have to be kept out of logs and errors
+func IsSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") || strings.Contains(key, "secret") || strings.Contains(key, "token")
+}
+
+//Redact returns the value to be logged for a given option
+func Redact(key string, value string) string {
+	if IsSecretKey(key) && !isReference(key) {
+		return Redacted
+	}
+	return RedactURL(value)
+}
+
+//RedactURL returns a given value with the password it holds replaced, if it's an url holding credentials
+func RedactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.User == nil {
+		return value
+	}
+	if _, ok := parsed.User.Password(); !ok {
+		return value
+	}
+	parsed.User = url.UserPassword(parsed.User.Username(), Redacted)
+	return parsed.String()
+}
+
+func isReference(key string) bool {
+	return strings.HasSuffix(key, ".file") || strings.HasSuffix(key, ".env") || strings.HasSuffix(key, ".secret")
+}
@@ -0,0 +1,75 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	//DirEnv the environment variable overriding the driver state directory
+	DirEnv = "SOXY_STATE_DIR"
+	//DefaultDir the directory where the driver persists the state that has to survive its restarts
+	DefaultDir = "/var/lib/soxy-driver"
+)
+
+//Store a JSON document persisted in the driver state directory, only readable by the driver
+type Store struct {
+	path string
+	sync.Mutex
+}
+
+//NewStore returns the store persisted in a given file
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+//Path returns the store file path
+func (s *Store) Path() string {
+	return s.path
+}
+
+//Load decodes the store content into a given value, which is left untouched if nothing has been saved yet
+func (s *Store) Load(value interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, value)
+}
+
+//Save replaces the store content with a given value, atomically so that a crash never leaves a truncated store behind
+func (s *Store) Save(value interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.path)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if err = file.Chmod(0600); err == nil {
+		_, err = file.Write(content)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.path)
+}
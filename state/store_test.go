@@ -0,0 +1,29 @@
+package state
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreSaveAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	store := NewStore(filepath.Join(dir, "nested", "overrides.json"))
+
+	loaded := map[string]string{"untouched": "yes"}
+	assert.Nil(t, store.Load(&loaded))
+	assert.Equal(t, map[string]string{"untouched": "yes"}, loaded)
+
+	assert.Nil(t, store.Save(map[string]string{"soxy.proxyport": "1080"}))
+	info, err := os.Stat(store.Path())
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded = nil
+	assert.Nil(t, store.Load(&loaded))
+	assert.Equal(t, map[string]string{"soxy.proxyport": "1080"}, loaded)
+}
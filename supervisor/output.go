@@ -0,0 +1,143 @@
+package supervisor
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/utils"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+//LogSettings how the output of the supervised processes is logged
+type LogSettings struct {
+	//Level the level the output lines are logged at
+	Level logrus.Level
+	//Dir the directory the output of each process is also written to, disabled if empty
+	Dir string
+	//MaxSize the size (in MB) above which a process log file is rotated
+	MaxSize int64
+	//MaxFiles the number of rotated log files kept
+	MaxFiles int
+}
+
+var (
+	logSettings = LogSettings{Level: logrus.InfoLevel}
+	logLock     sync.RWMutex
+)
+
+//ConfigureLogs sets how the output of the supervised processes is logged, the log files settings apply to the processes
+//started from now on
+func ConfigureLogs(settings LogSettings) {
+	logLock.Lock()
+	defer logLock.Unlock()
+	logSettings = settings
+}
+
+func currentLogSettings() LogSettings {
+	logLock.RLock()
+	defer logLock.RUnlock()
+	return logSettings
+}
+
+//SetLogging sets the fields the process output lines are logged with and the name of its log file
+func (p *Process) SetLogging(fields logrus.Fields, logFile string) {
+	p.Lock()
+	defer p.Unlock()
+	p.fields = fields
+	p.logFile = logFile
+}
+
+//OnOutput registers a callback every process output line is handed to
+func (p *Process) OnOutput(callback func(line string)) {
+	p.Lock()
+	defer p.Unlock()
+	p.onOutput = callback
+}
+
+//processOutput re-emits the output lines of a process run through logrus
+type processOutput struct {
+	entry    *logrus.Entry
+	pid      int64
+	file     *utils.RotatingFile
+	callback func(line string)
+	//writer the pipe end handed to the process, closed on the driver side once the process is started
+	writer *os.File
+}
+
+//capture redirects the output of a command about to be started, the caller must hold the lock
+func (p *Process) capture(cmd *exec.Cmd) *processOutput {
+	settings := currentLogSettings()
+	if settings.Dir != "" && p.output == nil {
+		name := p.logFile
+		if name == "" {
+			name = p.name
+		}
+		file, err := utils.NewRotatingFile(filepath.Join(settings.Dir, name+".log"), settings.MaxSize*1024*1024, settings.MaxFiles)
+		if err != nil {
+			logrus.Errorf("couldn't open the %s log file : %v", p.name, err)
+		} else {
+			p.output = file
+		}
+	}
+	output := &processOutput{
+		entry:    logrus.WithFields(p.fields).WithField("component", p.name),
+		file:     p.output,
+		callback: p.onOutput,
+	}
+	//the process writes to a pipe rather than to a writer copied by exec : waiting on the process doesn't then depend on
+	//its children closing their inherited output
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		logrus.Errorf("couldn't capture the %s output : %v", p.name, err)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		return output
+	}
+	output.writer = writer
+	cmd.Stdout, cmd.Stderr = writer, writer
+	go func() {
+		defer reader.Close()
+		io.Copy(utils.NewLineWriter(output.line), reader)
+	}()
+	return output
+}
+
+//started records the pid of the process the output belongs to, pid being 0 if the process couldn't be started
+func (o *processOutput) started(pid int) {
+	atomic.StoreInt64(&o.pid, int64(pid))
+	if o.writer != nil {
+		o.writer.Close()
+	}
+}
+
+func (o *processOutput) line(line string) {
+	entry := o.entry.WithField("pid", atomic.LoadInt64(&o.pid))
+	switch currentLogSettings().Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		entry.Error(line)
+	case logrus.WarnLevel:
+		entry.Warn(line)
+	case logrus.InfoLevel:
+		entry.Info(line)
+	default:
+		entry.Debug(line)
+	}
+	if o.file != nil {
+		o.file.Write([]byte(line + "\n"))
+	}
+	if o.callback != nil {
+		o.callback(line)
+	}
+}
+
+//closeOutput closes the process log file, if any
+func (p *Process) closeOutput() {
+	p.Lock()
+	defer p.Unlock()
+	if p.output != nil {
+		utils.LogIfNotNull(p.output.Close())
+		p.output = nil
+	}
+}
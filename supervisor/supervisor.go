@@ -0,0 +1,258 @@
+package supervisor
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/utils"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//State the lifecycle state of a supervised process
+type State string
+
+const (
+	//Stopped the process is not running and won't be restarted
+	Stopped State = "stopped"
+	//Running the process is running
+	Running State = "running"
+	//Backoff the process exited and is waiting to be restarted
+	Backoff State = "backoff"
+	//Failed the process crashed too often and was given up on
+	Failed State = "failed"
+)
+
+//Policy the restart policy applied to a supervised process
+type Policy struct {
+	//InitialBackoff the delay before the first restart
+	InitialBackoff time.Duration
+	//MaxBackoff the upper bound of the (exponentially growing) restart delay
+	MaxBackoff time.Duration
+	//MaxRestarts the number of restarts tolerated within Window before giving up
+	MaxRestarts int
+	//Window the period over which restarts are counted
+	Window time.Duration
+}
+
+//DefaultPolicy the restart policy used by the driver child processes
+var DefaultPolicy = Policy{
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	MaxRestarts:    5,
+	Window:         2 * time.Minute,
+}
+
+//Status a snapshot of a supervised process state
+type Status struct {
+	Name         string    `json:"name"`
+	State        State     `json:"state"`
+	PID          int       `json:"pid"`
+	Restarts     int       `json:"restarts"`
+	LastExitCode int       `json:"lastExitCode"`
+	LastExitTime time.Time `json:"lastExitTime"`
+	StartedAt    time.Time `json:"startedAt"`
+}
+
+//Uptime returns for how long the process has been running, zero if it isn't
+func (s Status) Uptime() time.Duration {
+	if s.State != Running || s.StartedAt.IsZero() {
+		return 0
+	}
+	return time.Since(s.StartedAt)
+}
+
+//Process supervises a child process : it waits on it and restarts it when it exits unexpectedly
+type Process struct {
+	name      string
+	command   func() *exec.Cmd
+	policy    Policy
+	onFailure func(Status)
+	cmd       *exec.Cmd
+	status    Status
+	crashes   []time.Time
+	stop      chan struct{}
+	done      chan struct{}
+	//fields the fields the process output lines are logged with
+	fields logrus.Fields
+	//logFile the name of the process log file
+	logFile  string
+	onOutput func(line string)
+	//output the process log file, while supervised
+	output *utils.RotatingFile
+	sync.Mutex
+}
+
+//New creates a supervisor for the processes built by the given command factory
+func New(name string, command func() *exec.Cmd, policy Policy) *Process {
+	return &Process{
+		name:    name,
+		command: command,
+		policy:  policy,
+		status: Status{
+			Name:  name,
+			State: Stopped,
+		},
+	}
+}
+
+//OnFailure registers a callback invoked once the process is permanently given up on
+func (p *Process) OnFailure(callback func(Status)) {
+	p.Lock()
+	defer p.Unlock()
+	p.onFailure = callback
+}
+
+//Start starts the process and its supervision, it is a no-op if the process is already supervised
+func (p *Process) Start() error {
+	p.Lock()
+	defer p.Unlock()
+	if p.stop != nil {
+		return nil
+	}
+	cmd := p.command()
+	output := p.capture(cmd)
+	if err := cmd.Start(); err != nil {
+		output.started(0)
+		return err
+	}
+	output.started(cmd.Process.Pid)
+	p.crashes = nil
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	p.running(cmd)
+	go p.supervise(cmd, p.stop, p.done)
+	return nil
+}
+
+//Stop kills the process and stops its supervision
+func (p *Process) Stop() error {
+	p.Lock()
+	if p.stop == nil {
+		p.Unlock()
+		p.closeOutput()
+		return nil
+	}
+	stop, done := p.stop, p.done
+	p.stop = nil
+	close(stop)
+	var err error
+	if p.cmd != nil && p.cmd.Process != nil {
+		err = p.cmd.Process.Kill()
+	}
+	p.Unlock()
+	<-done
+	p.closeOutput()
+	return err
+}
+
+//Status returns a snapshot of the supervised process state
+func (p *Process) Status() Status {
+	p.Lock()
+	defer p.Unlock()
+	return p.status
+}
+
+func (p *Process) supervise(cmd *exec.Cmd, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	backoff := p.policy.InitialBackoff
+	for {
+		exitCode := -1
+		if cmd != nil {
+			cmd.Wait()
+			exitCode = exitCodeOf(cmd)
+			utils.RemovePidFile(cmd.Process.Pid)
+		}
+
+		p.Lock()
+		p.cmd = nil
+		p.status.PID = 0
+		p.status.LastExitCode = exitCode
+		p.status.LastExitTime = time.Now()
+		select {
+		case <-stop:
+			p.status.State = Stopped
+			p.Unlock()
+			return
+		default:
+		}
+		if p.crashLooping() {
+			p.status.State = Failed
+			p.stop = nil
+			status, callback := p.status, p.onFailure
+			p.Unlock()
+			logrus.Errorf("%s crashed %d times within %s, giving up", p.name, len(p.crashes), p.policy.Window)
+			if callback != nil {
+				callback(status)
+			}
+			return
+		}
+		p.status.State = Backoff
+		p.Unlock()
+
+		logrus.Warnf("%s exited with code %d, restarting in %s", p.name, exitCode, backoff)
+		select {
+		case <-stop:
+			p.Lock()
+			p.status.State = Stopped
+			p.Unlock()
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > p.policy.MaxBackoff {
+			backoff = p.policy.MaxBackoff
+		}
+
+		p.Lock()
+		cmd = p.command()
+		output := p.capture(cmd)
+		if err := cmd.Start(); err != nil {
+			output.started(0)
+			logrus.Errorf("couldn't restart %s : %v", p.name, err)
+			cmd = nil
+		} else {
+			output.started(cmd.Process.Pid)
+			p.running(cmd)
+		}
+		p.status.Restarts++
+		p.Unlock()
+	}
+}
+
+// running records a started command, along with its pid file, the caller must hold the lock
+func (p *Process) running(cmd *exec.Cmd) {
+	if err := utils.WritePidFile(cmd.Process.Pid); err != nil {
+		logrus.Warnf("couldn't record the pid of %s : %v", p.name, err)
+	}
+	p.cmd = cmd
+	p.status.State = Running
+	p.status.PID = cmd.Process.Pid
+	p.status.StartedAt = time.Now()
+}
+
+// crashLooping records a crash and tells whether the restart limit is exceeded, the caller must hold the lock
+func (p *Process) crashLooping() bool {
+	now := time.Now()
+	recent := p.crashes[:0]
+	for _, crash := range p.crashes {
+		if now.Sub(crash) < p.policy.Window {
+			recent = append(recent, crash)
+		}
+	}
+	p.crashes = append(recent, now)
+	return len(p.crashes) > p.policy.MaxRestarts
+}
+
+func exitCodeOf(cmd *exec.Cmd) int {
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+		if status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+	return -1
+}
@@ -0,0 +1,103 @@
+package supervisor
+
+import (
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     4 * time.Millisecond,
+	MaxRestarts:    3,
+	Window:         time.Minute,
+}
+
+func TestProcessGivesUpOnCrashLoop(t *testing.T) {
+	failed := make(chan Status, 1)
+	process := New("crashing", func() *exec.Cmd {
+		return exec.Command("sh", "-c", "exit 3")
+	}, testPolicy)
+	process.OnFailure(func(status Status) {
+		failed <- status
+	})
+	assert.Nil(t, process.Start())
+
+	select {
+	case status := <-failed:
+		assert.Equal(t, Failed, status.State)
+		assert.Equal(t, testPolicy.MaxRestarts, status.Restarts)
+		assert.Equal(t, 3, status.LastExitCode)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the crash loop wasn't detected")
+	}
+	assert.Equal(t, Failed, process.Status().State)
+}
+
+func TestProcessStop(t *testing.T) {
+	process := New("sleeping", func() *exec.Cmd {
+		return exec.Command("sleep", "60")
+	}, testPolicy)
+	assert.Nil(t, process.Start())
+	assert.Equal(t, Running, process.Status().State)
+	assert.NotZero(t, process.Status().PID)
+
+	process.Stop()
+	status := process.Status()
+	assert.Equal(t, Stopped, status.State)
+	assert.Equal(t, 0, status.Restarts)
+	assert.Zero(t, status.PID)
+}
+
+func TestProcessRestartsAfterCrash(t *testing.T) {
+	process := New("restarting", func() *exec.Cmd {
+		return exec.Command("sh", "-c", "sleep 0.05; exit 1")
+	}, Policy{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+		MaxRestarts:    100,
+		Window:         time.Minute,
+	})
+	assert.Nil(t, process.Start())
+	time.Sleep(300 * time.Millisecond)
+	process.Stop()
+	status := process.Status()
+	assert.True(t, status.Restarts > 0)
+	assert.Equal(t, Stopped, status.State)
+}
+
+func TestProcessOutputIsCaptured(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy-process-logs")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	ConfigureLogs(LogSettings{Level: logrus.DebugLevel, Dir: dir, MaxSize: 1, MaxFiles: 1})
+	defer ConfigureLogs(LogSettings{Level: logrus.InfoLevel})
+
+	lines := make(chan string, 2)
+	process := New("talking", func() *exec.Cmd {
+		return exec.Command("sh", "-c", "echo out; echo err >&2; sleep 60")
+	}, testPolicy)
+	process.SetLogging(logrus.Fields{"network_id": "4f1e0d6a5b2c"}, "4f1e0d6a5b2c")
+	process.OnOutput(func(line string) { lines <- line })
+	assert.Nil(t, process.Start())
+
+	received := map[string]bool{}
+	for len(received) < 2 {
+		select {
+		case line := <-lines:
+			received[line] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("the process output wasn't captured")
+		}
+	}
+	process.Stop()
+	assert.Equal(t, map[string]bool{"out": true, "err": true}, received)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "4f1e0d6a5b2c.log"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "out\n")
+}
@@ -0,0 +1,107 @@
+package tor
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const controlTimeout = 2 * time.Second
+
+var bootstrapProgress = regexp.MustCompile(`PROGRESS=(\d+)`)
+
+//ControlInfo the embedded tor instance state, as reported through its control port
+type ControlInfo struct {
+	//BootstrapProgress the tor bootstrap progress, in percents
+	BootstrapProgress int
+	//Circuits the number of built circuits
+	Circuits int
+}
+
+//ControlInfo queries the embedded tor instance bootstrap progress and circuits through its control port
+func (t *Tor) ControlInfo() (*ControlInfo, error) {
+	cookie, err := ioutil.ReadFile(t.CookieFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the tor control cookie : %v", err)
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.FormatInt(t.ControlPort, 10)), controlTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	return queryControlInfo(conn, cookie)
+}
+
+func queryControlInfo(conn io.ReadWriter, cookie []byte) (*ControlInfo, error) {
+	control := &controlConn{conn, textproto.NewReader(bufio.NewReader(conn))}
+	if _, err := control.do("AUTHENTICATE " + hex.EncodeToString(cookie)); err != nil {
+		return nil, err
+	}
+	info := &ControlInfo{}
+	lines, err := control.do("GETINFO status/bootstrap-phase")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if match := bootstrapProgress.FindStringSubmatch(line); match != nil {
+			info.BootstrapProgress, _ = strconv.Atoi(match[1])
+		}
+	}
+	lines, err = control.do("GETINFO circuit-status")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == "BUILT" {
+			info.Circuits++
+		}
+	}
+	control.do("QUIT")
+	return info, nil
+}
+
+//controlConn a tor control protocol connection
+type controlConn struct {
+	writer io.Writer
+	reader *textproto.Reader
+}
+
+//do sends a command and returns the lines of its successful reply, data blocks included
+func (c *controlConn) do(command string) ([]string, error) {
+	if _, err := fmt.Fprintf(c.writer, "%s\r\n", command); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for {
+		line, err := c.reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) < 4 {
+			return nil, fmt.Errorf("malformed tor control reply '%s'", line)
+		}
+		if !strings.HasPrefix(line, "250") {
+			return nil, fmt.Errorf("tor control command '%s' failed : %s", strings.Fields(command)[0], line)
+		}
+		lines = append(lines, line[4:])
+		switch line[3] {
+		case ' ':
+			return lines, nil
+		case '+':
+			data, err := c.reader.ReadDotLines()
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, data...)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package tor
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+)
+
+func fakeControlPort(conn net.Conn, replies map[string]string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.Fields(line)[0]
+		if command == "GETINFO" {
+			command = strings.TrimSpace(line)
+		}
+		reply, ok := replies[command]
+		if !ok {
+			reply = "510 Unrecognized command\r\n"
+		}
+		conn.Write([]byte(reply))
+	}
+}
+
+func TestQueryControlInfo(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go fakeControlPort(server, map[string]string{
+		"AUTHENTICATE": "250 OK\r\n",
+		"GETINFO status/bootstrap-phase": "250-status/bootstrap-phase=NOTICE BOOTSTRAP PROGRESS=85 TAG=ap_conn SUMMARY=\"Connecting\"\r\n" +
+			"250 OK\r\n",
+		"GETINFO circuit-status": "250+circuit-status=\r\n" +
+			"1 BUILT $A~a,$B~b,$C~c PURPOSE=GENERAL\r\n" +
+			"2 EXTENDED $A~a PURPOSE=GENERAL\r\n" +
+			"3 BUILT $D~d,$E~e,$F~f PURPOSE=GENERAL\r\n" +
+			".\r\n" +
+			"250 OK\r\n",
+		"QUIT": "250 closing connection\r\n",
+	})
+	info, err := queryControlInfo(client, []byte{0xca, 0xfe})
+	assert.Nil(t, err)
+	assert.Equal(t, &ControlInfo{BootstrapProgress: 85, Circuits: 2}, info)
+}
+
+func TestQueryControlInfoAuthenticationFailure(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go fakeControlPort(server, map[string]string{"AUTHENTICATE": "515 Authentication failed\r\n"})
+	_, err := queryControlInfo(client, []byte{0xca, 0xfe})
+	assert.NotNil(t, err)
+}
@@ -1,28 +1,37 @@
 package tor
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/supervisor"
 	"github.com/yassine/soxy-driver/utils"
-	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"text/template"
 )
 
 //Tor a base tor structure that encapsulate the embedded tor instance
 type Tor struct {
-	SocksPort  int64
-	DNSPort    int64
-	command    *exec.Cmd
+	SocksPort int64
+	DNSPort   int64
+	//ControlPort the port of the tor control protocol, bound to the loopback interface
+	ControlPort int64
+	//CookieFile the file tor writes the control protocol authentication cookie to
+	CookieFile string
+	process    *supervisor.Process
 	configfile *os.File
-	isRunning  bool
+	template   string
 	sync.Mutex
+	//configLock guards configfile on its own : the process is started, thus the configuration file read, while the
+	//tor lock is held by Reconfigure
+	configLock sync.Mutex
 }
 
-//New creates and init a new Tor structure instance
-func New() (t *Tor) {
-	tor := &Tor{}
+//New creates and init a new Tor structure instance, rendering its configuration from the given template (the default one if empty)
+func New(configTemplate string) (t *Tor) {
+	tor := &Tor{template: configTemplate}
 	tor.init()
 	return tor
 }
@@ -37,55 +46,139 @@ func (t *Tor) init() {
 	defer t.Unlock()
 	t.SocksPort = utils.FindAvailablePort()
 	t.DNSPort = utils.FindAvailablePort()
+	t.ControlPort = utils.FindAvailablePort()
+	if dir, err := utils.RuntimeDir(); err == nil {
+		t.CookieFile = filepath.Join(dir, "tor-control.cookie")
+	} else {
+		logrus.Errorf("couldn't locate the tor control cookie : %v", err)
+	}
 	logrus.Debugf("using port '%d' as fallback tor proxy port", t.SocksPort)
-	t.configfile = tempFileConfig(t)
-	command := exec.Command("tor", "-f", t.configfile.Name())
-	command.Stdin = os.Stdin
-	command.Stdout = os.Stdout
-	command.Stderr = os.Stderr
-	t.command = command
+	configfile, err := tempFileConfig(t)
+	if err != nil {
+		logrus.Errorf("couldn't render the tor configuration : %v", err)
+	}
+	t.setConfigFile(configfile)
+	t.process = supervisor.New("tor", t.command, supervisor.DefaultPolicy)
 }
 
-//Startup starts the embedded Tor instance
-func (t *Tor) Startup() error {
+//Reconfigure renders the tor configuration from a new template (the default one if empty), restarting tor if it is running
+func (t *Tor) Reconfigure(configTemplate string) error {
 	t.Lock()
 	defer t.Unlock()
-	if !t.isRunning {
-		t.isRunning = true
-		err := t.command.Start()
-		if err != nil {
-			logrus.Error(err)
-			return err
-		}
+	previous := t.template
+	t.template = configTemplate
+	configfile, err := tempFileConfig(t)
+	if err != nil {
+		t.template = previous
+		return err
+	}
+	running := t.process.Status().State != supervisor.Stopped
+	utils.LogIfNotNull(t.process.Stop())
+	if previousFile := t.setConfigFile(configfile); previousFile != nil {
+		os.Remove(previousFile.Name())
+	}
+	if running {
+		return t.process.Start()
 	}
 	return nil
 }
 
+func (t *Tor) command() *exec.Cmd {
+	command := exec.Command("tor", "-f", t.configFile().Name())
+	command.Stdin = os.Stdin
+	return command
+}
+
+//configFile returns the current tor configuration file, nil if it couldn't be rendered
+func (t *Tor) configFile() *os.File {
+	t.configLock.Lock()
+	defer t.configLock.Unlock()
+	return t.configfile
+}
+
+//setConfigFile replaces the tor configuration file and returns the previous one
+func (t *Tor) setConfigFile(configfile *os.File) *os.File {
+	t.configLock.Lock()
+	defer t.configLock.Unlock()
+	previous := t.configfile
+	t.configfile = configfile
+	return previous
+}
+
+//Startup starts the embedded Tor instance
+func (t *Tor) Startup() error {
+	if t.configFile() == nil {
+		return fmt.Errorf("tor can't be started without a configuration")
+	}
+	err := t.process.Start()
+	if err != nil {
+		logrus.Error(err)
+	}
+	return err
+}
+
+//Status returns the state of the supervised tor process
+func (t *Tor) Status() supervisor.Status {
+	return t.process.Status()
+}
+
+//OnFailure registers a callback invoked when tor keeps crashing and is given up on
+func (t *Tor) OnFailure(callback func(supervisor.Status)) {
+	t.process.OnFailure(callback)
+}
+
+//Stop stops the embedded Tor instance, keeping its configuration so that it can be started again
+func (t *Tor) Stop() error {
+	return t.process.Stop()
+}
+
 //Shutdown stops the embedded Tor instance
 func (t *Tor) Shutdown() error {
 	//Kill the process
-	err := t.command.Process.Kill()
+	err := t.process.Stop()
 	utils.LogIfNotNull(err)
 	//Remove config file
-	err = os.Remove(t.configfile.Name())
+	if configfile := t.configFile(); configfile != nil {
+		err = os.Remove(configfile.Name())
+	}
 	return err
 }
 
-func tempFileConfig(config *Tor) *os.File {
-	t := template.Must(template.New("configTemplate").Parse(torConfigurationTemplate))
-	tempFile, _ := ioutil.TempFile("/tmp", "tor-config")
-	err := t.Execute(tempFile, config)
+func tempFileConfig(config *Tor) (*os.File, error) {
+	for _, port := range []int64{config.SocksPort, config.DNSPort, config.ControlPort} {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid tor port '%d'", port)
+		}
+	}
+	configTemplate := config.template
+	if configTemplate == "" {
+		configTemplate = DefaultConfigurationTemplate
+	}
+	t, err := template.New("configTemplate").Parse(configTemplate)
 	if err != nil {
-		logrus.Error(err)
+		return nil, err
+	}
+	tempFile, err := utils.CreateRuntimeFile("tor-config")
+	if err != nil {
+		return nil, err
+	}
+	defer tempFile.Close()
+	err = t.Execute(tempFile, config)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return nil, err
 	}
-	return tempFile
+	return tempFile, nil
 }
 
-const torConfigurationTemplate = `Log notice stdout
+//DefaultConfigurationTemplate the default embedded tor instance configuration template
+const DefaultConfigurationTemplate = `Log notice stdout
 ExitPolicy reject *:*
 SocksPort 0.0.0.0:{{.SocksPort}}
 DNSPort 0.0.0.0:{{.DNSPort}}
 AutomapHostsOnResolve 1
-ControlListenAddress 0.0.0.0
+ControlPort 127.0.0.1:{{.ControlPort}}
+CookieAuthentication 1
+CookieAuthFile {{.CookieFile}}
 GeoIPExcludeUnknown 1
    `
@@ -0,0 +1,43 @@
+package tor
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/utils"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestReconfigureReplacesTheConfigurationFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy-tor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	os.Setenv(utils.RuntimeDirEnv, dir)
+	defer os.Unsetenv(utils.RuntimeDirEnv)
+
+	tor := New("")
+	previous := tor.command().Args[2]
+	//the command is built while the configuration is replaced, as the supervisor restarting tor does
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		for i := 0; i < 100; i++ {
+			tor.command()
+		}
+	}()
+	assert.Nil(t, tor.Reconfigure("SocksPort {{.SocksPort}}\n"))
+	wait.Wait()
+
+	current := tor.command().Args[2]
+	assert.NotEqual(t, previous, current)
+	_, err = os.Stat(previous)
+	assert.True(t, os.IsNotExist(err))
+	content, err := ioutil.ReadFile(current)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "SocksPort ")
+	assert.Nil(t, tor.Shutdown())
+	_, err = os.Stat(current)
+	assert.True(t, os.IsNotExist(err))
+}
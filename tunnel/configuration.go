@@ -0,0 +1,23 @@
+package tunnel
+
+//Configuration the configuration a tunnel backend is rendered from
+type Configuration struct {
+	//NetworkID the id of the network the tunnel belongs to
+	NetworkID string
+	//ProxyAddress the proxy address
+	ProxyAddress string
+	//ProxyPort the proxy port
+	ProxyPort int64
+	//ProxyType the proxy type. Available options : socks4, socks5, http-connect, http-relay
+	ProxyType string
+	//ProxyUser the proxy user (if authentication applies)
+	ProxyUser string
+	//ProxyPassword the proxy password (if authentication applies)
+	ProxyPassword string
+	//TunnelPort the port through which traffic is tunneled
+	TunnelPort int64
+	//TunnelBindAddress the tunnel bind address
+	TunnelBindAddress string
+	//Options the raw network options, used by backends having specific settings
+	Options map[string]string
+}
@@ -0,0 +1,239 @@
+package tunnel
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/secret"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+//DefaultExecArgs the arguments template used when a configuration template is given without arguments
+const DefaultExecArgs = "-c {{.ConfigFile}}"
+
+//ExecSettings what the exec backend may run : the network options are set by anyone allowed to create networks while
+//the binaries run as the driver
+type ExecSettings struct {
+	//Binaries the paths of the binaries the exec backend may run, the exec backend is disabled if empty
+	Binaries []string
+	//TemplateDir the directory the configuration templates have to be in, the templates are rejected if empty
+	TemplateDir string
+}
+
+var (
+	execSettings ExecSettings
+	execLock     sync.RWMutex
+)
+
+//ConfigureExec sets what the exec backends configured from now on may run
+func ConfigureExec(settings ExecSettings) {
+	execLock.Lock()
+	defer execLock.Unlock()
+	execSettings = settings
+}
+
+func currentExecSettings() ExecSettings {
+	execLock.RLock()
+	defer execLock.RUnlock()
+	return execSettings
+}
+
+//Exec a tunnel backend running an allowed transparent proxy binary (e.g. ipt2socks, gost, redsocks2)
+type Exec struct {
+	//Binary the transparent proxy binary name or path
+	Binary string
+	//Args the binary arguments template
+	Args string
+	//Template the path of the binary configuration file template
+	Template       string
+	argsTemplate   *template.Template
+	configTemplate *template.Template
+	//path the allowed binary path Binary resolves to
+	path       string
+	configFile string
+	args       []string
+	process    *supervisor.Process
+	*Configuration
+	sync.Mutex
+}
+
+//ExecData the data the exec backend templates are rendered with
+type ExecData struct {
+	*Configuration
+	//ConfigFile the path of the rendered configuration file, if any
+	ConfigFile string
+}
+
+//NewExec creates an exec backend, it has to be configured before being started
+func NewExec(binary string, args string, configTemplate string) *Exec {
+	backend := &Exec{
+		Binary:   binary,
+		Args:     args,
+		Template: configTemplate,
+	}
+	backend.process = supervisor.New(filepath.Base(binary), backend.command, supervisor.DefaultPolicy)
+	return backend
+}
+
+//Configure checks the binary and the templates are allowed and sets the configuration the backend would be started with
+func (e *Exec) Configure(configuration *Configuration) error {
+	e.Lock()
+	defer e.Unlock()
+	settings := currentExecSettings()
+	path, err := allowedBinary(e.Binary, settings.Binaries)
+	if err != nil {
+		return err
+	}
+	e.path = path
+	args := e.Args
+	if args == "" && e.Template != "" {
+		args = DefaultExecArgs
+	}
+	argsTemplate, err := template.New("args").Parse(args)
+	if err != nil {
+		return fmt.Errorf("invalid tunnel arguments template : %v", err)
+	}
+	e.argsTemplate = argsTemplate
+	e.configTemplate = nil
+	if e.Template != "" {
+		if settings.TemplateDir == "" {
+			return fmt.Errorf("tunnel configuration templates are disabled, no template directory is configured")
+		}
+		templatePath, err := utils.ResolveWithin(settings.TemplateDir, e.Template)
+		if err != nil {
+			return fmt.Errorf("invalid tunnel configuration template : %v", err)
+		}
+		content, err := ioutil.ReadFile(templatePath)
+		if err != nil {
+			return fmt.Errorf("couldn't read tunnel configuration template : %v", err)
+		}
+		configTemplate, err := template.New(filepath.Base(e.Template)).Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("invalid tunnel configuration template '%s' : %v", e.Template, err)
+		}
+		e.configTemplate = configTemplate
+	}
+	e.Configuration = configuration
+	e.process.SetLogging(logrus.Fields{"network_id": configuration.NetworkID}, configuration.NetworkID)
+	return nil
+}
+
+//Start renders the configuration file and the arguments, then starts the binary. The lock is released beforehand as
+//the process command is built from the rendered arguments
+func (e *Exec) Start() error {
+	e.Lock()
+	err := e.render()
+	e.Unlock()
+	if err != nil {
+		return err
+	}
+	err = e.process.Start()
+	utils.LogIfNotNull(err)
+	return err
+}
+
+//render writes the configuration file, unless already written, and sets the binary arguments
+func (e *Exec) render() error {
+	if e.argsTemplate == nil {
+		return fmt.Errorf("tunnel backend '%s' isn't configured", e.Binary)
+	}
+	data := &ExecData{Configuration: e.Configuration}
+	if e.configTemplate != nil && e.configFile == "" {
+		tempFile, err := utils.CreateRuntimeFile(filepath.Base(e.Binary))
+		if err != nil {
+			return err
+		}
+		err = e.configTemplate.Execute(tempFile, data)
+		tempFile.Close()
+		if err != nil {
+			os.Remove(tempFile.Name())
+			return fmt.Errorf("couldn't render tunnel configuration : %v", err)
+		}
+		e.configFile = tempFile.Name()
+	}
+	var args bytes.Buffer
+	if err := e.argsTemplate.Execute(&args, &ExecData{Configuration: argsConfiguration(e.Configuration), ConfigFile: e.configFile}); err != nil {
+		return fmt.Errorf("couldn't render tunnel arguments : %v", err)
+	}
+	e.args = strings.Fields(args.String())
+	return nil
+}
+
+//Stop stops the binary and removes its configuration file
+func (e *Exec) Stop() error {
+	err := e.process.Stop()
+	utils.LogIfNotNull(err)
+	e.Lock()
+	defer e.Unlock()
+	if e.configFile != "" {
+		err = os.Remove(e.configFile)
+		utils.LogIfNotNull(err)
+		e.configFile = ""
+	}
+	return err
+}
+
+//Health returns the state of the supervised binary
+func (e *Exec) Health() supervisor.Status {
+	return e.process.Status()
+}
+
+//OnFailure registers a callback invoked when the binary keeps crashing and is given up on
+func (e *Exec) OnFailure(callback func(supervisor.Status)) {
+	e.process.OnFailure(callback)
+}
+
+func (e *Exec) command() *exec.Cmd {
+	e.Lock()
+	path, args := e.path, e.args
+	e.Unlock()
+	command := exec.Command(path, args...)
+	command.Stdin = os.Stdin
+	return command
+}
+
+//argsConfiguration returns the configuration the arguments are rendered with : the arguments are visible to any local
+//user (ps, /proc/<pid>/cmdline), the proxy password and the secret options are left out of it
+func argsConfiguration(configuration *Configuration) *Configuration {
+	if configuration == nil {
+		return nil
+	}
+	redacted := *configuration
+	redacted.ProxyPassword = ""
+	redacted.Options = make(map[string]string)
+	for key, value := range configuration.Options {
+		if !secret.IsSecretKey(key) {
+			redacted.Options[key] = secret.RedactURL(value)
+		}
+	}
+	return &redacted
+}
+
+//allowedBinary returns the path a given binary resolves to, provided it is one of the allowed binaries
+func allowedBinary(binary string, allowed []string) (string, error) {
+	if len(allowed) == 0 {
+		return "", fmt.Errorf("the exec tunnel backend is disabled, no tunnel binary is allowed")
+	}
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", fmt.Errorf("tunnel binary '%s' not found : %v", binary, err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("tunnel binary '%s' not found : %v", binary, err)
+	}
+	for _, candidate := range allowed {
+		if allowedPath, err := filepath.EvalSymlinks(candidate); err == nil && allowedPath == resolved {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("tunnel binary '%s' isn't allowed (allowed binaries : %s)", binary, strings.Join(allowed, ", "))
+}
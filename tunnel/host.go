@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"strings"
+)
+
+//sniffedBytes the number of bytes a client first sends that are kept to find the host it reaches
+const sniffedBytes = 4096
+
+//hostSniffer keeps the first bytes a client sends, they name the host it reaches (the TLS SNI or the HTTP Host header)
+type hostSniffer struct {
+	prefix []byte
+}
+
+func (s *hostSniffer) Write(p []byte) (int, error) {
+	if missing := sniffedBytes - len(s.prefix); missing > 0 {
+		if len(p) < missing {
+			missing = len(p)
+		}
+		s.prefix = append(s.prefix, p[:missing]...)
+	}
+	return len(p), nil
+}
+
+//host returns the host named by the first bytes the client sent, empty if none
+func (s *hostSniffer) host() string {
+	if host := serverName(s.prefix); host != "" {
+		return host
+	}
+	return httpHost(s.prefix)
+}
+
+//serverName returns the server name indication of a TLS client hello, empty if the data isn't one
+func serverName(data []byte) string {
+	//record header : content type (handshake), version, length
+	if len(data) < 5 || data[0] != 0x16 {
+		return ""
+	}
+	data = data[5:]
+	//handshake header : type (client hello), length, then the client version and random
+	if len(data) < 38 || data[0] != 0x01 {
+		return ""
+	}
+	data = data[38:]
+	var ok bool
+	//session id, cipher suites and compression methods are skipped
+	if data, ok = skip(data, 1); !ok {
+		return ""
+	}
+	if data, ok = skip(data, 2); !ok {
+		return ""
+	}
+	if data, ok = skip(data, 1); !ok {
+		return ""
+	}
+	if len(data) < 2 {
+		return ""
+	}
+	extensions := data[2:]
+	if length := int(binary.BigEndian.Uint16(data)); length < len(extensions) {
+		extensions = extensions[:length]
+	}
+	for len(extensions) >= 4 {
+		extension, length := binary.BigEndian.Uint16(extensions), int(binary.BigEndian.Uint16(extensions[2:]))
+		if len(extensions) < 4+length {
+			return ""
+		}
+		content := extensions[4 : 4+length]
+		extensions = extensions[4+length:]
+		if extension != 0 {
+			continue
+		}
+		//server name list : length, then name type (host name), length and name
+		if len(content) < 5 || content[2] != 0 {
+			return ""
+		}
+		nameLength := int(binary.BigEndian.Uint16(content[3:]))
+		if len(content) < 5+nameLength {
+			return ""
+		}
+		return string(content[5 : 5+nameLength])
+	}
+	return ""
+}
+
+//skip skips a vector whose length is encoded on a given number of bytes
+func skip(data []byte, lengthBytes int) ([]byte, bool) {
+	if len(data) < lengthBytes {
+		return nil, false
+	}
+	length := 0
+	for _, b := range data[:lengthBytes] {
+		length = length<<8 | int(b)
+	}
+	if len(data) < lengthBytes+length {
+		return nil, false
+	}
+	return data[lengthBytes+length:], true
+}
+
+//httpHost returns the Host header of an HTTP request, empty if the data isn't one
+func httpHost(data []byte) string {
+	end := bytes.Index(data, []byte("\r\n\r\n"))
+	if end < 0 {
+		return ""
+	}
+	request, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data[:end+4])))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(request.Host)
+}
@@ -0,0 +1,332 @@
+package tunnel
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"github.com/yassine/soxy-driver/supervisor"
+	"github.com/yassine/soxy-driver/utils"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//upstreamTimeout the delay after which a connection to an upstream proxy, handshake included, is given up on
+const upstreamTimeout = 30 * time.Second
+
+//Shared a transparent proxy owned by the driver and shared by the networks : the traffic of every network is redirected
+//to its single port, each connection being tunneled through the upstream proxy routed for its source address. Unless
+//bound to a given address, it only listens on the loopback and on the gateways of the routed networks, where their
+//redirected traffic arrives
+type Shared struct {
+	//BindAddress the address the shared tunnel listens on, the loopback and the routed networks gateways if empty
+	BindAddress string
+	//Port the port the shared tunnel listens on
+	Port int64
+	//routes the upstream configurations by source network, see Route
+	routes map[string]sharedRoute
+	//observers the callbacks the connections are reported to once closed, by network id
+	observers map[string]func(Connection)
+	//gateways the number of routed networks relying on each gateway address
+	gateways  map[string]int
+	listeners map[string]net.Listener
+	started   bool
+	status    supervisor.Status
+	sync.Mutex
+}
+
+//Connection a connection tunneled through the shared tunnel
+type Connection struct {
+	//Accepted when the connection was accepted
+	Accepted time.Time
+	//Source the client address
+	Source *net.TCPAddr
+	//Destination the original destination address
+	Destination *net.TCPAddr
+	//Upstream the proxy the connection was tunneled through
+	Upstream string
+	//Host the host the client reached, as named by its TLS server name indication or its HTTP Host header
+	Host string
+	//BytesSent the number of bytes sent by the client
+	BytesSent int64
+	//BytesReceived the number of bytes received by the client
+	BytesReceived int64
+	//Result how the connection ended
+	Result string
+}
+
+//sharedRoute the upstream configuration of the connections coming from a given source network
+type sharedRoute struct {
+	source        *net.IPNet
+	configuration *Configuration
+}
+
+//NewShared creates a shared tunnel, it has to be started to accept connections
+func NewShared(bindAddress string, port int64) *Shared {
+	return &Shared{
+		BindAddress: bindAddress,
+		Port:        port,
+		routes:      make(map[string]sharedRoute),
+		observers:   make(map[string]func(Connection)),
+		gateways:    make(map[string]int),
+		listeners:   make(map[string]net.Listener),
+		status:      supervisor.Status{Name: "shared-tunnel", State: supervisor.Stopped},
+	}
+}
+
+//Start starts accepting connections, it is a no-op if the shared tunnel is already started
+func (s *Shared) Start() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.started {
+		return nil
+	}
+	addresses := []string{s.BindAddress}
+	if s.BindAddress == "" {
+		addresses = []string{"127.0.0.1"}
+		for gateway := range s.gateways {
+			addresses = append(addresses, gateway)
+		}
+	}
+	for _, address := range addresses {
+		if err := s.listen(address); err != nil {
+			s.closeListeners()
+			return err
+		}
+	}
+	s.started = true
+	s.status.State, s.status.StartedAt = supervisor.Running, time.Now()
+	return nil
+}
+
+//Stop stops accepting connections, the established ones are left to complete
+func (s *Shared) Stop() error {
+	s.Lock()
+	defer s.Unlock()
+	if !s.started {
+		return nil
+	}
+	err := s.closeListeners()
+	s.started = false
+	s.status.State, s.status.StartedAt = supervisor.Stopped, time.Time{}
+	return err
+}
+
+//Listen accepts connections on a given gateway address as well, while a routed network relies on it : the traffic the
+//network bridge redirects arrives on its address. It is a no-op when the shared tunnel is bound to a given address
+func (s *Shared) Listen(gateway net.IP) error {
+	s.Lock()
+	defer s.Unlock()
+	address := gateway.String()
+	s.gateways[address]++
+	if !s.started || s.BindAddress != "" {
+		return nil
+	}
+	if err := s.listen(address); err != nil {
+		s.unlisten(address)
+		return err
+	}
+	return nil
+}
+
+//Unlisten stops accepting connections on a given gateway address once no routed network relies on it anymore
+func (s *Shared) Unlisten(gateway net.IP) {
+	s.Lock()
+	defer s.Unlock()
+	s.unlisten(gateway.String())
+}
+
+//Addresses returns the addresses the shared tunnel listens on
+func (s *Shared) Addresses() []string {
+	s.Lock()
+	defer s.Unlock()
+	addresses := make([]string, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		addresses = append(addresses, listener.Addr().String())
+	}
+	return addresses
+}
+
+//listen starts accepting connections on a given address unless already listening on it, the caller must hold the lock
+func (s *Shared) listen(address string) error {
+	if _, ok := s.listeners[address]; ok {
+		return nil
+	}
+	listener, err := net.Listen("tcp4", net.JoinHostPort(address, strconv.Itoa(int(s.Port))))
+	if err != nil {
+		return fmt.Errorf("couldn't start the shared tunnel : %v", err)
+	}
+	s.listeners[address] = listener
+	logrus.Infof("shared tunnel listening on %s", listener.Addr())
+	go s.serve(listener)
+	return nil
+}
+
+//unlisten releases a gateway address and closes its listener once unused, the caller must hold the lock
+func (s *Shared) unlisten(address string) {
+	s.gateways[address]--
+	if s.gateways[address] > 0 {
+		return
+	}
+	delete(s.gateways, address)
+	if listener, ok := s.listeners[address]; ok && s.BindAddress == "" && address != "127.0.0.1" {
+		utils.LogIfNotNull(listener.Close())
+		delete(s.listeners, address)
+	}
+}
+
+//closeListeners closes every listener, the caller must hold the lock
+func (s *Shared) closeListeners() error {
+	var err error
+	for address, listener := range s.listeners {
+		if closeErr := listener.Close(); closeErr != nil {
+			err = closeErr
+		}
+		delete(s.listeners, address)
+	}
+	return err
+}
+
+//Status returns the state of the shared tunnel
+func (s *Shared) Status() supervisor.Status {
+	s.Lock()
+	defer s.Unlock()
+	return s.status
+}
+
+//Route tunnels the connections coming from a given source network through the upstream proxy of a given configuration,
+//replacing the current route of that source network if any. The most specific route of a source address applies
+func (s *Shared) Route(source *net.IPNet, configuration *Configuration) {
+	s.Lock()
+	defer s.Unlock()
+	s.routes[source.String()] = sharedRoute{source: source, configuration: configuration}
+}
+
+//Unroute removes the route of a given source network, its new connections are closed unless another route applies
+func (s *Shared) Unroute(source *net.IPNet) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.routes, source.String())
+}
+
+//Observe reports the connections tunneled for a given network to a given callback once they are closed
+func (s *Shared) Observe(networkID string, observer func(Connection)) {
+	s.Lock()
+	defer s.Unlock()
+	s.observers[networkID] = observer
+}
+
+//Unobserve stops reporting the connections tunneled for a given network
+func (s *Shared) Unobserve(networkID string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.observers, networkID)
+}
+
+//report hands a closed connection to the observer of its network, if any
+func (s *Shared) report(networkID string, connection Connection) {
+	s.Lock()
+	observer := s.observers[networkID]
+	s.Unlock()
+	if observer != nil {
+		observer(connection)
+	}
+}
+
+//Routes returns the source networks routed through the shared tunnel
+func (s *Shared) Routes() []string {
+	s.Lock()
+	defer s.Unlock()
+	routes := make([]string, 0, len(s.routes))
+	for source := range s.routes {
+		routes = append(routes, source)
+	}
+	return routes
+}
+
+//route returns the configuration of the most specific route of a given source address, nil if none applies
+func (s *Shared) route(source net.IP) *Configuration {
+	s.Lock()
+	defer s.Unlock()
+	var configuration *Configuration
+	longest := -1
+	for _, route := range s.routes {
+		if ones, _ := route.source.Mask.Size(); ones > longest && route.source.Contains(source) {
+			configuration, longest = route.configuration, ones
+		}
+	}
+	return configuration
+}
+
+func (s *Shared) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				logrus.Warnf("shared tunnel couldn't accept a connection : %v", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			logrus.Debugf("shared tunnel stopped accepting connections : %v", err)
+			return
+		}
+		go s.handle(conn.(*net.TCPConn))
+	}
+}
+
+//handle tunnels a redirected connection through the upstream proxy of its source address
+func (s *Shared) handle(conn *net.TCPConn) {
+	defer conn.Close()
+	accepted := time.Now()
+	source := conn.RemoteAddr().(*net.TCPAddr)
+	configuration := s.route(source.IP)
+	if configuration == nil {
+		logrus.Debugf("shared tunnel : no route for '%s', closing its connection", source.IP)
+		return
+	}
+	log := logrus.WithField("network_id", configuration.NetworkID)
+	destination, err := originalDestination(conn)
+	if err != nil {
+		log.Debugf("shared tunnel : couldn't find the destination of '%s' : %v", source, err)
+		return
+	}
+	//a connection to the shared tunnel itself wasn't redirected
+	if local := conn.LocalAddr().(*net.TCPAddr); destination.IP.Equal(local.IP) && destination.Port == local.Port {
+		return
+	}
+	connection := Connection{
+		Accepted:    accepted,
+		Source:      source,
+		Destination: destination,
+		Upstream:    net.JoinHostPort(configuration.ProxyAddress, strconv.Itoa(int(configuration.ProxyPort))),
+	}
+	upstream, err := dialUpstream(configuration, destination, upstreamTimeout)
+	if err != nil {
+		log.Warnf("shared tunnel : couldn't reach '%s' from '%s' : %v", destination, source.IP, err)
+		connection.Result = err.Error()
+		s.report(configuration.NetworkID, connection)
+		return
+	}
+	defer upstream.Close()
+	sniffer := &hostSniffer{}
+	connection.BytesSent, connection.BytesReceived = relay(conn, upstream, sniffer)
+	connection.Host, connection.Result = sniffer.host(), "closed"
+	s.report(configuration.NetworkID, connection)
+}
+
+//relay copies the data of two connections both ways until both are closed, half-closing them as they are, and returns
+//the number of bytes sent by the client and received by it. The data the client sends is also written to a given writer
+func relay(client *net.TCPConn, upstream net.Conn, observer io.Writer) (int64, int64) {
+	done := make(chan int64)
+	go func() {
+		sent, _ := io.Copy(upstream, io.TeeReader(client, observer))
+		if conn, ok := upstream.(*net.TCPConn); ok {
+			conn.CloseWrite()
+		}
+		done <- sent
+	}()
+	received, _ := io.Copy(client, upstream)
+	client.CloseWrite()
+	return <-done, received
+}
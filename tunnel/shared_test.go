@@ -0,0 +1,229 @@
+package tunnel
+
+import (
+	"bufio"
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"github.com/yassine/soxy-driver/utils"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharedRouteIsTheMostSpecificOne(t *testing.T) {
+	shared := NewShared("", 0)
+	_, network, _ := net.ParseCIDR("172.30.0.0/16")
+	_, endpoint, _ := net.ParseCIDR("172.30.0.5/32")
+	shared.Route(network, &Configuration{NetworkID: "network"})
+	shared.Route(endpoint, &Configuration{NetworkID: "profile"})
+
+	assert.Equal(t, "profile", shared.route(net.ParseIP("172.30.0.5")).NetworkID)
+	assert.Equal(t, "network", shared.route(net.ParseIP("172.30.0.6")).NetworkID)
+	assert.Nil(t, shared.route(net.ParseIP("172.31.0.6")))
+
+	shared.Unroute(endpoint)
+	assert.Equal(t, "network", shared.route(net.ParseIP("172.30.0.5")).NetworkID)
+	assert.Equal(t, []string{"172.30.0.0/16"}, shared.Routes())
+}
+
+func TestSharedListensOnTheLoopbackAndGateways(t *testing.T) {
+	shared := NewShared("", utils.FindAvailablePort())
+	gateway := net.ParseIP("127.0.0.2")
+	port := strconv.Itoa(int(shared.Port))
+	assert.Nil(t, shared.Listen(gateway))
+	assert.Nil(t, shared.Start())
+	defer shared.Stop()
+	assert.ElementsMatch(t, []string{"127.0.0.1:" + port, "127.0.0.2:" + port}, shared.Addresses())
+
+	//the gateway is listened on while a network relies on it
+	assert.Nil(t, shared.Listen(gateway))
+	shared.Unlisten(gateway)
+	assert.Len(t, shared.Addresses(), 2)
+	shared.Unlisten(gateway)
+	assert.Equal(t, []string{"127.0.0.1:" + port}, shared.Addresses())
+
+	assert.Nil(t, shared.Stop())
+	assert.Empty(t, shared.Addresses())
+}
+
+func TestSharedListensOnItsBindAddressAlone(t *testing.T) {
+	shared := NewShared("127.0.0.1", utils.FindAvailablePort())
+	assert.Nil(t, shared.Start())
+	defer shared.Stop()
+	assert.Nil(t, shared.Listen(net.ParseIP("127.0.0.2")))
+	assert.Equal(t, []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(int(shared.Port)))}, shared.Addresses())
+	shared.Unlisten(net.ParseIP("127.0.0.2"))
+	assert.Len(t, shared.Addresses(), 1)
+}
+
+func TestValidateSharedConfiguration(t *testing.T) {
+	assert.Nil(t, ValidateSharedConfiguration(&Configuration{}))
+	assert.Nil(t, ValidateSharedConfiguration(&Configuration{ProxyType: "http-connect"}))
+	assert.NotNil(t, ValidateSharedConfiguration(&Configuration{ProxyType: "http-relay"}))
+}
+
+func TestDialUpstreamSocks5(t *testing.T) {
+	proxy := fakeProxy(t, func(conn net.Conn) {
+		greeting := make([]byte, 3)
+		io.ReadFull(conn, greeting)
+		conn.Write([]byte{0x05, 0x02})
+		credentials := make([]byte, 2+len("user")+1+len("secret"))
+		io.ReadFull(conn, credentials)
+		conn.Write([]byte{0x01, 0x00})
+		request := make([]byte, 10)
+		io.ReadFull(conn, request)
+		if net.IP(request[4:8]).String() != "93.184.216.34" || request[8] != 0 || request[9] != 80 {
+			conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x1f, 0x90})
+		conn.Write([]byte("tunneled"))
+	})
+	defer proxy.listener.Close()
+	conn, err := dialUpstream(proxy.configuration("socks5", "user", "secret"), &net.TCPAddr{IP: net.ParseIP("93.184.216.34"), Port: 80}, time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	data := make([]byte, len("tunneled"))
+	_, err = io.ReadFull(conn, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "tunneled", string(data))
+}
+
+func TestDialUpstreamHTTPConnect(t *testing.T) {
+	proxy := fakeProxy(t, func(conn net.Conn) {
+		reader := bufio.NewReader(conn)
+		request, _ := reader.ReadString('\n')
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+		}
+		if !strings.HasPrefix(request, "CONNECT 93.184.216.34:443 ") {
+			conn.Write([]byte("HTTP/1.1 403 Forbidden\r\n\r\n"))
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\nProxy-Agent: test\r\n\r\ntunneled"))
+	})
+	defer proxy.listener.Close()
+	conn, err := dialUpstream(proxy.configuration("http-connect", "", ""), &net.TCPAddr{IP: net.ParseIP("93.184.216.34"), Port: 443}, time.Second)
+	assert.Nil(t, err)
+	defer conn.Close()
+	data := make([]byte, len("tunneled"))
+	_, err = io.ReadFull(conn, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "tunneled", string(data))
+
+	_, err = dialUpstream(proxy.configuration("http-connect", "", ""), &net.TCPAddr{IP: net.ParseIP("93.184.216.34"), Port: 22}, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestHostSniffer(t *testing.T) {
+	//the client hello the TLS client sends first names the server
+	client, server := net.Pipe()
+	go tls.Client(client, &tls.Config{ServerName: "example.com"}).Handshake()
+	hello := make([]byte, sniffedBytes)
+	n, err := server.Read(hello)
+	assert.Nil(t, err)
+	client.Close()
+	sniffer := &hostSniffer{}
+	sniffer.Write(hello[:n/2])
+	sniffer.Write(hello[n/2 : n])
+	assert.Equal(t, "example.com", sniffer.host())
+	assert.Empty(t, serverName(hello[:10]))
+
+	sniffer = &hostSniffer{}
+	sniffer.Write([]byte("GET /index.html HTTP/1.1\r\nUser-Agent: test\r\nHost: www.example.com:8080\r\n\r\n"))
+	assert.Equal(t, "www.example.com:8080", sniffer.host())
+
+	sniffer = &hostSniffer{}
+	sniffer.Write([]byte("SSH-2.0-OpenSSH_7.4\r\n"))
+	sniffer.Write(make([]byte, 2*sniffedBytes))
+	assert.Len(t, sniffer.prefix, sniffedBytes)
+	assert.Empty(t, sniffer.host())
+}
+
+func TestRelayCountsBytes(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	accepted := make(chan *net.TCPConn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn.(*net.TCPConn)
+		}
+	}()
+	dial := func() (*net.TCPConn, *net.TCPConn) {
+		conn, err := net.Dial("tcp4", listener.Addr().String())
+		assert.Nil(t, err)
+		return conn.(*net.TCPConn), <-accepted
+	}
+	client, clientSide := dial()
+	upstream, upstreamSide := dial()
+	defer client.Close()
+	defer upstream.Close()
+
+	go func() {
+		request, _ := ioutil.ReadAll(upstream)
+		upstream.Write([]byte("response to " + string(request)))
+		upstream.CloseWrite()
+	}()
+	go func() {
+		client.Write([]byte("request"))
+		client.CloseWrite()
+	}()
+	sniffer := &hostSniffer{}
+	sent, received := relay(clientSide, upstreamSide, sniffer)
+	response, _ := ioutil.ReadAll(client)
+	assert.Equal(t, "response to request", string(response))
+	assert.Equal(t, int64(len("request")), sent)
+	assert.Equal(t, int64(len("response to request")), received)
+	assert.Equal(t, "request", string(sniffer.prefix))
+}
+
+type testProxy struct {
+	address  *net.TCPAddr
+	listener net.Listener
+}
+
+func (p testProxy) configuration(proxyType string, user string, password string) *Configuration {
+	return &Configuration{
+		ProxyAddress:  p.address.IP.String(),
+		ProxyPort:     int64(p.address.Port),
+		ProxyType:     proxyType,
+		ProxyUser:     user,
+		ProxyPassword: password,
+	}
+}
+
+//fakeProxy serves a given handler on a local port for the duration of a test
+func fakeProxy(t *testing.T, handler func(conn net.Conn)) testProxy {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handler(conn)
+				//the client reads what was sent before the connection is closed
+				time.Sleep(100 * time.Millisecond)
+			}()
+		}
+	}()
+	address, _ := net.ResolveTCPAddr("tcp4", listener.Addr().String())
+	return testProxy{address: address, listener: listener}
+}
@@ -0,0 +1,211 @@
+package tunnel
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+//soOriginalDst the netfilter socket option returning the destination of a redirected connection
+const soOriginalDst = 80
+
+//SharedProxyTypes the proxy types supported by the shared tunnel
+var SharedProxyTypes = []string{"socks4", "socks5", "http-connect"}
+
+//ValidateSharedConfiguration checks a configuration can be tunneled through the shared tunnel
+func ValidateSharedConfiguration(configuration *Configuration) error {
+	proxyType := proxyTypeOf(configuration)
+	for _, supported := range SharedProxyTypes {
+		if proxyType == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("proxy type '%s' isn't supported by the shared tunnel (available choices : %s)", proxyType, strings.Join(SharedProxyTypes, ", "))
+}
+
+func proxyTypeOf(configuration *Configuration) string {
+	if configuration.ProxyType == "" {
+		return "socks5"
+	}
+	return configuration.ProxyType
+}
+
+//originalDestination returns the destination a connection had before being redirected to the shared tunnel
+func originalDestination(conn *net.TCPConn) (*net.TCPAddr, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var address *syscall.IPv6Mreq
+	var sockoptErr error
+	err = raw.Control(func(fd uintptr) {
+		//the sockaddr_in is returned in a structure of the right size
+		address, sockoptErr = syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockoptErr != nil {
+		return nil, sockoptErr
+	}
+	return &net.TCPAddr{
+		IP:   net.IPv4(address.Multiaddr[4], address.Multiaddr[5], address.Multiaddr[6], address.Multiaddr[7]),
+		Port: int(binary.BigEndian.Uint16(address.Multiaddr[2:4])),
+	}, nil
+}
+
+//dialUpstream connects to a destination through the upstream proxy of a configuration
+func dialUpstream(configuration *Configuration, destination *net.TCPAddr, timeout time.Duration) (net.Conn, error) {
+	proxy := net.JoinHostPort(configuration.ProxyAddress, strconv.Itoa(int(configuration.ProxyPort)))
+	conn, err := net.DialTimeout("tcp", proxy, timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	switch proxyTypeOf(configuration) {
+	case "socks4":
+		err = socks4Connect(conn, configuration, destination)
+	case "http-connect":
+		err = httpConnect(conn, configuration, destination)
+	default:
+		err = socks5Connect(conn, configuration, destination)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy '%s' : %v", proxy, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socks5Connect(conn net.Conn, configuration *Configuration, destination *net.TCPAddr) error {
+	methods := []byte{0x00}
+	if configuration.ProxyUser != "" {
+		methods = []byte{0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != methods[0] {
+		return fmt.Errorf("socks5 authentication method refused")
+	}
+	if methods[0] == 0x02 {
+		user, password := configuration.ProxyUser, configuration.ProxyPassword
+		if len(user) > 255 || len(password) > 255 {
+			return fmt.Errorf("socks5 credentials too long")
+		}
+		request := append([]byte{0x01, byte(len(user))}, user...)
+		request = append(append(request, byte(len(password))), password...)
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("socks5 authentication failed")
+		}
+	}
+	request := append([]byte{0x05, 0x01, 0x00, 0x01}, destination.IP.To4()...)
+	request = append(request, byte(destination.Port>>8), byte(destination.Port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("socks5 connection refused with code %d", header[1])
+	}
+	//the bound address is skipped
+	var length int
+	switch header[3] {
+	case 0x01:
+		length = net.IPv4len
+	case 0x04:
+		length = net.IPv6len
+	case 0x03:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return err
+		}
+		length = int(size[0])
+	default:
+		return fmt.Errorf("invalid socks5 address type %d", header[3])
+	}
+	_, err := io.ReadFull(conn, make([]byte, length+2))
+	return err
+}
+
+func socks4Connect(conn net.Conn, configuration *Configuration, destination *net.TCPAddr) error {
+	request := []byte{0x04, 0x01, byte(destination.Port >> 8), byte(destination.Port)}
+	request = append(append(request, destination.IP.To4()...), configuration.ProxyUser...)
+	if _, err := conn.Write(append(request, 0x00)); err != nil {
+		return err
+	}
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0x5a {
+		return fmt.Errorf("socks4 connection refused with code %d", reply[1])
+	}
+	return nil
+}
+
+func httpConnect(conn net.Conn, configuration *Configuration, destination *net.TCPAddr) error {
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", destination, destination)
+	if configuration.ProxyUser != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(configuration.ProxyUser + ":" + configuration.ProxyPassword))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := io.WriteString(conn, request+"\r\n"); err != nil {
+		return err
+	}
+	//the response is read byte by byte so that no tunneled data is consumed
+	status, err := readLine(conn)
+	if err != nil {
+		return err
+	}
+	for {
+		header, err := readLine(conn)
+		if err != nil {
+			return err
+		}
+		if header == "" {
+			break
+		}
+	}
+	fields := strings.Fields(status)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") || !strings.HasPrefix(fields[1], "2") {
+		return fmt.Errorf("http-connect refused with status '%s'", status)
+	}
+	return nil
+}
+
+//readLine reads a CRLF terminated line one byte at a time
+func readLine(reader io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < 8192 {
+		if _, err := io.ReadFull(reader, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", fmt.Errorf("http-connect response line too long")
+}
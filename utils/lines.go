@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"bytes"
+	"sync"
+)
+
+//LineWriter an io.Writer handing every complete line written to it to a callback, without its line terminator
+type LineWriter struct {
+	callback func(line string)
+	buffer   bytes.Buffer
+	sync.Mutex
+}
+
+//NewLineWriter creates a line writer invoking a given callback for every line
+func NewLineWriter(callback func(line string)) *LineWriter {
+	return &LineWriter{callback: callback}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	w.buffer.Write(p)
+	for {
+		index := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if index < 0 {
+			return len(p), nil
+		}
+		line := string(bytes.TrimRight(w.buffer.Next(index+1), "\r\n"))
+		w.callback(line)
+	}
+}
@@ -0,0 +1,15 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	writer := NewLineWriter(func(line string) { lines = append(lines, line) })
+	writer.Write([]byte("first\r\nsec"))
+	assert.Equal(t, []string{"first"}, lines)
+	writer.Write([]byte("ond\n\nthird"))
+	assert.Equal(t, []string{"first", "second", ""}, lines)
+}
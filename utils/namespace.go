@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"os"
+	"strings"
+)
+
+var namespace = strings.TrimSpace(os.Getenv("DRIVER_NAMESPACE"))
+
+//Namespace returns the driver namespace, empty if the driver isn't namespaced
+func Namespace() string {
+	return namespace
+}
+
+//SetNamespace sets the driver namespace, it has to be set before the driver is created
+func SetNamespace(value string) {
+	namespace = strings.TrimSpace(value)
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "soxy-audit")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "network.jsonl")
+
+	file, err := NewRotatingFile(path, 10, 2)
+	assert.Nil(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = file.Write([]byte(line))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, file.Close())
+
+	for suffix, expected := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		content, err := ioutil.ReadFile(path + suffix)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, string(content))
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	//RuntimeDirEnv the environment variable overriding the driver runtime directory
+	RuntimeDirEnv     = "SOXY_RUNTIME_DIR"
+	defaultRuntimeDir = "/run/soxy-driver"
+)
+
+//RuntimeDir returns the driver private runtime directory (where generated configurations are written), creating it if needed
+func RuntimeDir() (string, error) {
+	dir := os.Getenv(RuntimeDirEnv)
+	if dir == "" {
+		dir = defaultRuntimeDir
+	}
+	if namespace := Namespace(); namespace != "" {
+		dir = filepath.Join(dir, namespace)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, os.Chmod(dir, 0700)
+}
+
+//CreateRuntimeFile creates a file only readable by the driver in its runtime directory
+func CreateRuntimeFile(prefix string) (*os.File, error) {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return nil, err
+	}
+	file, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err = file.Chmod(0600); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+//pidFileSuffix the suffix of the files recording the processes the driver started
+const pidFileSuffix = ".pid"
+
+//WritePidFile records a process the driver started in its runtime directory, along with its start time so that a
+//process reusing its pid isn't mistaken for it
+func WritePidFile(pid int) error {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+	startTime, err := processStartTime("/proc", pid)
+	if err != nil {
+		return err
+	}
+	content := strconv.Itoa(pid) + " " + startTime + "\n"
+	return ioutil.WriteFile(filepath.Join(dir, strconv.Itoa(pid)+pidFileSuffix), []byte(content), 0600)
+}
+
+//RemovePidFile forgets a process the driver started, once it exited
+func RemovePidFile(pid int) {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return
+	}
+	if err = os.Remove(filepath.Join(dir, strconv.Itoa(pid)+pidFileSuffix)); err != nil && !os.IsNotExist(err) {
+		logrus.Debugf("couldn't remove the pid file of process %d : %v", pid, err)
+	}
+}
+
+//CleanRuntimeDir kills the processes a previous driver run recorded and left running, then removes the files of the
+//runtime directory : the sockets are left to the servers listening on them
+func CleanRuntimeDir() error {
+	dir, err := RuntimeDir()
+	if err != nil {
+		return err
+	}
+	for _, pid := range staleProcesses("/proc", dir, os.Getpid()) {
+		logrus.Infof("killing process %d left running by a previous driver run", pid)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			logrus.Errorf("couldn't kill process %d : %v", pid, err)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		logrus.Debugf("removing stale runtime file '%s'", file.Name())
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("couldn't remove stale runtime file '%s' : %v", file.Name(), err)
+		}
+	}
+	return nil
+}
+
+//staleProcesses returns the processes, other than the given one, recorded by the pid files of a given directory and
+//still running since they were recorded : the subdirectories (e.g. the ones of the namespaced instances) don't count
+func staleProcesses(procDir string, dir string, self int) []int {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, file := range files {
+		if !file.Mode().IsRegular() || !strings.HasSuffix(file.Name(), pidFileSuffix) {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(content))
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil || pid <= 0 || pid == self {
+			continue
+		}
+		if startTime, err := processStartTime(procDir, pid); err == nil && startTime == fields[1] {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+//processStartTime returns when a running process started, in clock ticks since the boot, as reported by its stat file
+func processStartTime(procDir string, pid int) (string, error) {
+	stat, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", err
+	}
+	//the command name may hold spaces and parentheses, the fields following it start with the state (3rd field)
+	end := bytes.LastIndexByte(stat, ')')
+	if end < 0 {
+		return "", fmt.Errorf("invalid stat file of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 20 {
+		return "", fmt.Errorf("invalid stat file of process %d", pid)
+	}
+	return fields[19], nil
+}
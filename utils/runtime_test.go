@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaleProcesses(t *testing.T) {
+	procDir, err := ioutil.TempDir("", "soxy-proc")
+	assert.Nil(t, err)
+	defer os.RemoveAll(procDir)
+	runtimeDir, err := ioutil.TempDir("", "soxy-run")
+	assert.Nil(t, err)
+	defer os.RemoveAll(runtimeDir)
+	//the start time is the 22nd field, the command name may hold spaces and parentheses
+	stats := map[string]string{
+		"10": "10 (redsocks) S 1 10 10 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 5000 0 0",
+		"11": "11 (tor (x) y) S 1 11 11 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 6000 0 0",
+		"12": "12 (sleep) S 1 12 12 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 9999 0 0",
+		"13": "13 (soxy-driver) S 1 13 13 0 -1 4194560 0 0 0 0 0 0 0 0 20 0 1 0 100 0 0",
+	}
+	for pid, stat := range stats {
+		assert.Nil(t, os.MkdirAll(filepath.Join(procDir, pid), 0700))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(procDir, pid, "stat"), []byte(stat), 0600))
+	}
+	pidFiles := map[string]string{
+		"10.pid": "10 5000\n",
+		"11.pid": "11 6000\n",
+		//the pid was reused by another process
+		"12.pid": "12 7000\n",
+		//the process exited
+		"14.pid":   "14 8000\n",
+		"13.pid":   "13 100\n",
+		"junk.pid": "soxy\n",
+	}
+	for name, content := range pidFiles {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(runtimeDir, name), []byte(content), 0600))
+	}
+	assert.ElementsMatch(t, []int{10, 11}, staleProcesses(procDir, runtimeDir, 13))
+
+	startTime, err := processStartTime(procDir, 11)
+	assert.Nil(t, err)
+	assert.Equal(t, "6000", startTime)
+}
+
+func TestPidFile(t *testing.T) {
+	runtimeDir, err := ioutil.TempDir("", "soxy-run")
+	assert.Nil(t, err)
+	defer os.RemoveAll(runtimeDir)
+	os.Setenv(RuntimeDirEnv, runtimeDir)
+	defer os.Unsetenv(RuntimeDirEnv)
+
+	assert.Nil(t, WritePidFile(os.Getpid()))
+	assert.ElementsMatch(t, []int{os.Getpid()}, staleProcesses("/proc", runtimeDir, 0))
+	assert.Empty(t, staleProcesses("/proc", runtimeDir, os.Getpid()))
+	RemovePidFile(os.Getpid())
+	assert.Empty(t, staleProcesses("/proc", runtimeDir, 0))
+}
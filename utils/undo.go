@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+//Undo records the side effects of an operation so that they can be reverted, in reverse order, if it fails halfway
+type Undo struct {
+	steps []undoStep
+}
+
+type undoStep struct {
+	description string
+	revert      func() error
+}
+
+//Push records how to revert a side effect that has just been applied
+func (u *Undo) Push(description string, revert func() error) {
+	u.steps = append(u.steps, undoStep{description: description, revert: revert})
+}
+
+//Rollback reverts the recorded side effects in reverse order, a failing step is logged and doesn't stop the rollback
+func (u *Undo) Rollback() {
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		step := u.steps[i]
+		logrus.Debugf("rolling back : %s", step.description)
+		if err := step.revert(); err != nil {
+			logrus.Errorf("couldn't roll back '%s' : %v", step.description, err)
+		}
+	}
+	u.steps = nil
+}
@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUndoRollsBackInReverseOrder(t *testing.T) {
+	var reverted []string
+	undo := &Undo{}
+	for _, step := range []string{"bridge", "rules", "tunnel"} {
+		name := step
+		undo.Push(name, func() error {
+			reverted = append(reverted, name)
+			if name == "rules" {
+				return errors.New("busy")
+			}
+			return nil
+		})
+	}
+	undo.Rollback()
+	assert.Equal(t, []string{"tunnel", "rules", "bridge"}, reverted)
+
+	undo.Rollback()
+	assert.Len(t, reverted, 3)
+}
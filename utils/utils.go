@@ -7,11 +7,16 @@ import (
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"net"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
+var hostnamePattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)*$`)
+
 //LogAndThrowError return an error given an error message
 func LogAndThrowError(message string, params ...interface{}) error {
-	formattedMessage := fmt.Sprintf(message, params)
+	formattedMessage := fmt.Sprintf(message, params...)
 	logrus.Errorf(formattedMessage)
 	return errors.New(formattedMessage)
 }
@@ -38,3 +43,28 @@ func LogIfNotNull(err error) {
 		logrus.Error(err.Error())
 	}
 }
+
+//ResolveWithin returns a given absolute path, links resolved, provided it points within a given directory
+func ResolveWithin(dir string, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("'%s' isn't an absolute path", path)
+	}
+	resolvedDir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("couldn't resolve directory '%s'", dir)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("couldn't resolve '%s'", path)
+	}
+	relative, err := filepath.Rel(resolvedDir, resolved)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("'%s' isn't in directory '%s'", path, dir)
+	}
+	return resolved, nil
+}
+
+//IsHost tells whether a given value is an ip address or a valid hostname
+func IsHost(value string) bool {
+	return net.ParseIP(value) != nil || hostnamePattern.MatchString(value)
+}